@@ -18,12 +18,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path"
 	"syscall"
 
 	"github.com/MangosArentLiterature/Athena/internal/athena"
+	"github.com/MangosArentLiterature/Athena/internal/banlist"
 	"github.com/MangosArentLiterature/Athena/internal/db"
 	"github.com/MangosArentLiterature/Athena/internal/logger"
 	"github.com/MangosArentLiterature/Athena/internal/settings"
@@ -37,6 +39,7 @@ var (
 )
 
 func main() {
+	settings.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 	if *configFlag != "" {
 		settings.ConfigPath = path.Clean(*configFlag)
@@ -46,6 +49,11 @@ func main() {
 		logger.LogFatalf("failed to read config: %v", err)
 		os.Exit(1)
 	}
+	if flag.Arg(0) == "check" {
+		os.Exit(check(config))
+	} else if flag.Arg(0) == "ban" {
+		os.Exit(ban(config, flag.Args()[1:]))
+	}
 	logger.LogPath = path.Clean(config.LogDir)
 	if _, err := os.Stat(logger.LogPath); os.IsNotExist(err) {
 		if err := os.Mkdir(logger.LogPath, 0755); err != nil {
@@ -68,9 +76,13 @@ func main() {
 	logger.LogStdOut = sliceutil.ContainsString(config.LogMethods, "stdout")
 	logger.LogFile = sliceutil.ContainsString(config.LogMethods, "log_file")
 	logger.DebugNetwork = *netDebugFlag
-	db.DBPath = settings.ConfigPath + "/athena.db"
+	store, err := db.OpenSQLite(settings.ConfigPath + "/athena.db")
+	if err != nil {
+		logger.LogFatalf("Failed to open database: %v", err)
+		os.Exit(1)
+	}
 
-	err = athena.InitServer(config)
+	err = athena.InitServer(config, store)
 	if err != nil {
 		logger.LogFatalf("Failed to initalize server: %v", err)
 		athena.CleanupServer()
@@ -81,6 +93,20 @@ func main() {
 	if config.EnableWS {
 		go athena.ListenWS()
 	}
+	if config.EnableWSS {
+		go athena.ListenWSS()
+	}
+	if config.EnableSSH {
+		go athena.ListenSSH()
+	}
+	reload := make(chan (os.Signal), 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			athena.ReloadCertificate()
+			athena.ReloadCommands()
+		}
+	}()
 	if !*cliFlag {
 		go athena.ListenInput()
 	}
@@ -88,11 +114,67 @@ func main() {
 	signal.Notify(stop, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	select {
 	case <-stop:
+		athena.Shutdown(-1, "")
+		select {
+		case <-athena.ShutdownDone:
+		case <-stop: // A second signal skips the shutdown sequence.
+			athena.CleanupServer()
+		}
+	case <-athena.ShutdownDone:
 		break
 	case err := <-athena.FatalError:
 		logger.LogFatal(err.Error())
-		break
+		athena.CleanupServer()
 	}
-	athena.CleanupServer()
+	athena.RestoreTerminal()
 	logger.LogInfo("Stopping server.")
 }
+
+// check prints the server's effective configuration and validates the remaining configuration files, returning an exit code.
+func check(config *settings.Config) int {
+	fmt.Println("# Effective configuration (defaults < config.toml < environment < flags)")
+	if err := config.Redacted().Encode(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode config: %v\n", err)
+		return 1
+	}
+	code := 0
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", name, err)
+			code = 1
+		}
+	}
+	_, err := settings.LoadMusic()
+	report("music.txt", err)
+	_, err = settings.LoadAreas()
+	report("areas.toml", err)
+	_, err = settings.LoadRoles()
+	report("roles.toml", err)
+	_, err = settings.LoadBanLists()
+	report("banlists.toml", err)
+	report("commands.toml", athena.CheckCommands())
+	for _, f := range []string{"/characters.txt", "/backgrounds.txt", "/parrot.txt"} {
+		_, err = settings.LoadFile(f)
+		report(f[1:], err)
+	}
+	if code == 0 {
+		fmt.Println("# All configuration files are valid.")
+	}
+	return code
+}
+
+// ban runs a ban list command against the server's database, and returns the exit code.
+func ban(config *settings.Config, args []string) int {
+	store, err := db.OpenSQLite(settings.ConfigPath + "/athena.db")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	err = banlist.Command(store, args, config.Name, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
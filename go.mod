@@ -6,8 +6,10 @@ require (
 	github.com/BurntSushi/toml v1.2.0
 	github.com/ecnepsnai/discord v1.2.1
 	github.com/xhit/go-str2duration/v2 v2.0.0
+	github.com/yuin/gopher-lua v1.1.1
 	go.uber.org/ratelimit v0.2.0
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa
+	golang.org/x/term v0.0.0-20220722155259-a9ba230a4035
 	modernc.org/sqlite v1.18.0
 	nhooyr.io/websocket v1.8.7
 )
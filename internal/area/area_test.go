@@ -180,3 +180,32 @@ func TestInvited(t *testing.T) {
 		t.Errorf("unexpected value for invited length, got %d, want %d", len(a.invited), 0)
 	}
 }
+
+func TestState(t *testing.T) {
+	a := NewArea(AreaData{Name: "Courtroom"}, 50, 0, EviAny)
+	a.AddEvidence("foo&foo&foo")
+	a.SetDoc("https://example.com/doc")
+	a.TstAppend("title")
+	a.TstAppend("statement")
+	a.SetTstState(TRPlayback)
+
+	// The snapshot is restored into a fresh area.
+	s := a.State()
+	b := NewArea(AreaData{Name: "Courtroom"}, 50, 0, EviAny)
+	b.Restore(s)
+	if len(b.evidence) != 1 || b.evidence[0] != "foo&foo&foo" {
+		t.Errorf("unexpected restored evidence, got %v", b.evidence)
+	}
+	if b.Doc() != "https://example.com/doc" {
+		t.Errorf("unexpected restored doc, got %s", b.Doc())
+	}
+	if b.TstLen() != 2 || b.TstState() != TRIdle {
+		t.Errorf("unexpected restored testimony, got length %d and state %d", b.TstLen(), b.TstState())
+	}
+
+	// Changes to the original area must not affect the snapshot.
+	a.AddEvidence("bar&bar&bar")
+	if len(s.Evidence) != 1 {
+		t.Errorf("snapshot shares evidence with area, got length %d", len(s.Evidence))
+	}
+}
@@ -0,0 +1,49 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package area
+
+// State is a snapshot of the parts of an area that persist across server restarts.
+type State struct {
+	Name      string   `json:"name"`
+	Evidence  []string `json:"evidence"`
+	Doc       string   `json:"doc"`
+	Testimony []string `json:"testimony"`
+}
+
+// State returns a snapshot of the area's evidence, doc, and testimony.
+func (a *Area) State() State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return State{
+		Name:      a.data.Name,
+		Evidence:  append([]string{}, a.evidence...),
+		Doc:       a.doc,
+		Testimony: append([]string{}, a.tr.Testimony...),
+	}
+}
+
+// Restore replaces the area's evidence, doc, and testimony with those from a snapshot.
+// A restored testimony is left idle.
+func (a *Area) Restore(s State) {
+	a.mu.Lock()
+	a.evidence = append([]string{}, s.Evidence...)
+	a.doc = s.Doc
+	a.tr.Testimony = append([]string{}, s.Testimony...)
+	a.tr.Index = 0
+	a.tr.State = TRIdle
+	a.mu.Unlock()
+}
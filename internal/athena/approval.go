@@ -0,0 +1,121 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+// A pendingBan is a ban waiting for a second moderator to approve its full length.
+// Until it is approved, the ban lasts the default ban duration.
+type pendingBan struct {
+	moderator string // The moderator who requested the ban.
+	until     int64  // The requested end of the ban, or -1 for a permanent ban.
+	timer     *time.Timer
+}
+
+var (
+	pendingBans                        = make(map[int]*pendingBan) // Keyed by ban ID.
+	pendingMu                          sync.Mutex
+	approvalThreshold, approvalTimeout time.Duration
+)
+
+// needsApproval returns whether a ban ending at until, placed by the client, needs another moderator's approval.
+func needsApproval(client *Client, until int64) bool {
+	if !config.EnableApproval || until == 0 {
+		return false
+	}
+	defaultLen, _ := str2duration.ParseDuration(config.BanLen)
+	now := time.Now().UTC()
+	if until != -1 && time.Unix(until, 0).Sub(now) <= defaultLen {
+		return false
+	}
+	return client.Junior() || until == -1 || time.Unix(until, 0).Sub(now) > approvalThreshold
+}
+
+// requestApproval records a pending ban, and asks other moderators to approve it.
+// If no moderator does so within the approval timeout, the ban is left as it is.
+func requestApproval(client *Client, id int, until int64) {
+	p := &pendingBan{moderator: client.ModName(), until: until}
+	p.timer = time.AfterFunc(approvalTimeout, func() {
+		if takePendingBan(id) != nil {
+			logger.LogInfof("Ban %v was not approved in time, and will not be extended.", id)
+		}
+	})
+	pendingMu.Lock()
+	if old, ok := pendingBans[id]; ok {
+		old.timer.Stop()
+	}
+	pendingBans[id] = p
+	pendingMu.Unlock()
+
+	msg := fmt.Sprintf("%v has requested that ban %v last until %v. Use /approveban %v or /denyban %v within %v.",
+		client.ModName(), id, banUntil(until), id, id, approvalTimeout)
+	for c := range clients.GetAllClients() {
+		if c != client && c.Authenticated() && c.Perms().Has(permBan) {
+			c.SendServerMessage(msg)
+		}
+	}
+}
+
+// takePendingBan removes and returns the pending ban with the given ID, or nil if there is none.
+func takePendingBan(id int) *pendingBan {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	p, ok := pendingBans[id]
+	if !ok {
+		return nil
+	}
+	p.timer.Stop()
+	delete(pendingBans, id)
+	return p
+}
+
+// reviewPendingBan checks that the client may approve or deny a pending ban, and removes it if so.
+func reviewPendingBan(client *Client, arg string) (int, *pendingBan) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		client.SendServerMessage("Invalid ban ID.")
+		return 0, nil
+	}
+	if client.Junior() {
+		client.SendServerMessage("Your role cannot review bans.")
+		return 0, nil
+	}
+	pendingMu.Lock()
+	p, ok := pendingBans[id]
+	pendingMu.Unlock()
+	if !ok {
+		client.SendServerMessage("That ban is not awaiting approval.")
+		return 0, nil
+	}
+	if p.moderator == client.ModName() {
+		client.SendServerMessage("You cannot review your own ban.")
+		return 0, nil
+	}
+	if takePendingBan(id) == nil {
+		client.SendServerMessage("That ban is not awaiting approval.")
+		return 0, nil
+	}
+	return id, p
+}
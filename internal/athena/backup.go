@@ -0,0 +1,66 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/backup"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+)
+
+var backupMu sync.Mutex // Prevents scheduled and on-demand backups from running at the same time.
+
+// runBackup backs up the server's database and athena.secret into the backup directory, and prunes old backups.
+func runBackup() (string, error) {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	return backup.Run(store, backupDir(), settings.ConfigPath+"/athena.secret", config.KeepDaily, config.KeepWeekly)
+}
+
+// backupDir returns the backup directory, resolving a relative path against the config directory.
+func backupDir() string {
+	if filepath.IsAbs(config.BackupDir) {
+		return config.BackupDir
+	}
+	return filepath.Join(settings.ConfigPath, config.BackupDir)
+}
+
+// scheduleBackups backs up the server's database every interval until the server shuts down.
+func scheduleBackups(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if ShuttingDown() {
+				return
+			}
+			path, err := runBackup()
+			if err != nil {
+				logger.LogErrorf("Scheduled backup failed: %v", err)
+				continue
+			}
+			logger.LogInfof("Backed up database to %v.", path)
+		case <-ShutdownDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/banlist"
+	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+var banLists []settings.BanList // The shared ban lists the server subscribes to.
+
+// subscribeBanLists starts periodically fetching each of the given ban lists, and deletes the bans of any other list.
+func subscribeBanLists(lists []settings.BanList) error {
+	intervals := make([]time.Duration, len(lists))
+	for i, l := range lists {
+		interval, err := str2duration.ParseDuration(l.Interval)
+		if err != nil {
+			return fmt.Errorf("failed to parse refresh_interval of ban list %v: %v", l.Name, err)
+		} else if interval <= 0 {
+			return fmt.Errorf("refresh_interval of ban list %v must be positive", l.Name)
+		}
+		intervals[i] = interval
+	}
+	banLists = lists
+	// Bans from lists that have been removed from banlists.toml would otherwise still apply, with no way to see
+	// or disable them.
+	stored, err := store.BanLists()
+	if err != nil {
+		return fmt.Errorf("failed to read ban lists: %v", err)
+	}
+	for _, l := range stored {
+		if _, ok := getBanList(l.Name); ok {
+			continue
+		}
+		err = store.RemoveBanList(l.Name)
+		if err != nil {
+			return fmt.Errorf("failed to remove ban list %v: %v", l.Name, err)
+		}
+		logger.LogInfof("Removed ban list %v and its %v bans, as it is no longer in banlists.toml.", l.Name, l.Bans)
+	}
+	for i, l := range lists {
+		go watchBanList(l, intervals[i])
+	}
+	return nil
+}
+
+// watchBanList fetches a ban list every interval until the server shuts down.
+func watchBanList(l settings.BanList, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := refreshBanList(l); err != nil {
+			logger.LogWarningf("Failed to fetch ban list %v, keeping previously fetched bans: %v", l.Name, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ShutdownDone:
+			return
+		}
+	}
+}
+
+// refreshBanList fetches a ban list, replacing its stored bans, and returns the number of malformed bans that were skipped.
+// If the list cannot be fetched or read, the previously fetched bans are kept.
+func refreshBanList(l settings.BanList) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	list, err := banlist.Fetch(ctx, l.Source)
+	if err != nil {
+		return 0, err
+	}
+	bans := make([]db.BanInfo, 0, len(list.Bans))
+	var skipped int
+	for _, b := range list.Bans {
+		if len(b.Areas) > 0 {
+			continue // Area names are specific to the server that issued the ban.
+		}
+		info, err := b.BanInfo()
+		if err != nil {
+			logger.LogDebugf("Skipping ban from ban list %v: %v", l.Name, err)
+			skipped++
+			continue
+		}
+		bans = append(bans, info)
+	}
+	err = store.ReplaceSharedBans(l.Name, bans)
+	if err != nil {
+		return 0, err
+	}
+	if skipped > 0 {
+		logger.LogWarningf("Skipped %v malformed bans in ban list %v.", skipped, l.Name)
+	}
+	logger.LogDebugf("Fetched %v bans from ban list %v.", len(bans), l.Name)
+	return skipped, nil
+}
+
+// getBanList returns the subscribed ban list with the given name.
+func getBanList(name string) (settings.BanList, bool) {
+	for _, l := range banLists {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return settings.BanList{}, false
+}
@@ -0,0 +1,121 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+)
+
+func TestSharedBanList(t *testing.T) {
+	setupTestServer(t)
+	banned, bannedConn := newTestClient(0, "192.0.2.5:1234")
+	other, otherConn := newTestClient(1, "192.0.2.6:1234")
+
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"server": "Sister Server", "bans": [{"id": 12, "ipid": %q, "hdid": "x", "start": "2022-04-15T05:20:00Z", "expiry": "perma", "reason": "ban evasion", "moderator": "m"},
+			{"id": 13, "ipid": "y", "start": "not a time", "expiry": "perma"}]}`,
+			banned.Ipid())
+	}))
+	defer srv.Close()
+	l := settings.BanList{Name: "Sister Server", Source: srv.URL + "/bans.json"}
+	banLists = []settings.BanList{l}
+	defer func() { banLists = nil }()
+
+	if skipped, err := refreshBanList(l); err != nil || skipped != 1 {
+		t.Fatalf("refreshBanList skipped %v bans, returned %v", skipped, err)
+	}
+	banned.CheckBanned(db.IPID)
+	if out := bannedConn.Output(); !strings.Contains(out, "BD#ban evasion") || !strings.Contains(out, "Ban list: Sister Server") {
+		t.Errorf("client banned by list was not told which list banned them, got %q", out)
+	}
+	other.CheckBanned(db.IPID)
+	if out := otherConn.Output(); out != "" || otherConn.closed {
+		t.Errorf("unbanned client was disconnected, got %q", out)
+	}
+	if bans, _ := store.GetBan(db.IPID, banned.Ipid()); len(bans) != 0 {
+		t.Errorf("shared ban was added to local bans")
+	}
+
+	// Failed fetches keep the previously fetched bans.
+	failing.Store(true)
+	if _, err := refreshBanList(l); err == nil {
+		t.Errorf("expected error from failing list")
+	}
+	banned.CheckBanned(db.IPID)
+	if !strings.Contains(bannedConn.Output(), "BD#") {
+		t.Errorf("bans were lost after a failed fetch")
+	}
+
+	store.SetBanListEnabled(l.Name, false)
+	bannedConn.closed = false
+	banned.CheckBanned(db.IPID)
+	if bannedConn.Output() != "" || bannedConn.closed {
+		t.Errorf("disabled list banned client")
+	}
+}
+
+func TestRemovedBanList(t *testing.T) {
+	setupTestServer(t)
+	c, conn := newTestClient(0, "192.0.2.5:1234")
+	store.ReplaceSharedBans("Old Server", []db.BanInfo{{Id: 1, Ipid: c.Ipid(), Duration: -1, Reason: "old ban"}})
+	c.CheckBanned(db.IPID)
+	if !conn.closed {
+		t.Fatalf("client banned by list was not disconnected")
+	}
+
+	// The list is no longer configured.
+	if err := subscribeBanLists(nil); err != nil {
+		t.Fatal(err)
+	}
+	conn.closed = false
+	conn.Output()
+	c.CheckBanned(db.IPID)
+	if conn.closed {
+		t.Errorf("ban from a list that is no longer configured still applies")
+	}
+	if lists, _ := store.BanLists(); len(lists) != 0 {
+		t.Errorf("removed list is still stored: %+v", lists)
+	}
+}
+
+func TestUnreachableBanList(t *testing.T) {
+	setupTestServer(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	l := settings.BanList{Name: "Gone", Source: srv.URL + "/bans.json"}
+	if _, err := refreshBanList(l); err == nil {
+		t.Errorf("expected error from unreachable list")
+	}
+	c, conn := newTestClient(0, "192.0.2.5:1234")
+	c.CheckBanned(db.IPID)
+	if conn.closed {
+		t.Errorf("client was disconnected because a ban list was unreachable")
+	}
+}
@@ -18,80 +18,287 @@ package athena
 
 import (
 	"bufio"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
-	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/area"
+	"github.com/MangosArentLiterature/Athena/internal/banlist"
 	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
+	"github.com/MangosArentLiterature/Athena/internal/totp"
+	"golang.org/x/term"
 )
 
-// ListenInput listens for input on stdin, parsing any commands.
-func ListenInput() {
-	input := bufio.NewScanner(os.Stdin)
-	for input.Scan() {
-		cmd := strings.Split(input.Text(), " ")
-		switch cmd[0] {
-		case "help":
-			logger.LogInfo("Recognized commands: help, mkusr, rmusr, players, getlog, say.")
-		case "mkusr":
-			if len(cmd) < 4 {
-				logger.LogInfo("Not enough arguments for command mkusr. Usage: mkusr <username> <password> <role>.")
-				break
-			}
-			if db.UserExists(cmd[1]) {
-				logger.LogInfo("User already exists.")
-				return
+// consoleCommands are the commands only available from the console, in addition to every command in Commands.
+var consoleCommands = []string{"getlog", "say", "totp", "ban export", "ban import"}
+
+var (
+	restoreTerminal func() // Restores stdin's terminal mode, if the console changed it.
+	restoreMu       sync.Mutex
+)
+
+// consoleConn is the connection of the console's pseudo-client.
+// Server messages sent to it are written to out, and other packets are discarded.
+type consoleConn struct {
+	net.Conn
+	out io.Writer
+}
+
+func (c *consoleConn) Write(b []byte) (int, error) {
+	for _, p := range strings.Split(string(b), "%") {
+		fields := strings.Split(p, "#")
+		if fields[0] == "CT" && len(fields) > 2 {
+			fmt.Fprintln(c.out, decode(fields[2]))
+		}
+	}
+	return len(b), nil
+}
+
+func (c *consoleConn) Close() error {
+	return nil
+}
+
+// isConsole returns whether the client is a console's pseudo-client, either the server's own or an SSH session's.
+func (client *Client) isConsole() bool {
+	_, ok := client.conn.(*consoleConn)
+	return ok
+}
+
+// newConsoleClient returns the pseudo-client that runs commands typed into a console. It has every permission,
+// and isn't in the client list, so commands never target it. Only the local console, read from stdin, may use
+// console-only commands.
+func newConsoleClient(out io.Writer, a *area.Area, local bool) *Client {
+	return &Client{
+		conn:          &consoleConn{out: out},
+		uid:           -1,
+		char:          -1,
+		pair:          ClientPairInfo{wanted_id: -1},
+		ipid:          "console",
+		oocName:       "Console",
+		mod_name:      "console",
+		authenticated: true,
+		perms:         permissions.NewSet(permissions.Admin),
+		area:          a,
+		localConsole:  local,
+	}
+}
+
+// splitArgs splits a console line into arguments. Arguments are separated by spaces, unless the spaces are
+// inside double or single quotes, or escaped with a backslash.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
 			}
-			user := cmd[1]
-			pass := cmd[2]
-			role, err := getRole(cmd[3])
-			if err != nil {
-				logger.LogInfo("Invalid role.")
-				break
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
 			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	} else if escaped {
+		return nil, fmt.Errorf("line ends with an escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// completeCommand completes the command name being typed on a console line when tab is pressed.
+func completeCommand(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) || strings.Contains(line, " ") {
+		return "", 0, false
+	}
+	var matches []string
+	commandsMu.RLock()
+	for name := range Commands {
+		if strings.HasPrefix(name, line) {
+			matches = append(matches, name)
+		}
+	}
+	commandsMu.RUnlock()
+	names := append([]string{"help"}, consoleCommands...)
+	for name := range scriptCommands() {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		name, _, _ = strings.Cut(name, " ")
+		if strings.HasPrefix(name, line) && !sliceutil.ContainsString(matches, name) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+	if len(matches) == 1 {
+		return matches[0] + " ", len(matches[0]) + 1, true
+	}
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix, len(prefix), true
+}
 
-			err = db.CreateUser(user, []byte(pass), role.GetPermissions())
+// runConsoleCommand runs a line typed into a console as the given client, writing any output to out.
+func runConsoleCommand(console *Client, line string, out io.Writer) {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Fprintf(out, "Invalid command: %v.\n", err)
+		return
+	} else if len(args) == 0 {
+		return
+	}
+	cmd, args := strings.TrimPrefix(args[0], "/"), args[1:]
+	if !console.localConsole {
+		// Console-only commands bypass permission checks and can read and write files, so remote consoles may not use them.
+		ParseCommand(console, cmd, args)
+		return
+	}
+	switch cmd {
+	case "help":
+		ParseCommand(console, "help", args)
+		fmt.Fprintf(out, "Console-only commands: %v.\n", strings.Join(consoleCommands, ", "))
+	case "totp":
+		if len(args) < 2 || (args[0] != "enroll" && args[0] != "disable") {
+			fmt.Fprintln(out, "Not enough arguments for command totp. Usage: totp enroll|disable <username>.")
+			break
+		}
+		if !store.UserExists(args[1]) {
+			fmt.Fprintln(out, "User does not exist.")
+			break
+		}
+		if args[0] == "disable" {
+			err := store.SetTOTPSecret(args[1], "")
 			if err != nil {
-				logger.LogInfof("Failed to create user: %v.", err.Error())
+				fmt.Fprintf(out, "Failed to disable two-factor authentication: %v.\n", err.Error())
 				break
 			}
-			logger.LogInfof("Sucessfully created user %v.", user)
-		case "rmusr":
-			if len(cmd) < 2 {
-				logger.LogInfo("Not enough arguments for command rmusr. Usage: rmusr <username>.")
-				break
-			}
-			if !db.UserExists(cmd[1]) {
-				logger.LogInfo("User does not exist.")
-			}
-			err := db.RemoveUser(cmd[1])
-			if err != nil {
-				logger.LogInfof("Failed to remove user: %v.", err.Error())
-				break
+			logger.LogInfof("Disabled two-factor authentication for %v.", args[1])
+			break
+		}
+		secret, err := totp.NewSecret()
+		if err == nil {
+			err = store.SetTOTPSecret(args[1], secret)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "Failed to enroll user: %v.\n", err.Error())
+			break
+		}
+		logger.LogInfof("Enrolled %v in two-factor authentication.", args[1])
+		// The secret is printed rather than logged, so that it is not written to the log file.
+		fmt.Fprintf(out, "Add this secret to an authenticator app: %v\nOr import this URI: %v\n", secret, totp.URI(config.Name, args[1], secret))
+	case "getlog":
+		if len(args) < 1 {
+			fmt.Fprintln(out, "Not enough arguments for command getlog. Usage: getlog <area>.")
+			break
+		}
+		name := strings.Join(args, " ")
+		for _, a := range areas {
+			if strings.EqualFold(a.Name(), name) {
+				fmt.Fprintln(out, strings.Join(a.Buffer(), "\n"))
 			}
-			logger.LogInfof("Sucessfully removed user %v.", cmd[1])
-		case "players":
-			logger.LogInfof("There are currently %v/%v players online.", players.GetPlayerCount(), config.MaxPlayers)
-		case "getlog":
-			if len(cmd) < 2 {
-				logger.LogInfo("Not enough arguments for command getlog. Usage: getlog <area>.")
+		}
+	case "say":
+		if len(args) < 1 {
+			fmt.Fprintln(out, "Not enough arguments for command say. Usage: say <message>.")
+			break
+		}
+		msg := strings.Join(args, " ")
+		for c := range clients.GetAllClients() {
+			c.SendServerMessage(msg)
+		}
+	case "ban":
+		if len(args) > 0 && (args[0] == "export" || args[0] == "import") {
+			var res strings.Builder
+			if err := banlist.Command(store, args, config.Name, &res); err != nil {
+				fmt.Fprintln(out, err.Error())
 				break
 			}
-			for _, a := range areas {
-				if a.Name() == cmd[1] {
-					logger.LogInfo(strings.Join(a.Buffer(), "\n"))
+			fmt.Fprint(out, res.String())
+			break
+		}
+		ParseCommand(console, cmd, args)
+	default:
+		ParseCommand(console, cmd, args)
+	}
+}
+
+// ListenInput runs commands typed on stdin as the console client. When stdin is a terminal, lines can be
+// edited, previous lines recalled with the arrow keys, and command names completed with tab.
+func ListenInput() {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) && term.IsTerminal(int(os.Stdout.Fd())) {
+		state, err := term.MakeRaw(fd)
+		if err == nil {
+			restoreMu.Lock()
+			restoreTerminal = func() { term.Restore(fd, state) }
+			restoreMu.Unlock()
+			t := term.NewTerminal(struct {
+				io.Reader
+				io.Writer
+			}{os.Stdin, os.Stdout}, "> ")
+			t.AutoCompleteCallback = completeCommand
+			logger.SetOutput(t)
+			console := newConsoleClient(t, areas[0], true)
+			for {
+				line, err := t.ReadLine()
+				if err != nil && err != term.ErrPasteIndicator {
+					// The terminal no longer sends signals, so Ctrl+C and Ctrl+D end up here.
+					RestoreTerminal()
+					Shutdown(-1, "")
+					return
 				}
+				runConsoleCommand(console, line, t)
 			}
-		case "say":
-			if len(cmd) < 2 {
-				logger.LogInfo("Not enough arguments for command say. Usage: say <message>.")
-				break
-			}
-			for c := range clients.GetAllClients() {
-				c.SendServerMessage(cmd[1])
-			}
-		default:
-			logger.LogInfo("Unrecognized command")
 		}
 	}
+	console := newConsoleClient(os.Stdout, areas[0], true)
+	input := bufio.NewScanner(os.Stdin)
+	for input.Scan() {
+		runConsoleCommand(console, input.Text(), os.Stdout)
+	}
+}
+
+// RestoreTerminal restores stdin's terminal to the mode it was in before the console started.
+func RestoreTerminal() {
+	restoreMu.Lock()
+	defer restoreMu.Unlock()
+	if restoreTerminal != nil {
+		restoreTerminal()
+		restoreTerminal = nil
+		logger.SetOutput(os.Stdout)
+	}
 }
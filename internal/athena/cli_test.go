@@ -0,0 +1,119 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/area"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  say  hello   world ", []string{"say", "hello", "world"}},
+		{`mkusr "new mod" 'pass word' moderator`, []string{"mkusr", "new mod", "pass word", "moderator"}},
+		{`say it\'s "a \"quoted\" word"`, []string{"say", "it's", `a "quoted" word`}},
+		{`say '\' ""`, []string{"say", `\`, ""}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q", tt.line, got, err, tt.want)
+		}
+	}
+	for _, line := range []string{`say "hello`, `say 'hello`, `say hello\`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("splitArgs(%q) did not fail", line)
+		}
+	}
+}
+
+func TestConsole(t *testing.T) {
+	setupTestServer(t)
+	var out strings.Builder
+	console := newConsoleClient(&out, areas[0], true)
+	_, conn := newTestClient(0, "192.0.2.1:1234")
+
+	runConsoleCommand(console, "say hello there, world", &out)
+	if !strings.Contains(conn.Output(), "hello there, world") {
+		t.Errorf("say did not send the whole message, got %q", conn.Output())
+	}
+
+	runConsoleCommand(console, `mkusr "new mod" password moderator`, &out)
+	if !store.UserExists("new mod") {
+		t.Errorf("mkusr did not create the user, got %q", out.String())
+	}
+	if out.String() != "User created.\n" {
+		t.Errorf("command output was not written to the console, got %q", out.String())
+	}
+
+	out.Reset()
+	runConsoleCommand(console, "/kick -u @all spam", &out)
+	if !conn.closed || !strings.Contains(out.String(), "Kicked 1 clients.") {
+		t.Errorf("console did not kick only the client, got %q", out.String())
+	}
+
+	out.Reset()
+	runConsoleCommand(console, `say "hello`, &out)
+	if !strings.Contains(out.String(), "Invalid command") {
+		t.Errorf("unterminated quote was not reported, got %q", out.String())
+	}
+}
+
+func TestConsoleMove(t *testing.T) {
+	setupTestServer(t)
+	var out strings.Builder
+	console := newConsoleClient(&out, areas[0], true)
+	c, _ := newTestClient(0, "192.0.2.1:1234")
+	c.JoinArea(areas[0])
+	areas[0].SetLock(area.LockLocked)
+	defer areas[0].SetLock(area.LockFree)
+
+	// Moving the console must not change either area's player count, or reset the area it leaves.
+	runConsoleCommand(console, "move 1", &out)
+	if console.Area() != areas[1] {
+		t.Errorf("console was not moved, got %q", out.String())
+	}
+	if n := areas[0].PlayerCount(); n != 1 || areas[0].Lock() != area.LockLocked {
+		t.Errorf("area 0 has %v players and lock %v after the console left, want 1 player and still locked", n, areas[0].Lock())
+	}
+	if n := areas[1].PlayerCount(); n != 0 {
+		t.Errorf("area 1 has %v players after the console joined, want 0", n)
+	}
+}
+
+func TestCompleteCommand(t *testing.T) {
+	setupTestServer(t)
+	if line, pos, ok := completeCommand("unm", 3, '\t'); !ok || line != "unmute " || pos != 7 {
+		t.Errorf("completeCommand(unm) = %q, %v, %v", line, pos, ok)
+	}
+	if line, _, ok := completeCommand("un", 2, '\t'); !ok || line != "un" {
+		t.Errorf("completeCommand(un) = %q, %v", line, ok)
+	}
+	if line, _, ok := completeCommand("lockb", 5, '\t'); !ok || line != "lockbg " {
+		t.Errorf("completeCommand(lockb) = %q, %v", line, ok)
+	}
+	if _, _, ok := completeCommand("kick -", 6, '\t'); ok {
+		t.Errorf("completed an argument")
+	}
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/MangosArentLiterature/Athena/internal/area"
 	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/events"
 	"github.com/MangosArentLiterature/Athena/internal/logger"
 	"github.com/MangosArentLiterature/Athena/internal/packet"
 	"github.com/MangosArentLiterature/Athena/internal/permissions"
@@ -65,28 +66,39 @@ type Client struct {
 	area          *area.Area
 	char          int
 	ipid          string
+	legacyIpid    string
+	legacyHdid    string
 	oocName       string
 	lastmsg       string
-	perms         uint64
+	perms         permissions.Set
+	areaPerms     map[string]permissions.Set
+	invocation    invocation  // The command the client is running.
+	pendingCmd    *invocation // A mass action waiting for /confirm.
 	authenticated bool
 	mod_name      string
+	role          string
 	pos           string
 	case_prefs    [5]bool
 	muted         MuteState
 	muteuntil     time.Time
 	showname      string
 	narrator      bool
+	localConsole  bool // Whether the client is the server's own console, which alone may use console-only commands.
 }
 
-// NewClient returns a new client.
-func NewClient(conn net.Conn, ipid string) *Client {
-	return &Client{
+// NewClient returns a new client connecting from the given address.
+func NewClient(conn net.Conn, addr string) *Client {
+	client := &Client{
 		conn: conn,
 		uid:  -1,
 		char: -1,
 		pair: ClientPairInfo{wanted_id: -1},
-		ipid: ipid,
+		ipid: getIpid(addr),
 	}
+	if config.LegacyLookup {
+		client.legacyIpid = getLegacyIpid(addr)
+	}
+	return client
 }
 
 // handleClient handles a client connection to the server.
@@ -169,6 +181,7 @@ func (client *Client) SendPacket(header string, contents ...string) {
 func (client *Client) clientCleanup() {
 	if client.Uid() != -1 {
 		logger.LogInfof("Client (IPID:%v UID:%v) left the server", client.ipid, client.Uid())
+		bus.Publish(events.ClientLeft{Player: player(client)})
 
 		if client.Area().PlayerCount() <= 1 {
 			client.Area().Reset()
@@ -186,9 +199,7 @@ func (client *Client) clientCleanup() {
 		}
 		uids.ReleaseUid(client.Uid())
 		players.RemovePlayer()
-		if config.Advertise {
-			updatePlayers <- players.GetPlayerCount()
-		}
+		updateAdvertiser()
 		client.Area().RemoveChar(client.CharID())
 		sendPlayerArup()
 	}
@@ -310,19 +321,76 @@ func (client *Client) SetLastMsg(msg string) {
 }
 
 // Perms returns the client's current permissions.
-func (client *Client) Perms() uint64 {
+func (client *Client) Perms() permissions.Set {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	return client.perms
 }
 
 // SetPerms sets the client's permissionss.
-func (client *Client) SetPerms(perms uint64) {
+func (client *Client) SetPerms(perms permissions.Set) {
 	client.mu.Lock()
 	client.perms = perms
 	client.mu.Unlock()
 }
 
+// SetAreaPerms sets the permissions the client has been granted in individual areas, keyed by area name.
+func (client *Client) SetAreaPerms(perms map[string]permissions.Set) {
+	client.mu.Lock()
+	client.areaPerms = perms
+	client.mu.Unlock()
+}
+
+// PermsIn returns the client's permissions in an area, including those granted to it in that area only.
+func (client *Client) PermsIn(a *area.Area) permissions.Set {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if a == nil {
+		return client.perms
+	}
+	return client.perms.Union(client.areaPerms[a.Name()])
+}
+
+// HasPermission returns whether the client has a permission in its current area.
+func (client *Client) HasPermission(perm permissions.Permission) bool {
+	return client.HasPermissionIn(client.Area(), perm)
+}
+
+// HasPermissionIn returns whether the client has a permission in the given area.
+func (client *Client) HasPermissionIn(a *area.Area, perm permissions.Permission) bool {
+	return client.PermsIn(a).Has(perm)
+}
+
+// Invocation returns the command the client is running.
+func (client *Client) Invocation() invocation {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.invocation
+}
+
+// SetInvocation sets the command the client is running.
+func (client *Client) SetInvocation(inv invocation) {
+	client.mu.Lock()
+	client.invocation = inv
+	client.mu.Unlock()
+}
+
+// SetPendingCommand sets the mass action waiting for the client to /confirm it.
+func (client *Client) SetPendingCommand(inv *invocation) {
+	client.mu.Lock()
+	client.pendingCmd = inv
+	client.mu.Unlock()
+}
+
+// TakePendingCommand removes and returns the mass action waiting for the client to /confirm it, or nil if there is none.
+func (client *Client) TakePendingCommand() *invocation {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	inv := client.pendingCmd
+	client.pendingCmd = nil
+	return inv
+}
+
 // Authenticated returns whether the client is logged in as a moderator.
 func (client *Client) Authenticated() bool {
 	client.mu.Lock()
@@ -351,6 +419,26 @@ func (client *Client) SetModName(name string) {
 	client.mu.Unlock()
 }
 
+// Role returns the name of the client's moderator role.
+func (client *Client) Role() string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.role
+}
+
+// SetRole sets the name of the client's moderator role.
+func (client *Client) SetRole(role string) {
+	client.mu.Lock()
+	client.role = role
+	client.mu.Unlock()
+}
+
+// Junior returns whether the client is logged in with a junior role.
+func (client *Client) Junior() bool {
+	role, err := getRole(client.Role())
+	return err == nil && role.Junior
+}
+
 // Pos returns the client's current position.
 func (client *Client) Pos() string {
 	client.mu.Lock()
@@ -417,7 +505,7 @@ func (client *Client) SetPairWantedID(id int) {
 // RemoveAuth logs a client out as moderator.
 func (client *Client) RemoveAuth() {
 	client.mu.Lock()
-	client.authenticated, client.perms, client.mod_name = false, 0, ""
+	client.authenticated, client.perms, client.areaPerms, client.mod_name, client.role = false, permissions.Set{}, nil, "", ""
 	client.mu.Unlock()
 	client.SendServerMessage("Logged out as moderator.")
 	client.SendPacket("AUTH", "-1")
@@ -425,30 +513,43 @@ func (client *Client) RemoveAuth() {
 
 // CheckBanned returns if a client is currently banned.
 func (client *Client) CheckBanned(by db.BanLookup) {
-	var banned bool
-	var baninfo db.BanInfo
-	var err error
+	var value, legacy, kind string
 	switch by {
 	case db.IPID:
-		banned, baninfo, err = db.IsBanned(by, client.Ipid())
+		value, legacy, kind = client.Ipid(), client.legacyIpid, "IP"
+	case db.HDID:
+		value, legacy, kind = client.Hdid(), client.legacyHdid, "HDID"
+	default:
+		return
+	}
+	if value == "" {
+		return
+	}
+
+	if legacy != "" {
+		err := store.RekeyBans(by, legacy, value)
 		if err != nil {
-			logger.LogErrorf("Error reading IP ban for %v: %v", client.Ipid(), err)
+			logger.LogErrorf("Error migrating legacy %v bans for %v: %v", kind, client.Ipid(), err)
 		}
-	case db.HDID:
-		banned, baninfo, err = db.IsBanned(by, client.Hdid())
+	}
+	banned, baninfo, err := store.IsBanned(by, value)
+	if err != nil {
+		logger.LogErrorf("Error reading %v ban for %v: %v", kind, client.Ipid(), err)
+	}
+	if !banned {
+		// Shared ban lists are only consulted locally, so an unreachable list never delays a join.
+		banned, baninfo, err = store.IsSharedBanned(by, value)
 		if err != nil {
-			logger.LogErrorf("Error reading HDID ban for %v: %v", client.Ipid(), err)
+			logger.LogErrorf("Error reading shared %v ban for %v: %v", kind, client.Ipid(), err)
 		}
 	}
 
 	if banned {
-		var duration string
-		if baninfo.Duration == -1 {
-			duration = "∞"
+		if baninfo.Source != "" {
+			client.SendPacket("BD", fmt.Sprintf("%v\nUntil: %v\nBan list: %v", baninfo.Reason, banUntil(baninfo.Duration), baninfo.Source))
 		} else {
-			duration = time.Unix(baninfo.Duration, 0).UTC().Format("02 Jan 2006 15:04 MST")
+			client.SendPacket("BD", fmt.Sprintf("%v\nUntil: %v\nID: %v", baninfo.Reason, banUntil(baninfo.Duration), baninfo.Id))
 		}
-		client.SendPacket("BD", fmt.Sprintf("%v\nUntil: %v\nID: %v", baninfo.Reason, duration, baninfo.Id))
 		client.conn.Close()
 		return
 	}
@@ -467,14 +568,35 @@ func (client *Client) JoinArea(area *area.Area) {
 	sendPlayerArup()
 }
 
-// ChangeArea changes the client's current area.
-func (client *Client) ChangeArea(a *area.Area) bool {
+// CanJoinArea returns whether the client may enter an area, and if not, a message explaining why.
+func (client *Client) CanJoinArea(a *area.Area) (bool, string) {
 	if a.Lock() == area.LockLocked &&
 		!sliceutil.ContainsInt(a.Invited(), client.Uid()) &&
-		!permissions.HasPermission(client.Perms(), permissions.PermissionField["BYPASS_LOCK"]) {
-		return false
+		!client.HasPermissionIn(a, permBypassLock) {
+		return false, "You are not invited to that area."
+	}
+	banned, info, err := store.IsAreaBanned(client.Ipid(), client.Hdid(), a.Name())
+	if err != nil {
+		logger.LogErrorf("Error reading area ban for %v: %v", client.Ipid(), err)
+	}
+	if banned {
+		return false, fmt.Sprintf("You are banned from %v.\n%v\nUntil: %v\nID: %v", a.Name(), info.Reason, banUntil(info.Duration), info.Id)
+	}
+	return true, ""
+}
+
+// ChangeArea changes the client's current area, returning whether it could, and if not, a message explaining why.
+func (client *Client) ChangeArea(a *area.Area) (bool, string) {
+	if ok, reason := client.CanJoinArea(a); !ok {
+		return false, reason
+	}
+	// A console is never counted among an area's players, so moving it only changes the area its commands act on.
+	if client.isConsole() {
+		client.SetArea(a)
+		return true, ""
 	}
 	addToBuffer(client, "AREA", "Left area.", false)
+	from := client.Area().Name()
 	if client.Area().PlayerCount() <= 1 {
 		client.Area().Reset()
 		sendLockArup()
@@ -495,12 +617,28 @@ func (client *Client) ChangeArea(a *area.Area) bool {
 		writeToArea(a, "CharsCheck", a.Taken()...)
 	}
 	addToBuffer(client, "AREA", "Joined area.", false)
-	return true
+	bus.Publish(events.AreaChanged{Player: player(client), From: from})
+	return true, ""
+}
+
+// leaveArea moves the client out of its current area, to the first area it may join, returning whether it could.
+// If there is no such area, the client is left where it is.
+func (client *Client) leaveArea() bool {
+	current := client.Area()
+	for _, a := range areas {
+		if a == current {
+			continue
+		}
+		if ok, _ := client.ChangeArea(a); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // HasCMPermission returns whether the client has CM permissions in it's area.
 func (client *Client) HasCMPermission() bool {
-	if client.Area().HasCM(client.Uid()) || permissions.HasPermission(client.Perms(), permissions.PermissionField["CM"]) {
+	if client.Area().HasCM(client.Uid()) || client.HasPermission(permCM) {
 		return true
 	} else {
 		return false
@@ -513,7 +651,7 @@ func (client *Client) CanSpeakIC() bool {
 	case client.CharID() == -1:
 		return false
 	case client.Area().Lock() == area.LockSpectatable && !sliceutil.ContainsInt(client.area.Invited(), client.Uid()) &&
-		!permissions.HasPermission(client.Perms(), permissions.PermissionField["BYPASS_LOCK"]):
+		!client.HasPermission(permBypassLock):
 		return false
 	case client.Muted() == ICMuted || client.Muted() == ICOOCMuted:
 		return client.CheckUnmute()
@@ -537,7 +675,7 @@ func (client *Client) CanChangeMusic() bool {
 	case client.Area().LockMusic() && !client.HasCMPermission():
 		return false
 	case client.Area().Lock() == area.LockSpectatable && !sliceutil.ContainsInt(client.area.Invited(), client.Uid()) &&
-		!permissions.HasPermission(client.Perms(), permissions.PermissionField["BYPASS_LOCK"]):
+		!client.HasPermission(permBypassLock):
 		return false
 	case client.Muted() == MusicMuted || client.Muted() == ICMuted || client.Muted() == ICOOCMuted:
 		return client.CheckUnmute()
@@ -551,7 +689,7 @@ func (client *Client) CanJud() bool {
 	case client.CharID() == -1:
 		return false
 	case client.Area().Lock() == area.LockSpectatable && !sliceutil.ContainsInt(client.area.Invited(), client.Uid()) &&
-		!permissions.HasPermission(client.Perms(), permissions.PermissionField["BYPASS_LOCK"]):
+		!client.HasPermission(permBypassLock):
 		return false
 	case client.Muted() == JudMuted || client.Muted() == ICMuted || client.Muted() == ICOOCMuted:
 		return client.CheckUnmute()
@@ -601,7 +739,7 @@ func (client *Client) CanAlterEvidence() bool {
 	}
 	switch client.Area().EvidenceMode() {
 	case area.EviMods:
-		if !permissions.HasPermission(client.Perms(), permissions.PermissionField["MOD_EVI"]) {
+		if !client.HasPermission(permModEvi) {
 			return false
 		}
 	case area.EviCMs:
@@ -29,7 +29,9 @@ import (
 	"time"
 
 	"github.com/MangosArentLiterature/Athena/internal/area"
+	"github.com/MangosArentLiterature/Athena/internal/banlist"
 	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/events"
 	"github.com/MangosArentLiterature/Athena/internal/logger"
 	"github.com/MangosArentLiterature/Athena/internal/permissions"
 	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
@@ -37,369 +39,548 @@ import (
 )
 
 type Command struct {
-	handler  func(client *Client, args []string, usage string)
-	minArgs  int
-	usage    string
-	desc     string
-	reqPerms uint64
+	handler     func(client *Client, args []string, usage string)
+	minArgs     int
+	usage       string
+	desc        string
+	reqPerm     permissions.Permission
+	areaScoped  bool           // Whether a role granted in the client's current area is enough to use the command.
+	checksPerms bool           // Whether the handler checks other permissions itself, so that the command's permission cannot be changed.
+	sensitive   bool           // Whether the command's arguments, such as passwords, must be kept out of events.
+	limits      *commandLimits // How often the command can be used, or nil for no limit.
 }
 
 var Commands map[string]Command
 
+// The number of bans shown per page by /getban.
+const banPageSize = 5
+
 func initCommands() {
-	Commands = map[string]Command{
+	builtinCommands = map[string]Command{
 		"about": {
-			handler:  cmdAbout,
-			minArgs:  0,
-			usage:    "Usage: /about",
-			desc:     "Prints Athena version information.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdAbout,
+			minArgs: 0,
+			usage:   "Usage: /about",
+			desc:    "Prints Athena version information.",
+			reqPerm: permissions.None,
 		},
 		"allowcms": {
-			handler:  cmdAllowCMs,
-			minArgs:  1,
-			usage:    "Usage: /allowcms <true|false>",
-			desc:     "Toggles allowing CMs on or off.",
-			reqPerms: permissions.PermissionField["MODIFY_AREA"],
+			handler:    cmdAllowCMs,
+			minArgs:    1,
+			usage:      "Usage: /allowcms <true|false>",
+			desc:       "Toggles allowing CMs on or off.",
+			reqPerm:    permModifyArea,
+			areaScoped: true,
 		},
 		"allowiniswap": {
-			handler:  cmdAllowIniswap,
-			minArgs:  1,
-			usage:    "Usage: /allowiniswap <true|false>",
-			desc:     "Toggles iniswapping on or off.",
-			reqPerms: permissions.PermissionField["MODIFY_AREA"],
+			handler:    cmdAllowIniswap,
+			minArgs:    1,
+			usage:      "Usage: /allowiniswap <true|false>",
+			desc:       "Toggles iniswapping on or off.",
+			reqPerm:    permModifyArea,
+			areaScoped: true,
+		},
+		"approveban": {
+			handler: cmdApproveBan,
+			minArgs: 1,
+			usage:   "Usage: /approveban <id>",
+			desc:    "Approves another moderator's pending ban, extending it to its full length.",
+			reqPerm: permBan,
 		},
 		"areainfo": {
-			handler:  cmdAreaInfo,
-			minArgs:  0,
-			usage:    "Usage: /areainfo",
-			desc:     "Prints area settings.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdAreaInfo,
+			minArgs: 0,
+			usage:   "Usage: /areainfo",
+			desc:    "Prints area settings.",
+			reqPerm: permissions.None,
+		},
+		"arearole": {
+			handler: cmdAreaRole,
+			minArgs: 2,
+			usage:   "Usage: /arearole grant|revoke <username> <role> <area1>,<area2>... | /arearole list <username>",
+			desc:    "Grants or revokes a user's role in specific areas.",
+			reqPerm: permissions.Admin,
+		},
+		"backup": {
+			handler: cmdBackup,
+			minArgs: 0,
+			usage:   "Usage: /backup",
+			desc:    "Backs up the server's database.",
+			reqPerm: permissions.Admin,
 		},
 		"ban": {
-			handler:  cmdBan,
-			minArgs:  3,
-			usage:    "Usage: /ban -u <uid1>,<uid2>... | -i <ipid1>,<ipid2>... [-d duration] <reason>",
-			desc:     "Bans user(s) from the server.",
-			reqPerms: permissions.PermissionField["BAN"],
+			handler: cmdBan,
+			minArgs: 3,
+			usage:   "Usage: /ban -u <target1>,<target2>... | -i <ipid1>,<ipid2>... | -ipid <ipid1>,<ipid2>... | -hdid <hdid1>,<hdid2>... [-only ipid|hdid] [-a <area1>,<area2>...] [-d duration] <reason>",
+			desc:    "Bans user(s) from the server, or from specific areas.",
+			reqPerm: permBan,
+		},
+		"banexport": {
+			handler: cmdBanExport,
+			minArgs: 0,
+			usage:   "Usage: /banexport [json|csv]",
+			desc:    "Exports the server's bans to a file.",
+			reqPerm: permissions.Admin,
+		},
+		"banlist": {
+			handler: cmdBanList,
+			minArgs: 0,
+			usage:   "Usage: /banlist [enable|disable|refresh <name>]",
+			desc:    "Shows or manages subscribed ban lists.",
+			reqPerm: permissions.Admin,
+		},
+		"banstats": {
+			handler: cmdBanStats,
+			minArgs: 0,
+			usage:   "Usage: /banstats",
+			desc:    "Shows bans per moderator and per week, and the most common ban reasons.",
+			reqPerm: permBanInfo,
 		},
 		"bg": {
-			handler:  cmdBg,
-			minArgs:  1,
-			usage:    "Usage: /bg <background>",
-			desc:     "Sets the area's background.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:     cmdBg,
+			minArgs:     1,
+			usage:       "Usage: /bg <background>",
+			desc:        "Sets the area's background.",
+			reqPerm:     permCM,
+			areaScoped:  true,
+			checksPerms: true,
 		},
 		"charselect": {
-			handler:  cmdCharSelect,
-			minArgs:  0,
-			usage:    "Usage: /charselect [uid1],[uid2]...",
-			desc:     "Return to character select.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdCharSelect,
+			minArgs: 0,
+			usage:   "Usage: /charselect [target1],[target2]...",
+			desc:    "Return to character select.",
+			reqPerm: permissions.None,
 		},
 		"cm": {
-			handler:  cmdCM,
-			minArgs:  0,
-			usage:    "Usage: /cm [uid1],[uid2]...",
-			desc:     "Promote to area CM.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdCM,
+			minArgs: 0,
+			usage:   "Usage: /cm [target1],[target2]...",
+			desc:    "Promote to area CM.",
+			reqPerm: permissions.None,
+		},
+		"confirm": {
+			handler: cmdConfirm,
+			minArgs: 0,
+			usage:   "Usage: /confirm",
+			desc:    "Confirms a command that affects many users.",
+			reqPerm: permissions.None,
+		},
+		"denyban": {
+			handler: cmdDenyBan,
+			minArgs: 1,
+			usage:   "Usage: /denyban <id>",
+			desc:    "Denies another moderator's pending ban, leaving it at the default length.",
+			reqPerm: permBan,
 		},
 		"doc": {
-			handler:  cmdDoc,
-			minArgs:  0,
-			usage:    "Usage: /doc [-c] [doc]\n-c: Clear the doc.",
-			desc:     "Prints or sets the area's document.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdDoc,
+			minArgs: 0,
+			usage:   "Usage: /doc [-c] [doc]\n-c: Clear the doc.",
+			desc:    "Prints or sets the area's document.",
+			reqPerm: permissions.None,
 		},
 		"editban": {
-			handler:  cmdEditBan,
-			minArgs:  2,
-			usage:    "Usage: /editban [-d duration] [-r reason] <id1>,<id2>...",
-			desc:     "Changes the reason of ban(s).",
-			reqPerms: permissions.PermissionField["BAN"],
+			handler: cmdEditBan,
+			minArgs: 2,
+			usage:   "Usage: /editban [-d duration] [-r reason] <id1>,<id2>...",
+			desc:    "Changes the reason of ban(s).",
+			reqPerm: permBan,
 		},
 		"evimode": {
-			handler:  cmdSetEviMod,
-			minArgs:  1,
-			usage:    "Usage: /evimode <mode>",
-			desc:     "Sets the area's evidence mode.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:     cmdSetEviMod,
+			minArgs:     1,
+			usage:       "Usage: /evimode <mode>",
+			desc:        "Sets the area's evidence mode.",
+			reqPerm:     permCM,
+			areaScoped:  true,
+			checksPerms: true,
 		},
 		"forcebglist": {
-			handler:  cmdForceBGList,
-			minArgs:  1,
-			usage:    "Usage: /forcebglist <true|false>",
-			desc:     "Toggles enforcing the server BG list on or off.",
-			reqPerms: permissions.PermissionField["MODIFY_AREA"],
+			handler:    cmdForceBGList,
+			minArgs:    1,
+			usage:      "Usage: /forcebglist <true|false>",
+			desc:       "Toggles enforcing the server BG list on or off.",
+			reqPerm:    permModifyArea,
+			areaScoped: true,
 		},
 		"getban": {
-			handler:  cmdGetBan,
-			minArgs:  0,
-			usage:    "Usage: /getban [-b banid | -i ipid]",
-			desc:     "Prints ban(s) matching the search parameters, or prints the 5 most recent bans.",
-			reqPerms: permissions.PermissionField["BAN_INFO"],
+			handler: cmdGetBan,
+			minArgs: 0,
+			usage:   "Usage: /getban [-b banid] [-i ipid] [-hdid hdid] [-m moderator] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-active | -expired] [-p page] [reason]",
+			desc:    "Searches bans, showing the most recent first. Reasons are matched by substring.",
+			reqPerm: permBanInfo,
 		},
 		"global": {
-			handler:  cmdGlobal,
-			minArgs:  1,
-			usage:    "Usage: /global <message>",
-			desc:     "Sends a global message.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdGlobal,
+			minArgs: 1,
+			usage:   "Usage: /global <message>",
+			desc:    "Sends a global message.",
+			reqPerm: permissions.None,
+			limits:  newCommandLimits(5*time.Second, 0, 0),
 		},
 		"invite": {
-			handler:  cmdInvite,
-			minArgs:  1,
-			usage:    "Usage: /invite <uid1>,<uid2>...",
-			desc:     "Invites user(s) to the current area.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdInvite,
+			minArgs:    1,
+			usage:      "Usage: /invite <target1>,<target2>...",
+			desc:       "Invites user(s) to the current area.",
+			reqPerm:    permCM,
+			areaScoped: true,
+			limits:     newCommandLimits(time.Second, 0, 0),
 		},
 		"kick": {
-			handler:  cmdKick,
-			minArgs:  3,
-			usage:    "Usage: /kick -u <uid1>,<uid2>... | -i <ipid1>,<ipid2>... <reason>",
-			desc:     "Kicks user(s) from the server.",
-			reqPerms: permissions.PermissionField["KICK"],
+			handler:    cmdKick,
+			minArgs:    3,
+			usage:      "Usage: /kick -u <target1>,<target2>... | -i <ipid1>,<ipid2>... <reason>",
+			desc:       "Kicks user(s) from the server.",
+			reqPerm:    permKick,
+			areaScoped: true,
 		},
 		"kickarea": {
-			handler:  cmdAreaKick,
-			minArgs:  1,
-			usage:    "Usage: /kickarea <uid1>,<uid2>...",
-			desc:     "Kicks user(s) from the current area.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdAreaKick,
+			minArgs:    1,
+			usage:      "Usage: /kickarea <target1>,<target2>...",
+			desc:       "Kicks user(s) from the current area.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"lock": {
-			handler:  cmdLock,
-			minArgs:  0,
-			usage:    "Usage: /lock [-s]\n-s: Sets the area to be spectatable.",
-			desc:     "Locks the current area or sets it to spectatable.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdLock,
+			minArgs:    0,
+			usage:      "Usage: /lock [-s]\n-s: Sets the area to be spectatable.",
+			desc:       "Locks the current area or sets it to spectatable.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"lockbg": {
-			handler:  cmdLockBG,
-			minArgs:  1,
-			usage:    "Usage: /lockbg <true|false>",
-			desc:     "Toggles locking the BG on or off.",
-			reqPerms: permissions.PermissionField["MODIFY_AREA"],
+			handler:    cmdLockBG,
+			minArgs:    1,
+			usage:      "Usage: /lockbg <true|false>",
+			desc:       "Toggles locking the BG on or off.",
+			reqPerm:    permModifyArea,
+			areaScoped: true,
 		},
 		"lockmusic": {
-			handler:  cmdLockMusic,
-			minArgs:  1,
-			usage:    "Usage: /lockmusic <true|false>",
-			desc:     "Toggles CM only music on or off.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdLockMusic,
+			minArgs:    1,
+			usage:      "Usage: /lockmusic <true|false>",
+			desc:       "Toggles CM only music on or off.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"log": {
-			handler:  cmdLog,
-			minArgs:  1,
-			usage:    "Usage: /log <area>",
-			desc:     "Prints an area's log buffer.",
-			reqPerms: permissions.PermissionField["LOG"],
+			handler:    cmdLog,
+			minArgs:    1,
+			usage:      "Usage: /log <area>",
+			desc:       "Prints an area's log buffer.",
+			reqPerm:    permLog,
+			areaScoped: true,
 		},
 		"login": {
-			handler:  cmdLogin,
-			minArgs:  2,
-			usage:    "Usage: /login <username> <password>",
-			desc:     "Logs in as moderator.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler:   cmdLogin,
+			minArgs:   2,
+			usage:     "Usage: /login <username> <password> [code]",
+			desc:      "Logs in as moderator.",
+			reqPerm:   permissions.None,
+			sensitive: true,
 		},
 		"logout": {
-			handler:  cmdLogout,
-			minArgs:  0,
-			usage:    "Usage: /logout",
-			desc:     "Logs out as moderator.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdLogout,
+			minArgs: 0,
+			usage:   "Usage: /logout",
+			desc:    "Logs out as moderator.",
+			reqPerm: permissions.None,
 		},
 		"mkusr": {
-			handler:  cmdMakeUser,
-			minArgs:  3,
-			usage:    "Usage: /mkusr <username> <password> <role>",
-			desc:     "Creates a new moderator user.",
-			reqPerms: permissions.PermissionField["ADMIN"],
+			handler:   cmdMakeUser,
+			minArgs:   3,
+			usage:     "Usage: /mkusr <username> <password> <role>",
+			desc:      "Creates a new moderator user.",
+			reqPerm:   permissions.Admin,
+			sensitive: true,
 		},
 		"mod": {
-			handler:  cmdMod,
-			minArgs:  1,
-			usage:    "Usage: /mod [-g] <message>\n-g: Send the message globally.",
-			desc:     "Sends a message speaking officially as a moderator.",
-			reqPerms: permissions.PermissionField["MOD_SPEAK"],
+			handler: cmdMod,
+			minArgs: 1,
+			usage:   "Usage: /mod [-g] <message>\n-g: Send the message globally.",
+			desc:    "Sends a message speaking officially as a moderator.",
+			reqPerm: permModSpeak,
 		},
 		"modchat": {
-			handler:  cmdModChat,
-			minArgs:  1,
-			usage:    "Usage: /modchat <message>",
-			desc:     "Sends a message to other moderators.",
-			reqPerms: permissions.PermissionField["MOD_CHAT"],
+			handler: cmdModChat,
+			minArgs: 1,
+			usage:   "Usage: /modchat <message>",
+			desc:    "Sends a message to other moderators.",
+			reqPerm: permModChat,
 		},
 		"motd": {
-			handler:  cmdMotd,
-			minArgs:  0,
-			usage:    "Usage /motd",
-			desc:     "Sends the server's message of the day.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdMotd,
+			minArgs: 0,
+			usage:   "Usage /motd",
+			desc:    "Sends the server's message of the day.",
+			reqPerm: permissions.None,
 		},
 		"move": {
-			handler:  cmdMove,
-			minArgs:  1,
-			usage:    "Usage: /move [-u <uid1,<uid2>...] <area>",
-			desc:     "Moves to an area.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdMove,
+			minArgs: 1,
+			usage:   "Usage: /move [-u <target1>,<target2>...] <area>",
+			desc:    "Moves to an area.",
+			reqPerm: permissions.None,
 		},
 		"mute": {
-			handler:  cmdMute,
-			minArgs:  1,
-			usage:    "Usage: /mute [-ic][-ooc][-m][-j][-d duration][-r reason] <uid1>,<uid2>...\n-ic: Mute IC.\n-ooc: Mute OOC.\n-m: Mute music.\n-j: Mute judge.",
-			desc:     "Mutes users(s) from IC, OOC, changing music, and/or judge controls.",
-			reqPerms: permissions.PermissionField["MUTE"],
+			handler:    cmdMute,
+			minArgs:    1,
+			usage:      "Usage: /mute [-ic][-ooc][-m][-j][-d duration][-r reason] <target1>,<target2>...\n-ic: Mute IC.\n-ooc: Mute OOC.\n-m: Mute music.\n-j: Mute judge.",
+			desc:       "Mutes users(s) from IC, OOC, changing music, and/or judge controls.",
+			reqPerm:    permMute,
+			areaScoped: true,
 		},
 		"narrator": {
-			handler:  cmdNarrator,
-			minArgs:  0,
-			usage:    "Usage: /narrator",
-			desc:     "Toggles narrator mode on or off.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdNarrator,
+			minArgs: 0,
+			usage:   "Usage: /narrator",
+			desc:    "Toggles narrator mode on or off.",
+			reqPerm: permissions.None,
 		},
 		"nointpres": {
-			handler:  cmdNoIntPres,
-			minArgs:  1,
-			usage:    "Usage: /nointpres <true|false>",
-			desc:     "Toggles non-interrupting preanims in the current area on or off.",
-			reqPerms: permissions.PermissionField["MODIFY_AREA"],
+			handler:    cmdNoIntPres,
+			minArgs:    1,
+			usage:      "Usage: /nointpres <true|false>",
+			desc:       "Toggles non-interrupting preanims in the current area on or off.",
+			reqPerm:    permModifyArea,
+			areaScoped: true,
 		},
 		"parrot": {
-			handler:  cmdParrot,
-			minArgs:  1,
-			usage:    "Usage: /parrot [-d duration][-r reason] <uid1>,<uid2>...",
-			desc:     "Parrots user(s).",
-			reqPerms: permissions.PermissionField["MUTE"],
+			handler:    cmdParrot,
+			minArgs:    1,
+			usage:      "Usage: /parrot [-d duration][-r reason] <target1>,<target2>...",
+			desc:       "Parrots user(s).",
+			reqPerm:    permMute,
+			areaScoped: true,
+		},
+		"passwd": {
+			handler:   cmdPasswd,
+			minArgs:   2,
+			usage:     "Usage: /passwd <old password> <new password>",
+			desc:      "Changes your moderator password.",
+			reqPerm:   permissions.None,
+			sensitive: true,
 		},
 		"play": {
-			handler:  cmdPlay,
-			minArgs:  1,
-			usage:    "Usage: /play <song>",
-			desc:     "Plays a song.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:     cmdPlay,
+			minArgs:     1,
+			usage:       "Usage: /play <song>",
+			desc:        "Plays a song.",
+			reqPerm:     permCM,
+			areaScoped:  true,
+			checksPerms: true,
 		},
 		"players": {
-			handler:  cmdPlayers,
-			minArgs:  0,
-			usage:    "Usage: /players [-a]\n-a: Target all areas.",
-			desc:     "Shows players in the current or all areas.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdPlayers,
+			minArgs: 0,
+			usage:   "Usage: /players [-a]\n-a: Target all areas.",
+			desc:    "Shows players in the current or all areas.",
+			reqPerm: permissions.None,
 		},
 		"pm": {
-			handler:  cmdPM,
-			minArgs:  2,
-			usage:    "Usage: /pm <uid1>,<uid2>... <message>",
-			desc:     "Sends a private message.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdPM,
+			minArgs: 2,
+			usage:   "Usage: /pm <target1>,<target2>... <message>",
+			desc:    "Sends a private message.",
+			reqPerm: permissions.None,
+			limits:  newCommandLimits(time.Second, 0, 0),
+		},
+		"reloadcommands": {
+			handler: cmdReloadCommands,
+			minArgs: 0,
+			usage:   "Usage: /reloadcommands",
+			desc:    "Reloads custom commands, aliases and command settings from commands.toml.",
+			reqPerm: permissions.Admin,
 		},
 		"rmusr": {
-			handler:  cmdRemoveUser,
-			minArgs:  1,
-			usage:    "Usage: /rmusr <username>",
-			desc:     "Removes a moderator user.",
-			reqPerms: permissions.PermissionField["ADMIN"],
+			handler: cmdRemoveUser,
+			minArgs: 1,
+			usage:   "Usage: /rmusr <username>",
+			desc:    "Removes a moderator user.",
+			reqPerm: permissions.Admin,
+		},
+		"roles": {
+			handler: cmdRoles,
+			minArgs: 0,
+			usage:   "Usage: /roles",
+			desc:    "Lists the moderator roles and their permissions.",
+			reqPerm: permissions.None,
 		},
 		"roll": {
-			handler:  cmdRoll,
-			minArgs:  1,
-			usage:    "Usage: /roll [-p] <dice>d<sides>\n-p: Sets the roll to be private.",
-			desc:     "Rolls dice.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdRoll,
+			minArgs: 1,
+			usage:   "Usage: /roll [-p] <dice>d<sides>\n-p: Sets the roll to be private.",
+			desc:    "Rolls dice.",
+			reqPerm: permissions.None,
+			limits:  newCommandLimits(2*time.Second, 0, 0),
+		},
+		"script": {
+			handler: cmdScript,
+			minArgs: 1,
+			usage:   "Usage: /script list|load <name>|unload <name>\nlist: Lists the loaded scripts and their commands.\nload: Loads or reloads a script.\nunload: Unloads a script.",
+			desc:    "Manages Lua scripts.",
+			reqPerm: permissions.Admin,
 		},
 		"setrole": {
-			handler:  cmdChangeRole,
-			minArgs:  2,
-			usage:    "Usage: /setrole <username> <role>",
-			desc:     "Changes a moderator user's role.",
-			reqPerms: permissions.PermissionField["ADMIN"],
+			handler: cmdChangeRole,
+			minArgs: 2,
+			usage:   "Usage: /setrole <username> <role>",
+			desc:    "Changes a moderator user's role.",
+			reqPerm: permissions.Admin,
+		},
+		"shutdown": {
+			handler: cmdShutdown,
+			minArgs: 0,
+			usage:   "Usage: /shutdown [delay] [message]",
+			desc:    "Shuts down the server after warning players.",
+			reqPerm: permissions.Admin,
 		},
 		"status": {
-			handler:  cmdStatus,
-			minArgs:  1,
-			usage:    "Usage: /status <status>",
-			desc:     "Sets the current area's status.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdStatus,
+			minArgs:    1,
+			usage:      "Usage: /status <status>",
+			desc:       "Sets the current area's status.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"swapevi": {
-			handler:  cmdSwapEvi,
-			minArgs:  2,
-			usage:    "Usage: /swapevi <id1> <id2>",
-			desc:     "Swaps index of evidence.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdSwapEvi,
+			minArgs: 2,
+			usage:   "Usage: /swapevi <id1> <id2>",
+			desc:    "Swaps index of evidence.",
+			reqPerm: permissions.None,
 		},
 		"testimony": {
-			handler:  cmdTestimony,
-			minArgs:  0,
-			usage:    "Usage /testimony <record|stop|play|update|insert|delete>",
-			desc:     "Updates the current area's testimony recorder, or prints current testimony.",
-			reqPerms: permissions.PermissionField["NONE"],
+			handler: cmdTestimony,
+			minArgs: 0,
+			usage:   "Usage /testimony <record|stop|play|update|insert|delete>",
+			desc:    "Updates the current area's testimony recorder, or prints current testimony.",
+			reqPerm: permissions.None,
 		},
 		"unban": {
-			handler:  cmdUnban,
-			minArgs:  1,
-			usage:    "Usage: /unban <id1>,<id2>...",
-			desc:     "Nullifies ban(s).",
-			reqPerms: permissions.PermissionField["BAN"],
+			handler: cmdUnban,
+			minArgs: 1,
+			usage:   "Usage: /unban <id1>,<id2>...",
+			desc:    "Nullifies ban(s).",
+			reqPerm: permBan,
 		},
 		"uncm": {
-			handler:  cmdUnCM,
-			minArgs:  0,
-			usage:    "Usage: /uncm [uid1],[uid2]...",
-			desc:     "Removes CM(s) from the current area.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdUnCM,
+			minArgs:    0,
+			usage:      "Usage: /uncm [target1],[target2]...",
+			desc:       "Removes CM(s) from the current area.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"uninvite": {
-			handler:  cmdUninvite,
-			minArgs:  1,
-			usage:    "Usage: /uninvite <uid1>,<uid2>...",
-			desc:     "Uninvites user(s) from the current area.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdUninvite,
+			minArgs:    1,
+			usage:      "Usage: /uninvite <target1>,<target2>...",
+			desc:       "Uninvites user(s) from the current area.",
+			reqPerm:    permCM,
+			areaScoped: true,
+			limits:     newCommandLimits(time.Second, 0, 0),
 		},
 		"unlock": {
-			handler:  cmdUnlock,
-			minArgs:  0,
-			usage:    "Usage: /unlock",
-			desc:     "Unlocks the current area.",
-			reqPerms: permissions.PermissionField["CM"],
+			handler:    cmdUnlock,
+			minArgs:    0,
+			usage:      "Usage: /unlock",
+			desc:       "Unlocks the current area.",
+			reqPerm:    permCM,
+			areaScoped: true,
 		},
 		"unmute": {
-			handler:  cmdUnmute,
-			minArgs:  1,
-			usage:    "Usage: /unmute <uid1>,<uid2>...",
-			desc:     "Unmutes user(s).",
-			reqPerms: permissions.PermissionField["MUTE"],
+			handler:    cmdUnmute,
+			minArgs:    1,
+			usage:      "Usage: /unmute <target1>,<target2>...",
+			desc:       "Unmutes user(s).",
+			reqPerm:    permMute,
+			areaScoped: true,
+		},
+		"userperm": {
+			handler: cmdUserPerm,
+			minArgs: 3,
+			usage:   "Usage: /userperm <grant|deny|reset> <username> <permission>",
+			desc:    "Grants or denies a permission to a user regardless of their role, or resets it to their role's.",
+			reqPerm: permissions.Admin,
+		},
+		"whoami": {
+			handler: cmdWhoAmI,
+			minArgs: 0,
+			usage:   "Usage: /whoami",
+			desc:    "Shows your moderator account, role and permissions.",
+			reqPerm: permissions.None,
 		},
 	}
+	commandsMu.Lock()
+	Commands = builtinCommands
+	commandsMu.Unlock()
 }
 
 // ParseCommand calls the appropriate function for a given command.
 func ParseCommand(client *Client, command string, args []string) {
+	runCommand(client, invocation{name: command, args: args})
+}
+
+// runCommand runs a command for the client, if the client is allowed to use it.
+// Confirmed mass actions have already been checked against the command's limits, so are not checked again.
+func runCommand(client *Client, inv invocation) {
+	command, args := inv.name, inv.args
 	if command == "help" {
 		var s []string
+		cmds := scriptCommands()
+		commandsMu.RLock()
 		for name, cmd := range Commands {
-			if permissions.HasPermission(client.Perms(), cmd.reqPerms) || (cmd.reqPerms == permissions.PermissionField["CM"] && client.Area().HasCM(client.Uid())) {
-				s = append(s, fmt.Sprintf("- /%v: %v", name, cmd.desc))
+			cmds[name] = cmd
+		}
+		commandsMu.RUnlock()
+		for name, cmd := range cmds {
+			if canUse(client, cmd) {
+				line := fmt.Sprintf("- /%v: %v", name, cmd.desc)
+				if cmd.reqPerm != permissions.None {
+					line += fmt.Sprintf(" [%v]", cmd.reqPerm)
+				}
+				s = append(s, line)
 			}
 		}
 		sort.Strings(s)
-		client.SendServerMessage("Recognized commands:\n" + strings.Join(s, "\n") + "\n\nTo view detailed usage on a command, do /<command> -h")
+		client.SendServerMessage("Recognized commands:\n" + strings.Join(s, "\n") + "\n\nThe permission needed for a command is shown in brackets. To view detailed usage on a command, do /<command> -h")
 		return
 	}
 
-	cmd := Commands[command]
-	if cmd.handler == nil {
+	cmd, ok := lookupCommand(command)
+	if !ok {
 		client.SendServerMessage("Invalid command.")
 		return
-	} else if permissions.HasPermission(client.Perms(), cmd.reqPerms) || (cmd.reqPerms == permissions.PermissionField["CM"] && client.Area().HasCM(client.Uid())) {
+	} else if canUse(client, cmd) {
 		if sliceutil.ContainsString(args, "-h") {
-			client.SendServerMessage(cmd.usage)
+			usage := cmd.usage
+			if strings.Contains(usage, "target1>") || strings.Contains(usage, "target1]") {
+				usage += "\n" + targetHelp
+			}
+			if req := requirement(cmd); req != "" {
+				usage += "\n" + req
+			}
+			client.SendServerMessage(usage)
 			return
 		} else if len(args) < cmd.minArgs {
 			client.SendServerMessage("Not enough arguments.\n" + cmd.usage)
 			return
+		} else if !inv.confirmed && !checkLimits(client, command, cmd) {
+			return
 		}
+		client.SetInvocation(inv)
 		cmd.handler(client, args, cmd.usage)
+		e := events.CommandExecuted{Player: player(client), Command: command, Args: args}
+		if cmd.sensitive {
+			e.Args = nil
+		}
+		bus.Publish(e)
 	} else {
 		client.SendServerMessage("You do not have permission to use that command.")
 		return
@@ -412,6 +593,8 @@ func cmdAbout(client *Client, _ []string, _ string) {
 		version, "https://github.com/MangosArentLiterature/Athena."))
 }
 
+var permModifyArea = permissions.Register("MODIFY_AREA", "Modify area settings.")
+
 // Handles /allowcms
 func cmdAllowCMs(client *Client, args []string, _ string) {
 	var result string
@@ -447,22 +630,143 @@ func cmdAllowIniswap(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Set iniswapping to %v.", args[0]), false)
 }
 
+// Handles /approveban
+func cmdApproveBan(client *Client, args []string, _ string) {
+	id, p := reviewPendingBan(client, args[0])
+	if p == nil {
+		return
+	}
+	err := store.UpdateDuration(id, p.until)
+	if err != nil {
+		logger.LogErrorf("while approving ban %v: %v", id, err)
+		client.SendServerMessage("An unexpected error occured.")
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Approved ban %v, which now lasts until %v.", id, banUntil(p.until)))
+	addToBuffer(client, "CMD", fmt.Sprintf("Approved ban %v by %v until %v.", id, p.moderator, banUntil(p.until)), true)
+}
+
 // Handles /areainfo
 func cmdAreaInfo(client *Client, _ []string, _ string) {
 	out := fmt.Sprintf("\nBG: %v\nEvi mode: %v\nAllow iniswap: %v\nNon-interrupting pres: %v\nCMs allowed: %v\nForce BG list: %v\nBG locked: %v\nMusic locked: %v",
 		client.Area().Background(), client.Area().EvidenceMode().String(), client.Area().IniswapAllowed(), client.Area().NoInterrupt(),
 		client.Area().CMsAllowed(), client.Area().ForceBGList(), client.Area().LockBG(), client.Area().LockMusic())
+	holders, err := store.AreaRoleHolders(client.Area().Name())
+	if err != nil {
+		logger.LogErrorf("Error reading area roles for %v: %v", client.Area().Name(), err)
+	}
+	if len(holders) > 0 {
+		var l []string
+		for _, h := range holders {
+			l = append(l, fmt.Sprintf("%v (%v)", h.Username, h.Role))
+		}
+		out += "\nArea roles: " + strings.Join(l, ", ")
+	}
 	client.SendServerMessage(out)
 }
 
+// Handles /arearole
+func cmdAreaRole(client *Client, args []string, usage string) {
+	action, username := args[0], args[1]
+	if !store.UserExists(username) {
+		client.SendServerMessage("User does not exist.")
+		return
+	}
+	if action == "list" {
+		grants, err := store.AreaRoles(username)
+		if err != nil {
+			client.SendServerMessage("Failed to read area roles.")
+			logger.LogError(err.Error())
+			return
+		}
+		if len(grants) == 0 {
+			client.SendServerMessage(fmt.Sprintf("%v has no area roles.", username))
+			return
+		}
+		s := fmt.Sprintf("Area roles of %v:", username)
+		for _, g := range grants {
+			s += fmt.Sprintf("\n- %v: %v", g.Area, g.Role)
+		}
+		client.SendServerMessage(s)
+		return
+	}
+	if len(args) < 4 || (action != "grant" && action != "revoke") {
+		client.SendServerMessage("Not enough arguments.\n" + usage)
+		return
+	}
+	role, err := getRole(args[2])
+	if err != nil && action == "grant" {
+		client.SendServerMessage("Invalid role.")
+		return
+	}
+	roleName := args[2]
+	if err == nil {
+		roleName = role.Name
+	}
+	var names []string
+	for _, s := range strings.Split(args[3], ",") {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 0 || id >= len(areas) {
+			client.SendServerMessage(fmt.Sprintf("%v is not a valid area.", s))
+			return
+		}
+		names = append(names, areas[id].Name())
+	}
+	for _, name := range names {
+		if action == "grant" {
+			err = store.GrantAreaRole(username, name, roleName)
+		} else {
+			err = store.RevokeAreaRole(username, name, roleName)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = refreshUser(username)
+	}
+	if err != nil {
+		client.SendServerMessage("Failed to change area roles.")
+		logger.LogError(err.Error())
+		return
+	}
+	client.SendServerMessage("Area roles updated.")
+	if action == "grant" {
+		addToBuffer(client, "CMD", fmt.Sprintf("Granted %v the role %v in %v.", username, roleName, strings.Join(names, ", ")), true)
+	} else {
+		addToBuffer(client, "CMD", fmt.Sprintf("Revoked role %v of %v in %v.", roleName, username, strings.Join(names, ", ")), true)
+	}
+}
+
+// Handles /backup
+func cmdBackup(client *Client, _ []string, _ string) {
+	path, err := runBackup()
+	if err != nil {
+		logger.LogErrorf("while backing up database: %v", err)
+		client.SendServerMessage("Failed to back up the database.")
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Backed up the database to %v.", path))
+	addToBuffer(client, "CMD", "Backed up the database.", true)
+}
+
+var permBan = permissions.Register("BAN", "Ban users from the server.")
+
 // Handles /ban
 func cmdBan(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
 	flags.SetOutput(io.Discard)
 	uids := &[]string{}
 	ipids := &[]string{}
+	rawIpids := &[]string{}
+	rawHdids := &[]string{}
+	areaIDs := &[]string{}
 	flags.Var(&cmdParamList{uids}, "u", "")
 	flags.Var(&cmdParamList{ipids}, "i", "")
+	flags.Var(&cmdParamList{rawIpids}, "ipid", "")
+	flags.Var(&cmdParamList{rawHdids}, "hdid", "")
+	flags.Var(&cmdParamList{areaIDs}, "a", "")
+	only := flags.String("only", "", "")
 	duration := flags.String("d", config.BanLen, "")
 	flags.Parse(args)
 
@@ -472,14 +776,61 @@ func cmdBan(client *Client, args []string, usage string) {
 	}
 
 	var toBan []*Client
-	if len(*uids) > 0 {
-		toBan = getUidList(*uids)
-	} else if len(*ipids) > 0 {
-		toBan = getIpidList(*ipids)
+	var targets []banTarget
+	if (len(*uids) > 0 || len(*ipids) > 0) && (len(*rawIpids) > 0 || len(*rawHdids) > 0) {
+		client.SendServerMessage("Failed to ban: -u and -i cannot be combined with -ipid or -hdid.")
+		return
+	} else if len(*uids) > 0 || len(*ipids) > 0 {
+		var ok bool
+		toBan, ok = getTargets(client, append(*uids, ipidSelectors(*ipids)...), permissions.None)
+		if !ok {
+			return
+		}
+	} else if len(*rawIpids) > 0 || len(*rawHdids) > 0 {
+		for _, s := range *rawIpids {
+			targets = append(targets, banTarget{ipid: s})
+		}
+		for _, s := range *rawHdids {
+			targets = append(targets, banTarget{hdid: s})
+		}
 	} else {
 		client.SendServerMessage("Not enough arguments:\n" + usage)
 		return
 	}
+	for _, c := range toBan {
+		targets = append(targets, banTarget{ipid: c.Ipid(), hdid: c.Hdid()})
+	}
+
+	switch strings.ToLower(*only) {
+	case "":
+	case "ipid":
+		for i := range targets {
+			targets[i].hdid = ""
+		}
+	case "hdid":
+		for i := range targets {
+			targets[i].ipid = ""
+		}
+	default:
+		client.SendServerMessage("Failed to ban: -only must be ipid or hdid.")
+		return
+	}
+
+	var banAreas []string
+	for _, s := range *areaIDs {
+		id, err := strconv.Atoi(s)
+		if err != nil || id < 0 || id >= len(areas) {
+			client.SendServerMessage(fmt.Sprintf("Failed to ban: %v is not a valid area.", s))
+			return
+		}
+		if id == 0 {
+			client.SendServerMessage("Cannot ban users from the default area.")
+			return
+		}
+		if !sliceutil.ContainsString(banAreas, areas[id].Name()) {
+			banAreas = append(banAreas, areas[id].Name())
+		}
+	}
 
 	banTime, reason := time.Now().UTC().Unix(), strings.Join(flags.Args(), " ")
 	var until int64
@@ -493,36 +844,179 @@ func cmdBan(client *Client, args []string, usage string) {
 		}
 		until = time.Now().UTC().Add(parsedDur).Unix()
 	}
+	// Bans that need approval last the default length until another moderator approves them.
+	requested := until
+	pending := needsApproval(client, until)
+	if pending {
+		defaultLen, _ := str2duration.ParseDuration(config.BanLen)
+		until = time.Now().UTC().Add(defaultLen).Unix()
+	}
 
 	var count int
 	var report string
-	for _, c := range toBan {
-		id, err := db.AddBan(c.Ipid(), c.Hdid(), banTime, until, reason, client.ModName())
+	var pendingIDs []string
+	seen := make(map[banTarget]bool)
+	for _, t := range targets {
+		if seen[t] || (t.ipid == "" && t.hdid == "") {
+			continue
+		}
+		seen[t] = true
+		id, err := store.AddBan(db.BanInfo{Ipid: t.ipid, Hdid: t.hdid, Time: banTime, Duration: until,
+			Reason: reason, Moderator: client.ModName(), Areas: banAreas})
 		if err != nil {
+			logger.LogErrorf("while adding ban: %v", err)
 			continue
 		}
-		var untilS string
-		if until == -1 {
-			untilS = "∞"
-		} else {
-			untilS = time.Unix(until, 0).UTC().Format("02 Jan 2006 15:04 MST")
+		bus.Publish(events.Ban{By: player(client), ID: id, IPID: t.ipid, HDID: t.hdid, Reason: reason, Until: until})
+		if label := t.String(); !strings.Contains(report, label) {
+			report += label + ", "
 		}
-		if !strings.Contains(report, c.Ipid()) {
-			report += c.Ipid() + ", "
+		enforceBan(client, t, banAreas, reason, until, id)
+		if pending {
+			requestApproval(client, id, requested)
+			pendingIDs = append(pendingIDs, strconv.Itoa(id))
 		}
-		c.SendPacket("KB", fmt.Sprintf("%v\nUntil: %v\nID: %v", reason, untilS, id))
-		c.conn.Close()
 		count++
 	}
 	report = strings.TrimSuffix(report, ", ")
-	client.SendServerMessage(fmt.Sprintf("Banned %v clients.", count))
+	if len(toBan) > 0 {
+		client.SendServerMessage(fmt.Sprintf("Banned %v clients.", count))
+	} else {
+		client.SendServerMessage(fmt.Sprintf("Added %v bans.", count))
+	}
+	if len(pendingIDs) > 0 {
+		client.SendServerMessage(fmt.Sprintf("Bans %v need another moderator's approval, and last until %v unless approved.",
+			strings.Join(pendingIDs, ", "), banUntil(until)))
+	}
 	sendPlayerArup()
-	addToBuffer(client, "CMD", fmt.Sprintf("Banned %v from server for %v: %v.", report, *duration, reason), true)
+	scope := "server"
+	if len(banAreas) > 0 {
+		scope = strings.Join(banAreas, ", ")
+	}
+	addToBuffer(client, "CMD", fmt.Sprintf("Banned %v from %v for %v: %v.", report, scope, *duration, reason), true)
+}
+
+// Handles /banexport
+func cmdBanExport(client *Client, args []string, usage string) {
+	format := banlist.JSON
+	if len(args) > 0 {
+		format = banlist.Format(strings.ToLower(args[0]))
+		if format != banlist.JSON && format != banlist.CSV {
+			client.SendServerMessage("Invalid format.\n" + usage)
+			return
+		}
+	}
+	path := fmt.Sprintf("%v/bans-%v.%v", logger.LogPath, time.Now().UTC().Format("2006-01-02T150405Z"), format)
+	n, err := banlist.Export(store, path, config.Name)
+	if err != nil {
+		logger.LogErrorf("while exporting bans: %v", err)
+		client.SendServerMessage("Failed to export bans.")
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Exported %v bans to %v.", n, path))
+	addToBuffer(client, "CMD", fmt.Sprintf("Exported %v bans.", n), true)
+}
+
+// Handles /banlist
+func cmdBanList(client *Client, args []string, usage string) {
+	if len(args) == 0 {
+		lists, err := store.BanLists()
+		if err != nil {
+			logger.LogErrorf("while getting ban lists: %v", err)
+			client.SendServerMessage("An unexpected error occured.")
+			return
+		}
+		enabled := make(map[string]db.BanListInfo)
+		for _, l := range lists {
+			enabled[l.Name] = l
+		}
+		s := "Ban lists:\n----------"
+		for _, l := range banLists {
+			info, ok := enabled[l.Name]
+			state, updated := "enabled", "never"
+			if ok && !info.Enabled {
+				state = "disabled"
+			}
+			if info.Updated != 0 {
+				updated = time.Unix(info.Updated, 0).UTC().Format("02 Jan 2006 15:04 MST")
+			}
+			s += fmt.Sprintf("\n%v (%v)\nSource: %v\nBans: %v\nLast fetched: %v\n----------", l.Name, state, l.Source, info.Bans, updated)
+		}
+		client.SendServerMessage(s)
+		return
+	}
+	if len(args) < 2 {
+		client.SendServerMessage("Not enough arguments.\n" + usage)
+		return
+	}
+	name := strings.Join(args[1:], " ")
+	l, ok := getBanList(name)
+	if !ok {
+		client.SendServerMessage("No ban list with that name exists.")
+		return
+	}
+	switch args[0] {
+	case "enable", "disable":
+		enable := args[0] == "enable"
+		err := store.SetBanListEnabled(l.Name, enable)
+		if err != nil {
+			logger.LogErrorf("while updating ban list: %v", err)
+			client.SendServerMessage("An unexpected error occured.")
+			return
+		}
+		result := "Disabled"
+		if enable {
+			result = "Enabled"
+		}
+		client.SendServerMessage(fmt.Sprintf("%v ban list %v.", result, l.Name))
+		addToBuffer(client, "CMD", fmt.Sprintf("%v ban list %v.", result, l.Name), true)
+	case "refresh":
+		// Fetching a list can take a while, so it is done without holding up the client's other packets.
+		client.SendServerMessage(fmt.Sprintf("Refreshing ban list %v.", l.Name))
+		go func() {
+			skipped, err := refreshBanList(l)
+			if err != nil {
+				client.SendServerMessage(fmt.Sprintf("Failed to fetch ban list %v: %v", l.Name, err))
+				return
+			}
+			result := fmt.Sprintf("Refreshed ban list %v.", l.Name)
+			if skipped > 0 {
+				result += fmt.Sprintf(" Skipped %v malformed bans.", skipped)
+			}
+			client.SendServerMessage(result)
+		}()
+	default:
+		client.SendServerMessage("Argument not recognized.\n" + usage)
+	}
+}
+
+// Handles /banstats
+func cmdBanStats(client *Client, _ []string, _ string) {
+	stats, err := store.BanStats(10)
+	if err != nil {
+		logger.LogErrorf("while getting ban statistics: %v", err)
+		client.SendServerMessage("An unexpected error occured.")
+		return
+	}
+	s := fmt.Sprintf("Ban statistics:\nTotal bans: %v (%v active)", stats.Total, stats.Active)
+	for _, section := range []struct {
+		title  string
+		counts []db.Count
+	}{{"Bans per moderator", stats.Moderators}, {"Bans per week", stats.Weeks}, {"Most common reasons", stats.Reasons}} {
+		if len(section.counts) == 0 {
+			continue
+		}
+		s += "\n----------\n" + section.title + ":"
+		for _, c := range section.counts {
+			s += fmt.Sprintf("\n%v: %v", c.Key, c.Count)
+		}
+	}
+	client.SendServerMessage(s)
 }
 
 // Handles /bg
 func cmdBg(client *Client, args []string, _ string) {
-	if client.Area().LockBG() && !permissions.HasPermission(client.Perms(), permissions.PermissionField["MODIFY_AREA"]) {
+	if client.Area().LockBG() && !client.HasPermission(permModifyArea) {
 		client.SendServerMessage("You do not have permission to change the background in this area.")
 		return
 	}
@@ -549,7 +1043,10 @@ func cmdCharSelect(client *Client, args []string, _ string) {
 			client.SendServerMessage("You do not have permission to use that command.")
 			return
 		}
-		toChange := getUidList(strings.Split(args[0], ","))
+		toChange, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+		if !ok {
+			return
+		}
 		var count int
 		var report string
 		for _, c := range toChange {
@@ -568,6 +1065,8 @@ func cmdCharSelect(client *Client, args []string, _ string) {
 	}
 }
 
+var permCM = permissions.Register("CM", "Act as CM in any area.")
+
 // Handles /cm
 func cmdCM(client *Client, args []string, _ string) {
 	if client.CharID() == -1 {
@@ -582,7 +1081,7 @@ func cmdCM(client *Client, args []string, _ string) {
 		if client.Area().HasCM(client.Uid()) {
 			client.SendServerMessage("You are already a CM in this area.")
 			return
-		} else if len(client.Area().CMs()) > 0 && !permissions.HasPermission(client.Perms(), permissions.PermissionField["CM"]) {
+		} else if len(client.Area().CMs()) > 0 && !client.HasPermission(permCM) {
 			client.SendServerMessage("This area already has a CM.")
 			return
 		}
@@ -594,7 +1093,10 @@ func cmdCM(client *Client, args []string, _ string) {
 			client.SendServerMessage("You do not have permission to use that command.")
 			return
 		}
-		toCM := getUidList(strings.Split(args[0], ","))
+		toCM, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+		if !ok {
+			return
+		}
 		var count int
 		var report string
 		for _, c := range toCM {
@@ -613,6 +1115,27 @@ func cmdCM(client *Client, args []string, _ string) {
 	sendCMArup()
 }
 
+// Handles /confirm
+func cmdConfirm(client *Client, _ []string, _ string) {
+	inv := client.TakePendingCommand()
+	if inv == nil || time.Since(inv.at) > confirmTimeout {
+		client.SendServerMessage("There is nothing to confirm.")
+		return
+	}
+	inv.confirmed = true
+	runCommand(client, *inv)
+}
+
+// Handles /denyban
+func cmdDenyBan(client *Client, args []string, _ string) {
+	id, p := reviewPendingBan(client, args[0])
+	if p == nil {
+		return
+	}
+	client.SendServerMessage(fmt.Sprintf("Denied ban %v, which will not be extended.", id))
+	addToBuffer(client, "CMD", fmt.Sprintf("Denied ban %v by %v until %v.", id, p.moderator, banUntil(p.until)), true)
+}
+
 // Handles /doc
 func cmdDoc(client *Client, args []string, _ string) {
 	flags := flag.NewFlagSet("", 0)
@@ -672,19 +1195,23 @@ func cmdEditBan(client *Client, args []string, usage string) {
 	}
 
 	var report string
+	var pendingIDs []string
 	for _, s := range toUpdate {
 		id, err := strconv.Atoi(s)
 		if err != nil {
 			continue
 		}
-		if useDur {
-			err = db.UpdateDuration(id, until)
+		if useDur && needsApproval(client, until) {
+			requestApproval(client, id, until)
+			pendingIDs = append(pendingIDs, s)
+		} else if useDur {
+			err = store.UpdateDuration(id, until)
 			if err != nil {
 				continue
 			}
 		}
 		if useReason {
-			err = db.UpdateReason(id, *reason)
+			err = store.UpdateReason(id, *reason)
 			if err != nil {
 				continue
 			}
@@ -693,6 +1220,9 @@ func cmdEditBan(client *Client, args []string, usage string) {
 	}
 	report = strings.TrimSuffix(report, ", ")
 	client.SendServerMessage(fmt.Sprintf("Updated bans: %v", report))
+	if len(pendingIDs) > 0 {
+		client.SendServerMessage(fmt.Sprintf("The new duration of bans %v needs another moderator's approval.", strings.Join(pendingIDs, ", ")))
+	}
 	if useDur {
 		addToBuffer(client, "CMD", fmt.Sprintf("Edited bans: %v to duration: %v.", report, duration), true)
 	}
@@ -701,6 +1231,8 @@ func cmdEditBan(client *Client, args []string, usage string) {
 	}
 }
 
+var permModEvi = permissions.Register("MOD_EVI", "Modify evidence in areas whose evidence mode is \"mods\".")
+
 // Handles /evimode
 func cmdSetEviMod(client *Client, args []string, _ string) {
 	if !client.CanAlterEvidence() {
@@ -709,7 +1241,7 @@ func cmdSetEviMod(client *Client, args []string, _ string) {
 	}
 	switch args[0] {
 	case "mods":
-		if !permissions.HasPermission(client.Perms(), permissions.PermissionField["MOD_EVI"]) {
+		if !client.HasPermission(permModEvi) {
 			client.SendServerMessage("You do not have permission for this evidence mode.")
 			return
 		}
@@ -744,51 +1276,98 @@ func cmdForceBGList(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Set the BG list to %v.", args[0]), false)
 }
 
+var permBanInfo = permissions.Register("BAN_INFO", "View server bans.")
+
 // Handles /getban
-func cmdGetBan(client *Client, args []string, _ string) {
+func cmdGetBan(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
 	flags.SetOutput(io.Discard)
 	banid := flags.Int("b", -1, "")
-	ipid := flags.String("i", "", "")
-	flags.Parse(args)
-	s := "Bans:\n----------"
+	var f db.BanFilter
+	flags.StringVar(&f.Ipid, "i", "", "")
+	flags.StringVar(&f.Hdid, "hdid", "", "")
+	flags.StringVar(&f.Moderator, "m", "", "")
+	from := flags.String("from", "", "")
+	to := flags.String("to", "", "")
+	active := flags.Bool("active", false, "")
+	expired := flags.Bool("expired", false, "")
+	page := flags.Int("p", 1, "")
+	if err := flags.Parse(args); err != nil {
+		client.SendServerMessage("Invalid arguments:\n" + usage)
+		return
+	}
+	f.Reason = strings.Join(flags.Args(), " ")
+
 	entry := func(b db.BanInfo) string {
-		var d string
-		if b.Duration == -1 {
-			d = "∞"
-		} else {
-			d = time.Unix(b.Duration, 0).UTC().Format("02 Jan 2006 15:04 MST")
+		scope := "server"
+		if len(b.Areas) > 0 {
+			scope = strings.Join(b.Areas, ", ")
+		}
+
+		var source string
+		if b.Source != "" {
+			source = fmt.Sprintf("\nImported from: %v", b.Source)
 		}
 
-		return fmt.Sprintf("\nID: %v\nIPID: %v\nHDID: %v\nBanned on: %v\nUntil: %v\nReason: %v\nModerator: %v\n----------",
-			b.Id, b.Ipid, b.Hdid, time.Unix(b.Time, 0).UTC().Format("02 Jan 2006 15:04 MST"), d, b.Reason, b.Moderator)
+		return fmt.Sprintf("\nID: %v\nIPID: %v\nHDID: %v\nScope: %v\nBanned on: %v\nUntil: %v\nReason: %v\nModerator: %v%v\n----------",
+			b.Id, b.Ipid, b.Hdid, scope, time.Unix(b.Time, 0).UTC().Format("02 Jan 2006 15:04 MST"), banUntil(b.Duration), b.Reason, b.Moderator, source)
 	}
 	if *banid > 0 {
-		b, err := db.GetBan(db.BANID, *banid)
+		b, err := store.GetBan(db.BANID, *banid)
 		if err != nil || len(b) == 0 {
 			client.SendServerMessage("No ban with that ID exists.")
 			return
 		}
-		s += entry(b[0])
-	} else if *ipid != "" {
-		bans, err := db.GetBan(db.IPID, *ipid)
-		if err != nil || len(bans) == 0 {
-			client.SendServerMessage("No bans with that IPID exist.")
-			return
-		}
-		for _, b := range bans {
-			s += entry(b)
+		client.SendServerMessage("Bans:\n----------" + entry(b[0]))
+		return
+	}
+
+	for _, d := range []struct {
+		s    string
+		dest *int64
+		days int
+	}{{*from, &f.After, 0}, {*to, &f.Before, 1}} {
+		if d.s == "" {
+			continue
 		}
-	} else {
-		bans, err := db.GetRecentBans()
+		t, err := time.Parse("2006-01-02", d.s)
 		if err != nil {
-			logger.LogErrorf("while getting recent bans: %v", err)
-			client.SendServerMessage("An unexpected error occured.")
+			client.SendServerMessage(fmt.Sprintf("Invalid date %v, dates must be given as YYYY-MM-DD.", d.s))
 			return
 		}
-		for _, b := range bans {
-			s += entry(b)
-		}
+		*d.dest = t.AddDate(0, 0, d.days).Unix() // -to includes the whole day.
+	}
+	switch {
+	case *active && *expired:
+		client.SendServerMessage("Cannot search for bans that are both active and expired.")
+		return
+	case *active:
+		f.Status = db.Active
+	case *expired:
+		f.Status = db.Expired
+	}
+	if *page < 1 {
+		*page = 1
+	}
+
+	bans, total, err := store.SearchBans(f, (*page-1)*banPageSize, banPageSize)
+	if err != nil {
+		logger.LogErrorf("while searching bans: %v", err)
+		client.SendServerMessage("An unexpected error occured.")
+		return
+	}
+	if total == 0 {
+		client.SendServerMessage("No bans match your search.")
+		return
+	}
+	pages := (total + banPageSize - 1) / banPageSize
+	if len(bans) == 0 {
+		client.SendServerMessage(fmt.Sprintf("There are only %v pages of results.", pages))
+		return
+	}
+	s := fmt.Sprintf("Bans (page %v of %v, %v total):\n----------", *page, pages, total)
+	for _, b := range bans {
+		s += entry(b)
 	}
 	client.SendServerMessage(s)
 }
@@ -808,7 +1387,10 @@ func cmdInvite(client *Client, args []string, _ string) {
 		client.SendServerMessage("This area is unlocked.")
 		return
 	}
-	toInvite := getUidList(strings.Split(args[0], ","))
+	toInvite, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+	if !ok {
+		return
+	}
 	var count int
 	var report string
 	for _, c := range toInvite {
@@ -823,6 +1405,8 @@ func cmdInvite(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Invited %v to the area.", report), false)
 }
 
+var permKick = permissions.Register("KICK", "Kick users from the server.")
+
 // Handles /kick
 func cmdKick(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
@@ -838,21 +1422,21 @@ func cmdKick(client *Client, args []string, usage string) {
 		return
 	}
 
-	var toKick []*Client
-	if len(*uids) > 0 {
-		toKick = getUidList(*uids)
-	} else if len(*ipids) > 0 {
-		toKick = getIpidList(*ipids)
-	} else {
+	if len(*uids) == 0 && len(*ipids) == 0 {
 		client.SendServerMessage("Not enough arguments:\n" + usage)
 		return
 	}
+	toKick, ok := getTargets(client, append(*uids, ipidSelectors(*ipids)...), commandPerm("kick"))
+	if !ok {
+		return
+	}
 
 	var count int
 	var report string
 	reason := strings.Join(flags.Args(), " ")
 	for _, c := range toKick {
 		report += c.Ipid() + ", "
+		bus.Publish(events.Kick{By: player(client), Target: player(c), Reason: reason})
 		c.SendPacket("KK", reason)
 		c.conn.Close()
 		count++
@@ -869,19 +1453,26 @@ func cmdAreaKick(client *Client, args []string, _ string) {
 		client.SendServerMessage("Failed to kick: Cannot kick a user from area 0.")
 		return
 	}
-	toKick := getUidList(strings.Split(args[0], ","))
+	toKick, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+	if !ok {
+		return
+	}
 
 	var count int
 	var report string
 	for _, c := range toKick {
-		if c.Area() != client.Area() || permissions.HasPermission(c.Perms(), permissions.PermissionField["BYPASS_LOCK"]) {
+		if c.Area() != client.Area() || c.HasPermission(permBypassLock) {
 			continue
 		}
 		if c == client {
 			client.SendServerMessage("You can't kick yourself from the area.")
 			continue
 		}
-		c.ChangeArea(areas[0])
+		if !c.leaveArea() {
+			client.SendServerMessage(fmt.Sprintf("Could not kick %v, as no other area will take them.", c.Uid()))
+			continue
+		}
+		bus.Publish(events.Kick{By: player(client), Target: player(c), Area: true})
 		c.SendServerMessage("You were kicked from the area!")
 		count++
 		report += fmt.Sprintf("%v, ", c.Uid())
@@ -891,6 +1482,8 @@ func cmdAreaKick(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Kicked %v from area.", report), false)
 }
 
+var permBypassLock = permissions.Register("BYPASS_LOCK", "Enter and speak in locked areas.")
+
 // Handles /lock
 func cmdLock(client *Client, args []string, _ string) {
 	if sliceutil.ContainsString(args, "-s") { // Set area to spectatable.
@@ -953,6 +1546,8 @@ func cmdLockMusic(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Set CM-only music list to %v.", args[0]), false)
 }
 
+var permLog = permissions.Register("LOG", "View area logs.")
+
 // Handles /log
 func cmdLog(client *Client, args []string, _ string) {
 	wantedArea, err := strconv.Atoi(args[0])
@@ -962,6 +1557,10 @@ func cmdLog(client *Client, args []string, _ string) {
 	}
 	for i, a := range areas {
 		if i == wantedArea {
+			if !client.HasPermissionIn(a, commandPerm("log")) {
+				client.SendServerMessage("You do not have permission to view that area's log.")
+				return
+			}
 			client.SendServerMessage(strings.Join(a.Buffer(), "\n"))
 			return
 		}
@@ -975,20 +1574,71 @@ func cmdLogin(client *Client, args []string, _ string) {
 		client.SendServerMessage("You are already logged in.")
 		return
 	}
-	auth, perms := db.AuthenticateUser(args[0], []byte(args[1]))
-	addToBuffer(client, "AUTH", fmt.Sprintf("Attempted login as %v.", args[0]), true)
-	if auth {
-		client.SetAuthenticated(true)
-		client.SetPerms(perms)
-		client.SetModName(args[0])
-		client.SendServerMessage("Logged in as moderator.")
-		client.SendPacket("AUTH", "1")
-		client.SendServerMessage(fmt.Sprintf("Welcome, %v.", args[0]))
-		addToBuffer(client, "AUTH", fmt.Sprintf("Logged in as %v.", args[0]), true)
+	username := args[0]
+	addToBuffer(client, "AUTH", fmt.Sprintf("Attempted login as %v.", username), true)
+	if d := loginLockedFor(client.Ipid(), username); d > 0 {
+		client.SendServerMessage(fmt.Sprintf("Too many failed logins. Try again in %v.", d.Round(time.Second)))
+		addToBuffer(client, "AUTH", fmt.Sprintf("Refused login as %v while locked out.", username), true)
 		return
 	}
-	client.SendPacket("AUTH", "0")
-	addToBuffer(client, "AUTH", fmt.Sprintf("Failed login as %v.", args[0]), true)
+	// Every failure looks the same to the client, so that a correct password cannot be told apart from a wrong one
+	// on an account that also needs a two-factor code.
+	fail := func(reason string) {
+		client.SendPacket("AUTH", "0")
+		client.SendServerMessage("Login failed. Accounts with two-factor authentication log in with /login <username> <password> <code>.")
+		addToBuffer(client, "AUTH", fmt.Sprintf("Failed login as %v: %v.", username, reason), true)
+		bus.Publish(events.Login{Username: username, IPID: client.Ipid(), Method: "game", Reason: reason})
+		if d := failLogin(client.Ipid(), username); d > 0 {
+			client.SendServerMessage(fmt.Sprintf("Too many failed logins. Try again in %v.", d))
+			addToBuffer(client, "AUTH", fmt.Sprintf("Locked out logins as %v for %v.", username, d), true)
+		}
+	}
+
+	if !store.AuthenticateUser(username, []byte(args[1])) {
+		fail("wrong username or password")
+		return
+	}
+	user, err := store.GetUser(username)
+	if err != nil {
+		logger.LogErrorf("while reading user %v: %v", username, err)
+		client.SendServerMessage("An unexpected error occured.")
+		return
+	}
+	perms, err := userPermissions(user)
+	if err != nil {
+		logger.LogErrorf("User %v cannot log in: %v", username, err)
+		client.SendServerMessage("Your account's role is not valid. Ask an administrator to fix it.")
+		return
+	}
+	secret, err := store.TOTPSecret(username)
+	if err != nil {
+		logger.LogErrorf("while reading two-factor secret of %v: %v", username, err)
+		client.SendServerMessage("An unexpected error occured.")
+		return
+	}
+	if secret == "" && requiresTOTP(user.Role) {
+		fail("role requires two-factor authentication, but none is enrolled")
+		return
+	} else if secret != "" && len(args) < 3 {
+		fail("missing two-factor code")
+		return
+	} else if secret != "" && !checkTOTP(username, secret, args[2]) {
+		fail("wrong two-factor code")
+		return
+	}
+
+	accountLockout.Reset(username)
+	ipidLockout.Reset(client.Ipid())
+	client.SetAuthenticated(true)
+	client.SetPerms(perms)
+	client.SetAreaPerms(userAreaPermissions(user))
+	client.SetModName(username)
+	client.SetRole(user.Role)
+	client.SendServerMessage("Logged in as moderator.")
+	client.SendPacket("AUTH", "1")
+	client.SendServerMessage(fmt.Sprintf("Welcome, %v.", username))
+	addToBuffer(client, "AUTH", fmt.Sprintf("Logged in as %v.", username), true)
+	bus.Publish(events.Login{Username: username, IPID: client.Ipid(), Method: "game", Success: true})
 }
 
 // Handles /logout
@@ -1002,7 +1652,7 @@ func cmdLogout(client *Client, _ []string, _ string) {
 
 // Handles /mkusr
 func cmdMakeUser(client *Client, args []string, _ string) {
-	if db.UserExists(args[0]) {
+	if store.UserExists(args[0]) {
 		client.SendServerMessage("User already exists.")
 		return
 	}
@@ -1012,8 +1662,11 @@ func cmdMakeUser(client *Client, args []string, _ string) {
 		client.SendServerMessage("Invalid role.")
 		return
 	}
-	err = db.CreateUser(args[0], []byte(args[1]), role.GetPermissions())
-	if err != nil {
+	err = store.CreateUser(args[0], []byte(args[1]), role.Name)
+	if err == db.ErrUserExists {
+		client.SendServerMessage("User already exists.")
+		return
+	} else if err != nil {
 		logger.LogError(err.Error())
 		client.SendServerMessage("Invalid username/password.")
 		return
@@ -1022,6 +1675,8 @@ func cmdMakeUser(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Created user %v.", args[0]), true)
 }
 
+var permModSpeak = permissions.Register("MOD_SPEAK", "Speak officially as a moderator with /mod.")
+
 // Handles /mod
 func cmdMod(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
@@ -1041,11 +1696,13 @@ func cmdMod(client *Client, args []string, usage string) {
 	addToBuffer(client, "OOC", msg, false)
 }
 
+var permModChat = permissions.Register("MOD_CHAT", "Use the moderator chat with /modchat.")
+
 // Handles /modchat
 func cmdModChat(client *Client, args []string, _ string) {
 	msg := strings.Join(args, " ")
 	for c := range clients.GetAllClients() {
-		if permissions.HasPermission(c.Perms(), permissions.PermissionField["MOD_CHAT"]) {
+		if c.Perms().Has(permModChat) {
 			c.SendPacket("CT", fmt.Sprintf("[MODCHAT] %v", client.OOCName()), msg, "1")
 		}
 	}
@@ -1056,6 +1713,8 @@ func cmdMotd(client *Client, _ []string, _ string) {
 	client.SendServerMessage(config.Motd)
 }
 
+var permMoveUsers = permissions.Register("MOVE_USERS", "Move other users to different areas.")
+
 // Handles /move
 func cmdMove(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
@@ -1076,15 +1735,18 @@ func cmdMove(client *Client, args []string, usage string) {
 	wantedArea := areas[areaID]
 
 	if len(*uids) > 0 {
-		if !permissions.HasPermission(client.Perms(), permissions.PermissionField["MOVE_USERS"]) {
+		if !client.HasPermissionIn(wantedArea, permMoveUsers) {
 			client.SendServerMessage("You do not have permission to use that command.")
 			return
 		}
-		toMove := getUidList(*uids)
+		toMove, ok := getTargets(client, *uids, permMoveUsers)
+		if !ok {
+			return
+		}
 		var count int
 		var report string
 		for _, c := range toMove {
-			if !c.ChangeArea(wantedArea) {
+			if ok, _ := c.ChangeArea(wantedArea); !ok {
 				continue
 			}
 			c.SendServerMessage(fmt.Sprintf("You were moved to %v.", wantedArea.Name()))
@@ -1095,13 +1757,16 @@ func cmdMove(client *Client, args []string, usage string) {
 		client.SendServerMessage(fmt.Sprintf("Moved %v users.", count))
 		addToBuffer(client, "CMD", fmt.Sprintf("Moved %v to %v.", report, wantedArea.Name()), false)
 	} else {
-		if !client.ChangeArea(wantedArea) {
-			client.SendServerMessage("You are not invited to that area.")
+		if ok, reason := client.ChangeArea(wantedArea); !ok {
+			client.SendServerMessage(reason)
+			return
 		}
 		client.SendServerMessage(fmt.Sprintf("Moved to %v.", wantedArea.Name()))
 	}
 }
 
+var permMute = permissions.Register("MUTE", "Mute and parrot users.")
+
 // Handles /mute
 func cmdMute(client *Client, args []string, usage string) {
 	flags := flag.NewFlagSet("", 0)
@@ -1140,7 +1805,10 @@ func cmdMute(client *Client, args []string, usage string) {
 		client.SendServerMessage("Not enough arguments:\n" + usage)
 		return
 	}
-	toMute := getUidList(strings.Split(flags.Arg(0), ","))
+	toMute, ok := getTargets(client, strings.Split(flags.Arg(0), ","), commandPerm("mute"))
+	if !ok {
+		return
+	}
 	var count int
 	var report string
 	for _, c := range toMute {
@@ -1148,12 +1816,13 @@ func cmdMute(client *Client, args []string, usage string) {
 			continue
 		}
 		c.SetMuted(m)
-		if *duration == -1 {
-			c.SetUnmuteTime(time.Time{})
-		} else {
-			c.SetUnmuteTime(time.Now().UTC().Add(time.Duration(*duration) * time.Second))
+		var until time.Time
+		if *duration != -1 {
+			until = time.Now().UTC().Add(time.Duration(*duration) * time.Second)
 		}
+		c.SetUnmuteTime(until)
 		c.SendServerMessage(msg)
+		bus.Publish(events.Mute{By: player(client), Target: player(c), Kind: m.String(), Until: until})
 		count++
 		report += fmt.Sprintf("%v, ", c.Uid())
 	}
@@ -1203,7 +1872,10 @@ func cmdParrot(client *Client, args []string, usage string) {
 		client.SendServerMessage("Not enough arguments:\n" + usage)
 		return
 	}
-	toParrot := getUidList(strings.Split(flags.Arg(0), ","))
+	toParrot, ok := getTargets(client, strings.Split(flags.Arg(0), ","), commandPerm("parrot"))
+	if !ok {
+		return
+	}
 	var count int
 	var report string
 	for _, c := range toParrot {
@@ -1225,6 +1897,40 @@ func cmdParrot(client *Client, args []string, usage string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Parroted %v.", report), false)
 }
 
+// Handles /passwd
+func cmdPasswd(client *Client, args []string, _ string) {
+	if !client.Authenticated() {
+		client.SendServerMessage("You are not logged in.")
+		return
+	}
+	username := client.ModName()
+	if d := loginLockedFor(client.Ipid(), username); d > 0 {
+		client.SendServerMessage(fmt.Sprintf("Too many failed logins. Try again in %v.", d.Round(time.Second)))
+		return
+	}
+	if len(args[1]) < minPasswordLen {
+		client.SendServerMessage(fmt.Sprintf("New passwords must be at least %v characters long.", minPasswordLen))
+		return
+	} else if args[1] == args[0] {
+		client.SendServerMessage("The new password must be different from the old one.")
+		return
+	}
+	if !store.AuthenticateUser(username, []byte(args[0])) {
+		failLogin(client.Ipid(), username)
+		client.SendServerMessage("Incorrect password.")
+		addToBuffer(client, "AUTH", fmt.Sprintf("Failed to change password of %v: wrong password.", username), true)
+		return
+	}
+	err := store.ChangePassword(username, []byte(args[1]))
+	if err != nil {
+		logger.LogErrorf("while changing password of %v: %v", username, err)
+		client.SendServerMessage("Failed to change password.")
+		return
+	}
+	client.SendServerMessage("Password changed.")
+	addToBuffer(client, "AUTH", fmt.Sprintf("Changed password of %v.", username), true)
+}
+
 // Handles /play
 func cmdPlay(client *Client, args []string, _ string) {
 	if !client.CanChangeMusic() {
@@ -1242,6 +1948,7 @@ func cmdPlay(client *Client, args []string, _ string) {
 		}
 	}
 	writeToArea(client.Area(), "MC", s, fmt.Sprint(client.CharID()), client.Showname(), "1", "0")
+	bus.Publish(events.MusicChanged{Player: player(client), Song: s})
 }
 
 // Handles /players
@@ -1288,19 +1995,32 @@ func cmdPlayers(client *Client, args []string, _ string) {
 // Handles /pm
 func cmdPM(client *Client, args []string, _ string) {
 	msg := strings.Join(args[1:], " ")
-	toPM := getUidList(strings.Split(args[0], ","))
+	toPM, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+	if !ok {
+		return
+	}
 	for _, c := range toPM {
 		c.SendPacket("CT", fmt.Sprintf("[PM] %v", client.OOCName()), msg, "1")
 	}
 }
 
+// Handles /reloadcommands
+func cmdReloadCommands(client *Client, _ []string, _ string) {
+	if err := ReloadCommands(); err != nil {
+		client.SendServerMessage(fmt.Sprintf("Failed to reload commands.toml: %v", err))
+		return
+	}
+	client.SendServerMessage("Reloaded commands.toml.")
+	addToBuffer(client, "CMD", "Reloaded commands.toml.", true)
+}
+
 // Handles /rmusr
 func cmdRemoveUser(client *Client, args []string, _ string) {
-	if !db.UserExists(args[0]) {
+	if !store.UserExists(args[0]) {
 		client.SendServerMessage("User does not exist.")
 		return
 	}
-	err := db.RemoveUser(args[0])
+	err := store.RemoveUser(args[0])
 	if err != nil {
 		client.SendServerMessage("Failed to remove user.")
 		logger.LogError(err.Error())
@@ -1313,9 +2033,28 @@ func cmdRemoveUser(client *Client, args []string, _ string) {
 			c.RemoveAuth()
 		}
 	}
+	endSSHSessions(args[0])
 	addToBuffer(client, "CMD", fmt.Sprintf("Removed user %v.", args[0]), true)
 }
 
+// Handles /roles
+func cmdRoles(client *Client, _ []string, _ string) {
+	if !client.Authenticated() {
+		client.SendServerMessage("You are not logged in.")
+		return
+	}
+	s := "Roles:"
+	for _, r := range roles {
+		perms, _ := permissions.RolePermissions(roles, r.Name)
+		s += "\n- " + r.Name
+		if r.Extends != "" {
+			s += fmt.Sprintf(" (extends %v)", r.Extends)
+		}
+		s += ": " + perms.String()
+	}
+	client.SendServerMessage(s)
+}
+
 // Handles /roll
 func cmdRoll(client *Client, args []string, _ string) {
 	flags := flag.NewFlagSet("", 0)
@@ -1347,6 +2086,56 @@ func cmdRoll(client *Client, args []string, _ string) {
 	addToBuffer(client, "CMD", fmt.Sprintf("Rolled %v.", flags.Arg(0)), false)
 }
 
+// Handles /script
+func cmdScript(client *Client, args []string, usage string) {
+	if scriptEngine == nil {
+		client.SendServerMessage("Scripting is disabled.")
+		return
+	}
+	switch args[0] {
+	case "list":
+		loaded := scriptEngine.Loaded()
+		if len(loaded) == 0 {
+			client.SendServerMessage("No scripts are loaded.")
+			return
+		}
+		cmds := make(map[string][]string)
+		for _, sc := range scriptEngine.Commands() {
+			cmds[sc.Script] = append(cmds[sc.Script], "/"+sc.Name)
+		}
+		s := "Loaded scripts:"
+		for _, name := range loaded {
+			s += "\n- " + name
+			if len(cmds[name]) > 0 {
+				s += ": " + strings.Join(cmds[name], ", ")
+			}
+		}
+		client.SendServerMessage(s)
+	case "load", "unload":
+		if len(args) < 2 {
+			client.SendServerMessage("Not enough arguments.\n" + usage)
+			return
+		}
+		var err error
+		var result string
+		if args[0] == "load" {
+			err = loadScript(args[1])
+			result = "Loaded"
+		} else {
+			err = scriptEngine.Unload(args[1])
+			result = "Unloaded"
+		}
+		if err != nil {
+			client.SendServerMessage(fmt.Sprintf("Failed to %v script: %v", args[0], err))
+			return
+		}
+		client.SendServerMessage(fmt.Sprintf("%v script %v.", result, args[1]))
+		addToBuffer(client, "CMD", fmt.Sprintf("%v script %v.", result, args[1]), true)
+	default:
+		client.SendServerMessage("Invalid subcommand.\n" + usage)
+	}
+}
+
 // Handles /setrole
 func cmdChangeRole(client *Client, args []string, _ string) {
 	role, err := getRole(args[1])
@@ -1355,46 +2144,43 @@ func cmdChangeRole(client *Client, args []string, _ string) {
 		return
 	}
 
-	if !db.UserExists(args[0]) {
+	if !store.UserExists(args[0]) {
 		client.SendServerMessage("User does not exist.")
 		return
 	}
 
-	err = db.ChangePermissions(args[0], role.GetPermissions())
+	err = store.SetRole(args[0], role.Name)
+	if err == nil {
+		err = refreshUser(args[0])
+	}
 	if err != nil {
 		client.SendServerMessage("Failed to change permissions.")
 		logger.LogError(err.Error())
 		return
 	}
 	client.SendServerMessage("Role updated.")
+	addToBuffer(client, "CMD", fmt.Sprintf("Updated role of %v to %v.", args[0], args[1]), true)
+}
 
-	for c := range clients.GetAllClients() {
-		if c.Authenticated() && c.ModName() == args[0] {
-			c.SetPerms(role.GetPermissions())
-		}
+// Handles /shutdown
+func cmdShutdown(client *Client, args []string, _ string) {
+	delay, msg := parseShutdownArgs(args)
+	if !Shutdown(delay, msg) {
+		client.SendServerMessage("The server is already shutting down.")
+		return
 	}
-	addToBuffer(client, "CMD", fmt.Sprintf("Updated role of %v to %v.", args[0], args[1]), true)
+	client.SendServerMessage("Shutting down the server.")
+	addToBuffer(client, "CMD", "Shut down the server.", true)
 }
 
 // Handles /status
 func cmdStatus(client *Client, args []string, _ string) {
-	switch strings.ToLower(args[0]) {
-	case "idle":
-		client.Area().SetStatus(area.StatusIdle)
-	case "looking-for-players":
-		client.Area().SetStatus(area.StatusPlayers)
-	case "casing":
-		client.Area().SetStatus(area.StatusCasing)
-	case "recess":
-		client.Area().SetStatus(area.StatusRecess)
-	case "rp":
-		client.Area().SetStatus(area.StatusRP)
-	case "gaming":
-		client.Area().SetStatus(area.StatusGaming)
-	default:
+	status, ok := parseStatus(args[0])
+	if !ok {
 		client.SendServerMessage("Status not recognized. Recognized statuses: idle, looking-for-players, casing, recess, rp, gaming")
 		return
 	}
+	client.Area().SetStatus(status)
 	sendAreaServerMessage(client.Area(), fmt.Sprintf("%v set the status to %v.", client.OOCName(), args[0]))
 	sendStatusArup()
 	addToBuffer(client, "CMD", fmt.Sprintf("Set the status to %v.", args[0]), false)
@@ -1418,6 +2204,7 @@ func cmdSwapEvi(client *Client, args []string, _ string) {
 		client.SendServerMessage("Evidence swapped.")
 		writeToArea(client.Area(), "LE", client.Area().Evidence()...)
 		addToBuffer(client, "CMD", fmt.Sprintf("Swapped posistions of evidence %v and %v.", evi1, evi2), false)
+		bus.Publish(events.EvidenceChanged{Player: player(client), Action: events.EvidenceSwapped, ID: evi1, Other: evi2})
 	} else {
 		client.SendServerMessage("Invalid arguments.")
 	}
@@ -1494,10 +2281,11 @@ func cmdUnban(client *Client, args []string, _ string) {
 		if err != nil {
 			continue
 		}
-		err = db.UnBan(id)
+		err = store.UnBan(id)
 		if err != nil {
 			continue
 		}
+		takePendingBan(id)
 		report += fmt.Sprintf("%v, ", s)
 	}
 	report = strings.TrimSuffix(report, ", ")
@@ -1516,7 +2304,10 @@ func cmdUnCM(client *Client, args []string, _ string) {
 		client.SendServerMessage("You are no longer a CM in this area.")
 		addToBuffer(client, "CMD", "Un-CMed self.", false)
 	} else {
-		toCM := getUidList(strings.Split(args[0], ","))
+		toCM, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+		if !ok {
+			return
+		}
 		var count int
 		var report string
 		for _, c := range toCM {
@@ -1541,7 +2332,10 @@ func cmdUninvite(client *Client, args []string, _ string) {
 		client.SendServerMessage("This area is unlocked.")
 		return
 	}
-	toUninvite := getUidList(strings.Split(args[0], ","))
+	toUninvite, ok := getTargets(client, strings.Split(args[0], ","), permissions.None)
+	if !ok {
+		return
+	}
 	var count int
 	var report string
 	for _, c := range toUninvite {
@@ -1549,9 +2343,12 @@ func cmdUninvite(client *Client, args []string, _ string) {
 			continue
 		}
 		if client.Area().RemoveInvited(c.Uid()) {
-			if c.Area() == client.Area() && client.Area().Lock() == area.LockLocked && !permissions.HasPermission(c.Perms(), permissions.PermissionField["BYPASS_LOCK"]) {
-				c.SendServerMessage("You were kicked from the area!")
-				c.ChangeArea(areas[0])
+			if c.Area() == client.Area() && client.Area().Lock() == area.LockLocked && !c.HasPermission(permBypassLock) {
+				if c.leaveArea() {
+					c.SendServerMessage("You were kicked from the area!")
+				} else {
+					client.SendServerMessage(fmt.Sprintf("Could not move %v out of the area, as no other area will take them.", c.Uid()))
+				}
 			}
 			c.SendServerMessage(fmt.Sprintf("You were uninvited from area %v.", client.Area().Name()))
 			count++
@@ -1578,7 +2375,10 @@ func cmdUnlock(client *Client, _ []string, _ string) {
 
 // Handles /unmute
 func cmdUnmute(client *Client, args []string, _ string) {
-	toUnmute := getUidList(strings.Split(args[0], ","))
+	toUnmute, ok := getTargets(client, strings.Split(args[0], ","), commandPerm("unmute"))
+	if !ok {
+		return
+	}
 	var count int
 	var report string
 	for _, c := range toUnmute {
@@ -1587,6 +2387,7 @@ func cmdUnmute(client *Client, args []string, _ string) {
 		}
 		c.SetMuted(Unmuted)
 		c.SendServerMessage("You have been unmuted.")
+		bus.Publish(events.Mute{By: player(client), Target: player(c)})
 		count++
 		report += fmt.Sprintf("%v, ", c.Uid())
 	}
@@ -1594,3 +2395,60 @@ func cmdUnmute(client *Client, args []string, _ string) {
 	client.SendServerMessage(fmt.Sprintf("Unmuted %v clients.", count))
 	addToBuffer(client, "CMD", fmt.Sprintf("Unmuted %v.", report), false)
 }
+
+// Handles /userperm
+func cmdUserPerm(client *Client, args []string, usage string) {
+	action, username, perm := args[0], args[1], strings.ToUpper(args[2])
+	if _, ok := permissions.Lookup(perm); !ok && action != "reset" {
+		client.SendServerMessage("Invalid permission.")
+		return
+	}
+	if !store.UserExists(username) {
+		client.SendServerMessage("User does not exist.")
+		return
+	}
+	var err error
+	switch action {
+	case "grant":
+		err = store.SetOverride(username, perm, true)
+	case "deny":
+		err = store.SetOverride(username, perm, false)
+	case "reset":
+		err = store.ClearOverride(username, perm)
+	default:
+		client.SendServerMessage("Invalid action.\n" + usage)
+		return
+	}
+	if err == nil {
+		err = refreshUser(username)
+	}
+	if err != nil {
+		client.SendServerMessage("Failed to change permissions.")
+		logger.LogError(err.Error())
+		return
+	}
+	client.SendServerMessage("Permissions updated.")
+	addToBuffer(client, "CMD", fmt.Sprintf("Set %v of %v to %v.", perm, username, action), true)
+}
+
+// Handles /whoami
+func cmdWhoAmI(client *Client, _ []string, _ string) {
+	if !client.Authenticated() {
+		client.SendServerMessage("You are not logged in.")
+		return
+	}
+	s := fmt.Sprintf("Logged in as %v.", client.ModName())
+	if client.Role() != "" {
+		s += "\nRole: " + client.Role()
+	}
+	s += "\nPermissions: " + client.Perms().String()
+	if u, err := store.GetUser(client.ModName()); err == nil {
+		if len(u.Grants) > 0 {
+			s += "\nGranted: " + strings.Join(u.Grants, ", ")
+		}
+		if len(u.Denies) > 0 {
+			s += "\nDenied: " + strings.Join(u.Denies, ", ")
+		}
+	}
+	client.SendServerMessage(s)
+}
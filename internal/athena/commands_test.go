@@ -0,0 +1,615 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/area"
+	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/lockout"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/MangosArentLiterature/Athena/internal/totp"
+)
+
+// testConn is a net.Conn that records everything written to it.
+type testConn struct {
+	net.Conn
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (c *testConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(b)
+}
+
+func (c *testConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// Output returns everything written to the connection since the last call.
+func (c *testConn) Output() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.buf.String()
+	c.buf.Reset()
+	return s
+}
+
+// setupTestServer sets up a minimal server with one area and an in-memory store.
+func setupTestServer(t *testing.T) {
+	config = &settings.Config{ServerConfig: settings.ServerConfig{Name: "Test", BanLen: "3d", IPv6Prefix: 64}}
+	store = db.NewMemoryStore()
+	hashSecret = make([]byte, secretSize)
+	accountLockout, ipidLockout = lockout.New(3, time.Minute, time.Hour), lockout.New(3, time.Minute, time.Hour)
+	floodLockout = lockout.New(3, time.Minute, time.Hour)
+	characters = []string{"Phoenix", "Edgeworth"}
+	initCommands()
+	roles = []permissions.Role{{Name: "moderator", Permissions: []string{"BAN"}}}
+	areas = []*area.Area{
+		area.NewArea(area.AreaData{Name: "Lobby"}, len(characters), 10, area.EviAny),
+		area.NewArea(area.AreaData{Name: "Courtroom"}, len(characters), 10, area.EviAny),
+	}
+	logger.LogPath = t.TempDir()
+	t.Cleanup(func() {
+		for c := range clients.GetAllClients() {
+			clients.RemoveClient(c)
+		}
+		config, store, hashSecret, characters, areas, roles = nil, nil, nil, nil, nil, nil
+	})
+}
+
+// newTestClient returns a client that has joined the server with the given uid.
+func newTestClient(uid int, addr string) (*Client, *testConn) {
+	conn := &testConn{}
+	c := NewClient(conn, addr)
+	c.SetUid(uid)
+	c.SetHdid(hashID(addr))
+	c.SetArea(areas[0])
+	clients.AddClient(c)
+	return c, conn
+}
+
+func TestCmdLogin(t *testing.T) {
+	setupTestServer(t)
+	store.CreateUser("mod", []byte("password"), "moderator")
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	cmdLogin(c, []string{"mod", "wrong"}, "")
+	if c.Authenticated() || !strings.Contains(conn.Output(), "AUTH#0#%") {
+		t.Errorf("logged in with wrong password")
+	}
+
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if !c.Authenticated() || c.ModName() != "mod" || !c.Perms().Equal(permissions.NewSet(permBan)) {
+		t.Errorf("failed to log in, authenticated %v as %q with %v", c.Authenticated(), c.ModName(), c.Perms())
+	}
+	if !strings.Contains(conn.Output(), "AUTH#1#%") {
+		t.Errorf("client was not told it logged in")
+	}
+
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if !strings.Contains(conn.Output(), "You are already logged in.") {
+		t.Errorf("logged in twice")
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	setupTestServer(t)
+	roles = []permissions.Role{
+		{Name: "moderator", Permissions: []string{"KICK", "BAN"}},
+		{Name: "senior", Extends: "moderator", Permissions: []string{"LOG"}},
+	}
+	store.CreateUser("mod", []byte("password"), "senior")
+	store.SetOverride("mod", "MUTE", true)
+	store.SetOverride("mod", "KICK", false)
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	cmdLogin(c, []string{"mod", "password"}, "")
+	want := permissions.NewSet(permBan, permLog, permMute)
+	if !c.Perms().Equal(want) || c.Role() != "senior" {
+		t.Errorf("logged in with role %q and permissions %v, want senior and %v", c.Role(), c.Perms(), want)
+	}
+	cmdWhoAmI(c, []string{}, "")
+	if out := conn.Output(); !strings.Contains(out, "Permissions: BAN, LOG, MUTE") || !strings.Contains(out, "Denied: KICK") {
+		t.Errorf("unexpected /whoami output %q", out)
+	}
+
+	// Changing a role's permissions applies to its users at their next login.
+	roles[0].Permissions = []string{"BAN", "KICK", "MOD_CHAT"}
+	c.RemoveAuth()
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if !c.Perms().Has(permModChat) {
+		t.Errorf("permission added to an extended role was not applied")
+	}
+
+	cmdUserPerm(c, []string{"reset", "mod", "kick"}, "")
+	if !c.Perms().Has(permKick) {
+		t.Errorf("reset override was not applied to the logged in user")
+	}
+}
+
+func TestAssignLegacyRoles(t *testing.T) {
+	setupTestServer(t)
+	path := filepath.Join(t.TempDir(), "athena.db")
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Exec("CREATE TABLE USERS(USERNAME TEXT PRIMARY KEY, PASSWORD TEXT, PERMISSIONS TEXT)")
+	d.Exec("INSERT INTO USERS VALUES('mod', '', ?), ('custom', '', '3')", strconv.FormatUint(1<<2, 10))
+	d.Close()
+	s, err := db.OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	store = s
+
+	if err := assignLegacyRoles(); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := store.GetUser("mod"); u.Role != "moderator" {
+		t.Errorf("user with a role's permissions was assigned %q", u.Role)
+	}
+	u, _ := store.GetUser("custom")
+	if perms, _ := userPermissions(u); u.Role != "" || !perms.Equal(permissions.NewSet(permCM, permKick)) {
+		t.Errorf("user without a matching role has role %q and permissions %v", u.Role, perms)
+	}
+}
+
+func TestAreaRoles(t *testing.T) {
+	setupTestServer(t)
+	roles = append(roles, permissions.Role{Name: "host", Permissions: []string{"MUTE", "LOG", "MOD_CHAT"}})
+	store.CreateUser("mod", []byte("password"), "moderator")
+	store.SetOverride("mod", "LOG", false)
+	admin, adminConn := newTestClient(0, "192.0.2.1:1234")
+	admin.SetPerms(permissions.NewSet(permissions.Admin))
+	host, conn := newTestClient(1, "192.0.2.2:1234")
+	lobby, _ := newTestClient(2, "192.0.2.3:1234")
+	courtroom, _ := newTestClient(3, "192.0.2.4:1234")
+	courtroom.SetArea(areas[1])
+
+	cmdAreaRole(admin, []string{"grant", "mod", "nobody", "1"}, "")
+	if !strings.Contains(adminConn.Output(), "Invalid role.") {
+		t.Errorf("granted a role that does not exist")
+	}
+	cmdAreaRole(admin, []string{"grant", "mod", "host", "1"}, "")
+	cmdLogin(host, []string{"mod", "password"}, "")
+
+	// The role only applies in the area it was granted in.
+	ParseCommand(host, "mute", []string{"2,3"})
+	if !strings.Contains(conn.Output(), "You do not have permission to use that command.") {
+		t.Errorf("area role was used outside its area")
+	}
+	host.SetArea(areas[1])
+	ParseCommand(host, "mute", []string{"2,3"})
+	if lobby.Muted() != Unmuted || courtroom.Muted() != ICMuted {
+		t.Errorf("area role muted %v in the lobby and %v in the courtroom", lobby.Muted(), courtroom.Muted())
+	}
+	if canUse(host, Commands["modchat"]) {
+		t.Errorf("area role granted a command that is not area scoped")
+	}
+
+	// The user's denied permissions still apply to their area roles.
+	conn.Output()
+	ParseCommand(host, "log", []string{"1"})
+	if !strings.Contains(conn.Output(), "You do not have permission to use that command.") {
+		t.Errorf("denied permission was granted by an area role")
+	}
+
+	cmdAreaInfo(host, []string{}, "")
+	if !strings.Contains(conn.Output(), "Area roles: mod (host)") {
+		t.Errorf("area role holder was not listed in /areainfo")
+	}
+
+	cmdAreaRole(admin, []string{"revoke", "mod", "host", "1"}, "")
+	if host.HasPermission(permMute) {
+		t.Errorf("revoked area role was not removed from the logged in user")
+	}
+}
+
+func TestCmdHelp(t *testing.T) {
+	setupTestServer(t)
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+	c.SetPerms(permissions.NewSet(permBan))
+
+	ParseCommand(c, "help", []string{})
+	if out := conn.Output(); !strings.Contains(out, "- /ban: ") || !strings.Contains(out, "[BAN]") || strings.Contains(out, "/kick") {
+		t.Errorf("unexpected /help output %q", out)
+	}
+	ParseCommand(c, "ban", []string{"-h"})
+	if out := conn.Output(); !strings.Contains(out, "Requires BAN: "+permBan.Description()) {
+		t.Errorf("usage did not show the required permission, got %q", out)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	setupTestServer(t)
+	store.CreateUser("mod", []byte("password"), "moderator")
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	for i := 0; i < 3; i++ {
+		cmdLogin(c, []string{"mod", "wrong"}, "")
+	}
+	if !strings.Contains(conn.Output(), "Too many failed logins.") {
+		t.Errorf("client was not locked out")
+	}
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if c.Authenticated() {
+		t.Errorf("logged in while locked out")
+	}
+
+	// The lockout applies to the account from other IPIDs, but not to other accounts from other IPIDs.
+	other, _ := newTestClient(1, "192.0.2.2:1234")
+	cmdLogin(other, []string{"mod", "password"}, "")
+	if other.Authenticated() {
+		t.Errorf("logged in to a locked out account")
+	}
+	store.CreateUser("mod2", []byte("password"), "moderator")
+	cmdLogin(other, []string{"mod2", "password"}, "")
+	if !other.Authenticated() {
+		t.Errorf("failed to log in to another account")
+	}
+}
+
+func TestLoginTOTP(t *testing.T) {
+	setupTestServer(t)
+	roles = []permissions.Role{{Name: "secure", Permissions: []string{"KICK"}, RequireTOTP: true}}
+	store.CreateUser("mod", []byte("password"), "secure")
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	// A failed login with the right password must look the same as one with the wrong password.
+	// Each failure counts towards the lockout, which is reset between attempts.
+	resetLockout := func() {
+		accountLockout.Reset("mod")
+		ipidLockout.Reset(c.Ipid())
+	}
+	cmdLogin(c, []string{"mod", "wrong"}, "")
+	wrong := conn.Output()
+	resetLockout()
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if out := conn.Output(); c.Authenticated() || out != wrong {
+		t.Errorf("logged in without required two-factor authentication, or replied %q instead of %q", out, wrong)
+	}
+	resetLockout()
+	// Two-factor authentication is required by role, whatever permissions the user has been given.
+	store.SetOverride("mod", "BAN", true)
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if out := conn.Output(); c.Authenticated() || out != wrong {
+		t.Errorf("logged in without required two-factor authentication after being granted a permission, or replied %q", out)
+	}
+	resetLockout()
+
+	secret, _ := totp.NewSecret()
+	store.SetTOTPSecret("mod", secret)
+	cmdLogin(c, []string{"mod", "password"}, "")
+	if out := conn.Output(); c.Authenticated() || out != wrong {
+		t.Errorf("logged in without a two-factor code, or replied %q", out)
+	}
+	// Guessing the password without a code counts towards the lockout.
+	for i := 0; i < 10 && loginLockedFor(c.Ipid(), "mod") == 0; i++ {
+		cmdLogin(c, []string{"mod", "password"}, "")
+	}
+	if loginLockedFor(c.Ipid(), "mod") == 0 {
+		t.Errorf("logins without a two-factor code were never locked out")
+	}
+	resetLockout()
+	cmdLogin(c, []string{"mod", "password", "000000x"}, "")
+	if c.Authenticated() {
+		t.Errorf("logged in with a wrong two-factor code")
+	}
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	cmdLogin(c, []string{"mod", "password", code}, "")
+	if !c.Authenticated() {
+		t.Fatalf("failed to log in with a two-factor code")
+	}
+
+	c.RemoveAuth()
+	cmdLogin(c, []string{"mod", "password", code}, "")
+	if c.Authenticated() {
+		t.Errorf("logged in by reusing a two-factor code")
+	}
+}
+
+func TestCmdPasswd(t *testing.T) {
+	setupTestServer(t)
+	store.CreateUser("mod", []byte("password"), "moderator")
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	cmdPasswd(c, []string{"password", "new password"}, "")
+	if !strings.Contains(conn.Output(), "You are not logged in.") {
+		t.Errorf("changed password while logged out")
+	}
+	cmdLogin(c, []string{"mod", "password"}, "")
+	cmdPasswd(c, []string{"wrong", "new password"}, "")
+	if !strings.Contains(conn.Output(), "Incorrect password.") {
+		t.Errorf("changed password without the old password")
+	}
+	cmdPasswd(c, []string{"password", "new"}, "")
+	if !strings.Contains(conn.Output(), "at least 8 characters") || store.AuthenticateUser("mod", []byte("new")) {
+		t.Errorf("changed password to one that is too short")
+	}
+	cmdPasswd(c, []string{"password", "password"}, "")
+	if !strings.Contains(conn.Output(), "must be different") {
+		t.Errorf("changed password to the same password")
+	}
+	cmdPasswd(c, []string{"password", "new password"}, "")
+	if !store.AuthenticateUser("mod", []byte("new password")) {
+		t.Errorf("password was not changed")
+	}
+}
+
+func TestCmdBan(t *testing.T) {
+	setupTestServer(t)
+	mod, modConn := newTestClient(0, "192.0.2.1:1234")
+	mod.SetModName("mod")
+	target, targetConn := newTestClient(1, "192.0.2.2:1234")
+
+	cmdBan(mod, []string{"-u", "1", "-d", "1h", "being", "rude"}, "")
+	if !strings.Contains(modConn.Output(), "Banned 1 clients.") {
+		t.Errorf("moderator was not told the ban succeeded")
+	}
+	if out := targetConn.Output(); !strings.Contains(out, "KB#being rude") || !targetConn.closed {
+		t.Errorf("target was not kicked, got %q", out)
+	}
+
+	for by, value := range map[db.BanLookup]string{db.IPID: target.Ipid(), db.HDID: target.Hdid()} {
+		banned, info, err := store.IsBanned(by, value)
+		if err != nil || !banned || info.Reason != "being rude" {
+			t.Errorf("IsBanned(%v) = %v, %+v, %v", by, banned, info, err)
+		}
+	}
+	if banned, _, _ := store.IsBanned(db.IPID, mod.Ipid()); banned {
+		t.Errorf("moderator was banned")
+	}
+
+	cmdBan(mod, []string{"-u", "1", "-d", "forever", "reason"}, "")
+	if !strings.Contains(modConn.Output(), "Cannot parse duration") {
+		t.Errorf("invalid duration was accepted")
+	}
+}
+
+func TestCmdBanScope(t *testing.T) {
+	setupTestServer(t)
+	mod, modConn := newTestClient(0, "192.0.2.1:1234")
+	mod.SetModName("mod")
+	target, targetConn := newTestClient(1, "192.0.2.2:1234")
+	target.ChangeArea(areas[1])
+
+	cmdBan(mod, []string{"-u", "1", "-a", "0", "reason"}, "")
+	if !strings.Contains(modConn.Output(), "Cannot ban users from the default area.") {
+		t.Errorf("banned a user from the default area")
+	}
+
+	cmdBan(mod, []string{"-u", "1", "-a", "1", "-d", "1h", "area", "ban"}, "")
+	if targetConn.closed || target.Area() != areas[0] {
+		t.Errorf("area ban kicked = %v, area = %v; want moved to the default area", targetConn.closed, target.Area().Name())
+	}
+	if banned, _, _ := store.IsBanned(db.IPID, target.Ipid()); banned {
+		t.Errorf("area ban applies server-wide")
+	}
+	if ok, reason := target.CanJoinArea(areas[1]); ok || !strings.Contains(reason, "area ban") {
+		t.Errorf("CanJoinArea(banned area) = %v, %q", ok, reason)
+	}
+	if ok, _ := target.ChangeArea(areas[1]); ok {
+		t.Errorf("moved into a banned area")
+	}
+
+	// A client that cannot be moved out of an area it is banned from stays there, and the moderator is told.
+	locked, lockedConn := newTestClient(3, "192.0.2.4:1234")
+	locked.ChangeArea(areas[1])
+	areas[0].SetLock(area.LockLocked)
+	modConn.Output()
+	cmdBan(mod, []string{"-u", "3", "-a", "1", "-d", "1h", "area", "ban"}, "")
+	if lockedConn.closed || locked.Area() != areas[1] {
+		t.Errorf("area ban with no area to move to kicked = %v, area = %v; want left in place", lockedConn.closed, locked.Area().Name())
+	}
+	if out := modConn.Output(); !strings.Contains(out, "Could not move 3") {
+		t.Errorf("moderator was not told the client could not be moved, got %q", out)
+	}
+	areas[0].SetLock(area.LockFree)
+
+	// Targets from the client list cannot be mixed with raw IPIDs and HDIDs.
+	cmdBan(mod, []string{"-u", "2", "-hdid", "somehdid", "mixed"}, "")
+	if out := modConn.Output(); !strings.Contains(out, "cannot be combined") {
+		t.Errorf("ban mixing -u and -hdid replied %q", out)
+	}
+	if banned, _, _ := store.IsBanned(db.HDID, "somehdid"); banned {
+		t.Errorf("ban mixing -u and -hdid was placed")
+	}
+
+	other, _ := newTestClient(2, "192.0.2.3:1234")
+	cmdBan(mod, []string{"-u", "2", "-only", "hdid", "hdid", "ban"}, "")
+	if banned, _, _ := store.IsBanned(db.IPID, other.Ipid()); banned {
+		t.Errorf("HDID-only ban applies to the IPID")
+	}
+	if banned, _, _ := store.IsBanned(db.HDID, other.Hdid()); !banned {
+		t.Errorf("HDID-only ban does not apply to the HDID")
+	}
+
+	cmdBan(mod, []string{"-ipid", "offline", "offline", "ban"}, "")
+	if !strings.Contains(modConn.Output(), "Added 1 bans.") {
+		t.Errorf("moderator was not told the offline ban succeeded")
+	}
+	b, err := store.GetBan(db.IPID, "offline")
+	if err != nil || len(b) != 1 || b[0].Hdid != "" {
+		t.Errorf("GetBan(offline) = %+v, %v", b, err)
+	}
+}
+
+func TestBanApproval(t *testing.T) {
+	setupTestServer(t)
+	config.EnableApproval = true
+	approvalThreshold, approvalTimeout = 7*24*time.Hour, time.Hour
+	roles = []permissions.Role{{Name: "trial", Permissions: []string{"KICK", "BAN"}, Junior: true}}
+	mod, modConn := newTestClient(0, "192.0.2.1:1234")
+	mod.SetAuthenticated(true)
+	mod.SetModName("mod")
+	mod.SetPerms(permissions.NewSet(permBan))
+	other, otherConn := newTestClient(1, "192.0.2.2:1234")
+	other.SetAuthenticated(true)
+	other.SetModName("other")
+	other.SetPerms(permissions.NewSet(permBan))
+	newTestClient(2, "192.0.2.3:1234")
+	newTestClient(3, "192.0.2.4:1234")
+
+	cmdBan(mod, []string{"-u", "2", "-d", "perma", "reason"}, "")
+	if !strings.Contains(modConn.Output(), "need another moderator's approval") || !strings.Contains(otherConn.Output(), "/approveban 1") {
+		t.Errorf("permanent ban did not request approval")
+	}
+	if b, _ := store.GetBan(db.BANID, 1); len(b) != 1 || b[0].Duration == -1 {
+		t.Errorf("pending ban is permanent before approval, got %+v", b)
+	}
+	cmdApproveBan(mod, []string{"1"}, "")
+	if !strings.Contains(modConn.Output(), "You cannot review your own ban.") {
+		t.Errorf("moderator approved their own ban")
+	}
+	cmdApproveBan(other, []string{"1"}, "")
+	if b, _ := store.GetBan(db.BANID, 1); b[0].Duration != -1 {
+		t.Errorf("approved ban is not permanent, got %+v", b)
+	}
+	cmdDenyBan(other, []string{"1"}, "")
+	if !strings.Contains(otherConn.Output(), "not awaiting approval") {
+		t.Errorf("ban was reviewed twice")
+	}
+
+	cmdBan(mod, []string{"-u", "3", "-d", "1h", "reason"}, "")
+	if strings.Contains(modConn.Output(), "approval") {
+		t.Errorf("short ban requested approval")
+	}
+
+	// Bans by junior roles need approval if they are longer than the default.
+	mod.SetRole("trial")
+	if !needsApproval(mod, time.Now().Add(4*24*time.Hour).Unix()) || needsApproval(mod, time.Now().Add(time.Hour).Unix()) {
+		t.Errorf("junior approval policy not applied")
+	}
+	cmdApproveBan(mod, []string{"2"}, "")
+	if !strings.Contains(modConn.Output(), "Your role cannot review bans.") {
+		t.Errorf("junior moderator reviewed a ban")
+	}
+}
+
+func TestCmdGetBan(t *testing.T) {
+	setupTestServer(t)
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+	store.AddBan(db.BanInfo{Ipid: "ipid1", Hdid: "hdid1", Time: 0, Duration: -1, Reason: "first", Moderator: "mod"})
+	store.AddBan(db.BanInfo{Ipid: "ipid2", Hdid: "hdid2", Time: 1, Duration: -1, Reason: "second", Moderator: "mod"})
+
+	cmdGetBan(c, []string{}, "")
+	out := conn.Output()
+	if !strings.Contains(out, "first") || !strings.Contains(out, "second") || strings.Index(out, "second") > strings.Index(out, "first") {
+		t.Errorf("recent bans not listed most recent first, got %q", out)
+	}
+
+	cmdGetBan(c, []string{"-b", "1"}, "")
+	if out := conn.Output(); !strings.Contains(out, "ID: 1") || strings.Contains(out, "second") {
+		t.Errorf("unexpected output for ban ID, got %q", out)
+	}
+
+	cmdGetBan(c, []string{"-i", "ipid2"}, "")
+	if out := conn.Output(); !strings.Contains(out, "IPID: ipid2") || strings.Contains(out, "first") {
+		t.Errorf("unexpected output for IPID, got %q", out)
+	}
+
+	cmdGetBan(c, []string{"-b", "3"}, "")
+	if !strings.Contains(conn.Output(), "No ban with that ID exists.") {
+		t.Errorf("missing ban was found")
+	}
+
+	store.AddBan(db.BanInfo{Ipid: "ipid3", Hdid: "hdid3", Time: 2, Duration: -1, Reason: "third", Moderator: "mod", Source: "Sister Server"})
+	cmdGetBan(c, []string{"-i", "ipid3"}, "")
+	if out := conn.Output(); !strings.Contains(out, "Imported from: Sister Server") {
+		t.Errorf("imported ban is not marked as imported, got %q", out)
+	}
+	cmdGetBan(c, []string{"-i", "ipid2"}, "")
+	if out := conn.Output(); strings.Contains(out, "Imported from") {
+		t.Errorf("local ban is marked as imported, got %q", out)
+	}
+}
+
+func TestCmdGetBanSearch(t *testing.T) {
+	setupTestServer(t)
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		store.AddBan(db.BanInfo{Ipid: "ipid", Hdid: "hdid", Time: day.AddDate(0, 0, i).Unix(), Duration: -1, Reason: fmt.Sprintf("spam %v", i), Moderator: "alice"})
+	}
+	store.AddBan(db.BanInfo{Ipid: "ipid", Hdid: "other", Time: day.Unix(), Duration: 1, Reason: "trolling in OOC", Moderator: "bob"})
+
+	cmdGetBan(c, []string{"-m", "alice", "-p", "2"}, "")
+	if out := conn.Output(); !strings.Contains(out, "page 2 of 2, 7 total") || !strings.Contains(out, "spam 1") || strings.Contains(out, "spam 2") {
+		t.Errorf("unexpected second page, got %q", out)
+	}
+	cmdGetBan(c, []string{"-expired", "in", "ooc"}, "")
+	if out := conn.Output(); !strings.Contains(out, "trolling in OOC") || strings.Contains(out, "spam") {
+		t.Errorf("unexpected output for expired reason search, got %q", out)
+	}
+	cmdGetBan(c, []string{"-from", "2024-01-02", "-to", "2024-01-03"}, "")
+	if out := conn.Output(); !strings.Contains(out, "2 total") || !strings.Contains(out, "spam 2") {
+		t.Errorf("unexpected output for date range, got %q", out)
+	}
+	cmdGetBan(c, []string{"-hdid", "nobody"}, "")
+	if !strings.Contains(conn.Output(), "No bans match your search.") {
+		t.Errorf("search for a missing HDID found bans")
+	}
+	cmdGetBan(c, []string{"-from", "yesterday"}, "")
+	if !strings.Contains(conn.Output(), "Invalid date") {
+		t.Errorf("invalid date was accepted")
+	}
+
+	cmdBanStats(c, []string{}, "")
+	if out := conn.Output(); !strings.Contains(out, "Total bans: 8 (7 active)") || !strings.Contains(out, "alice: 7") ||
+		!strings.Contains(out, "2024-01-01: 8") {
+		t.Errorf("unexpected ban statistics, got %q", out)
+	}
+}
+
+func TestCmdAreaKickNoArea(t *testing.T) {
+	setupTestServer(t)
+	mod, modConn := newTestClient(0, "192.0.2.1:1234")
+	target, targetConn := newTestClient(1, "192.0.2.2:1234")
+	mod.ChangeArea(areas[1])
+	target.ChangeArea(areas[1])
+	areas[0].SetLock(area.LockLocked)
+	defer areas[0].SetLock(area.LockFree)
+
+	cmdAreaKick(mod, []string{"1"}, "")
+	if targetConn.closed || target.Area() != areas[1] {
+		t.Errorf("kicked client with no area to go to: closed = %v, area = %v", targetConn.closed, target.Area().Name())
+	}
+	if out := modConn.Output(); !strings.Contains(out, "Could not kick 1") || !strings.Contains(out, "Kicked 0 clients.") {
+		t.Errorf("moderator was not told the kick failed, got %q", out)
+	}
+}
@@ -17,8 +17,12 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 package athena
 
 import (
-	"strconv"
+	"fmt"
 	"strings"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
 )
 
 type cmdParamList struct {
@@ -38,29 +42,76 @@ func (v cmdParamList) Set(s string) error {
 	return nil
 }
 
-// getUidList returns a list of clients that have the given UID(s).
-func getUidList(uids []string) []*Client {
-	var l []*Client
-	for _, s := range uids {
-		uid, err := strconv.Atoi(s)
-		if err != nil || uid == -1 {
-			continue
-		}
-		c, err := getClientByUid(uid)
-		if err != nil {
-			continue
-		}
-		l = append(l, c)
+// ipidSelectors returns target selectors for the given IPIDs.
+func ipidSelectors(ipids []string) []string {
+	var l []string
+	for _, ipid := range ipids {
+		l = append(l, "ipid:"+ipid)
 	}
 	return l
 }
 
-// getIpidList returns a list of clients that have the given IPID(s).
-func getIpidList(ipids []string) []*Client {
-	var l []*Client
-	for _, s := range ipids {
-		c := getClientsByIpid(s)
-		l = append(l, c...)
+// canUse returns whether the client has permission to use a command in its current area.
+func canUse(client *Client, cmd Command) bool {
+	if cmd.reqPerm == permCM && client.Area().HasCM(client.Uid()) {
+		return true
+	}
+	if cmd.areaScoped {
+		return client.HasPermission(cmd.reqPerm)
+	}
+	return client.Perms().Has(cmd.reqPerm)
+}
+
+// requirement describes the permission needed to use a command, or returns an empty string if anyone can use it.
+func requirement(cmd Command) string {
+	if cmd.reqPerm == permissions.None {
+		return ""
+	}
+	s := fmt.Sprintf("Requires %v: %v", cmd.reqPerm, cmd.reqPerm.Description())
+	if cmd.areaScoped {
+		s += " This can be granted for a single area."
+	}
+	return s
+}
+
+// banTarget is an ipid/hdid pair to be banned. Either may be empty, in which case the ban only applies to the other.
+type banTarget struct {
+	ipid string
+	hdid string
+}
+
+func (t banTarget) String() string {
+	if t.ipid == "" {
+		return "HDID " + t.hdid
+	}
+	return t.ipid
+}
+
+// banUntil formats a ban's end time for display.
+func banUntil(duration int64) string {
+	if duration == -1 {
+		return "∞"
+	}
+	return time.Unix(duration, 0).UTC().Format("02 Jan 2006 15:04 MST")
+}
+
+// enforceBan applies a new ban to every connected client it matches.
+// Server-wide bans kick the client; area bans move the client out of the banned areas, telling the moderator who
+// placed the ban about any client that no other area will take.
+func enforceBan(mod *Client, t banTarget, banAreas []string, reason string, until int64, id int) {
+	for c := range clients.GetAllClients() {
+		if (t.ipid == "" || c.Ipid() != t.ipid) && (t.hdid == "" || c.Hdid() != t.hdid) {
+			continue
+		}
+		if len(banAreas) == 0 {
+			c.SendPacket("KB", fmt.Sprintf("%v\nUntil: %v\nID: %v", reason, banUntil(until), id))
+			c.conn.Close()
+		} else if c.Area() != nil && sliceutil.ContainsString(banAreas, c.Area().Name()) {
+			name := c.Area().Name()
+			c.SendServerMessage(fmt.Sprintf("You have been banned from %v.\n%v\nUntil: %v\nID: %v", name, reason, banUntil(until), id))
+			if !c.leaveArea() {
+				mod.SendServerMessage(fmt.Sprintf("Could not move %v out of %v, as no other area will take them.", c.Uid(), name))
+			}
+		}
 	}
-	return l
 }
@@ -0,0 +1,170 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/lockout"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+var permBypassCooldown = permissions.Register("BYPASS_COOLDOWN", "Ignore command cooldowns and daily limits.")
+
+// floodLockout counts each IPID's cooldown violations, locking repeat offenders out of commands.
+var floodLockout *lockout.Lockout
+
+// commandLimits limits how often a command can be used. It is shared by a command and its aliases.
+type commandLimits struct {
+	cooldown     time.Duration // The time a user must wait between uses.
+	areaCooldown time.Duration // The time between uses in the same area, by anyone.
+	daily        int           // The number of uses each user gets per day, or 0 for no limit.
+
+	mu       sync.Mutex
+	day      string               // The UTC date the counts below are for.
+	lastUse  map[string]time.Time // By IPID.
+	areaUse  map[string]time.Time // By area name.
+	dayUses  map[string]int       // By IPID.
+	midnight time.Time            // The start of the next day.
+}
+
+// newCommandLimits returns limits for a command.
+func newCommandLimits(cooldown time.Duration, areaCooldown time.Duration, daily int) *commandLimits {
+	return &commandLimits{cooldown: cooldown, areaCooldown: areaCooldown, daily: daily}
+}
+
+// take records a use of the command by the client at the given time. If the use is not allowed,
+// it instead returns a message explaining why, and how long the client must wait.
+func (l *commandLimits) take(client *Client, now time.Time) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now = now.UTC()
+	if l.lastUse == nil {
+		l.lastUse, l.areaUse = make(map[string]time.Time), make(map[string]time.Time)
+	}
+	if day := now.Format("2006-01-02"); day != l.day {
+		l.day = day
+		l.dayUses = make(map[string]int)
+		l.midnight = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		// Cooldowns carry over into the new day; only uses whose cooldowns have passed are forgotten.
+		for k, t := range l.lastUse {
+			if now.Sub(t) >= l.cooldown {
+				delete(l.lastUse, k)
+			}
+		}
+		for k, t := range l.areaUse {
+			if now.Sub(t) >= l.areaCooldown {
+				delete(l.areaUse, k)
+			}
+		}
+	}
+	ipid, areaName := client.Ipid(), client.Area().Name()
+	if l.daily > 0 && l.dayUses[ipid] >= l.daily {
+		return fmt.Sprintf("You have used this command %v times today, which is the limit.", l.daily), l.midnight.Sub(now)
+	}
+	if d := l.lastUse[ipid].Add(l.cooldown).Sub(now); l.cooldown > 0 && d > 0 {
+		return "This command is on cooldown.", d
+	}
+	if d := l.areaUse[areaName].Add(l.areaCooldown).Sub(now); l.areaCooldown > 0 && d > 0 {
+		return "This command was recently used in this area.", d
+	}
+	l.lastUse[ipid], l.areaUse[areaName] = now, now
+	l.dayUses[ipid]++
+	return "", 0
+}
+
+// inherit copies the uses recorded by old, so that reloading commands does not reset their limits.
+func (l *commandLimits) inherit(old *commandLimits) {
+	if l == old {
+		return
+	}
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.day, l.midnight = old.day, old.midnight
+	l.lastUse, l.areaUse, l.dayUses = copyMap(old.lastUse), copyMap(old.areaUse), copyMap(old.dayUses)
+}
+
+// copyMap returns a copy of a map, or nil if it is nil.
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// checkLimits returns whether the client may use a command now, telling the client why not if it can't.
+// Refused uses count towards flood detection, which locks the client out of all commands for a while.
+func checkLimits(client *Client, name string, cmd Command) bool {
+	if client.HasPermission(permBypassCooldown) {
+		return true
+	}
+	if d := floodLockout.Locked(client.Ipid()); d > 0 {
+		client.SendServerMessage(fmt.Sprintf("You are using commands too quickly. Try again in %v.", roundUp(d)))
+		return false
+	}
+	if cmd.limits == nil {
+		return true
+	}
+	msg, wait := cmd.limits.take(client, time.Now())
+	if msg == "" {
+		return true
+	}
+	client.SendServerMessage(fmt.Sprintf("%v Try again in %v.", msg, roundUp(wait)))
+	if d := floodLockout.Fail(client.Ipid()); d > 0 {
+		client.SendServerMessage(fmt.Sprintf("You are using commands too quickly. Try again in %v.", roundUp(d)))
+		addToBuffer(client, "FLOOD", fmt.Sprintf("Locked out of commands for %v after repeatedly using /%v.", d, name), true)
+		msg := fmt.Sprintf("%v (UID %v, IPID %v) was locked out of commands for %v for flooding /%v.", client.OOCName(), client.Uid(), client.Ipid(), d, name)
+		for c := range clients.GetAllClients() {
+			if c.Perms().Has(permModChat) {
+				c.SendServerMessage(msg)
+			}
+		}
+	}
+	return false
+}
+
+// roundUp rounds a wait up to the next second, so that users are never told to wait 0s.
+func roundUp(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
+}
+
+// initFlood sets up flood detection from the server's config.
+func initFlood(conf *settings.Config) error {
+	if conf.FloodViolations < 1 {
+		return fmt.Errorf("flood violations must be at least 1")
+	}
+	base, err := str2duration.ParseDuration(conf.FloodLockout)
+	if err != nil {
+		return fmt.Errorf("failed to parse flood lockout: %v", err.Error())
+	}
+	max, err := str2duration.ParseDuration(conf.FloodMaxLockout)
+	if err != nil {
+		return fmt.Errorf("failed to parse flood max_lockout: %v", err.Error())
+	}
+	floodLockout = lockout.New(conf.FloodViolations, base, max)
+	return nil
+}
@@ -0,0 +1,130 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+)
+
+func TestCommandLimits(t *testing.T) {
+	setupTestServer(t)
+	a, _ := newTestClient(0, "192.0.2.1:1234")
+	b, _ := newTestClient(1, "192.0.2.2:1234")
+	now := time.Date(2022, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	l := newCommandLimits(10*time.Second, 0, 2)
+	if msg, _ := l.take(a, now); msg != "" {
+		t.Fatalf("first use refused: %v", msg)
+	}
+	if msg, wait := l.take(a, now.Add(4*time.Second)); msg == "" || wait != 6*time.Second {
+		t.Errorf("use during cooldown gave %q, wait %v", msg, wait)
+	}
+	if msg, _ := l.take(b, now.Add(4*time.Second)); msg != "" {
+		t.Errorf("cooldown applied to another user: %v", msg)
+	}
+	l.take(a, now.Add(20*time.Second))
+	if msg, wait := l.take(a, now.Add(40*time.Second)); !strings.Contains(msg, "limit") || wait != time.Hour-40*time.Second {
+		t.Errorf("use over the daily limit gave %q, wait %v", msg, wait)
+	}
+	if msg, _ := l.take(a, now.Add(2*time.Hour)); msg != "" {
+		t.Errorf("daily limit was not reset the next day: %v", msg)
+	}
+
+	// Cooldowns are not reset at midnight.
+	l = newCommandLimits(2*time.Hour, 2*time.Hour, 0)
+	l.take(a, now)
+	if msg, _ := l.take(a, now.Add(90*time.Minute)); msg == "" {
+		t.Errorf("cooldown was reset at midnight")
+	}
+	if msg, _ := l.take(b, now.Add(100*time.Minute)); msg == "" {
+		t.Errorf("area cooldown was reset at midnight")
+	}
+	if msg, _ := l.take(a, now.Add(3*time.Hour)); msg != "" {
+		t.Errorf("cooldown did not expire: %v", msg)
+	}
+
+	l = newCommandLimits(0, time.Minute, 0)
+	l.take(a, now)
+	if msg, _ := l.take(b, now.Add(time.Second)); msg == "" {
+		t.Errorf("area cooldown did not apply to another user in the area")
+	}
+	b.SetArea(areas[1])
+	if msg, _ := l.take(b, now.Add(time.Second)); msg != "" {
+		t.Errorf("area cooldown applied to another area: %v", msg)
+	}
+}
+
+func TestReloadKeepsLimits(t *testing.T) {
+	setupTestServer(t)
+	path := settings.ConfigPath
+	settings.ConfigPath = t.TempDir()
+	t.Cleanup(func() { settings.ConfigPath = path })
+	if err := os.WriteFile(settings.ConfigPath+"/commands.toml", []byte("[Limits.about]\ncooldown = \"1h\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadCommands(); err != nil {
+		t.Fatal(err)
+	}
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+	ParseCommand(c, "about", []string{})
+	conn.Output()
+	if err := ReloadCommands(); err != nil {
+		t.Fatal(err)
+	}
+	ParseCommand(c, "about", []string{})
+	if out := conn.Output(); !strings.Contains(out, "on cooldown") {
+		t.Errorf("reloading commands reset cooldowns, got %q", out)
+	}
+}
+
+func TestFloodLockout(t *testing.T) {
+	setupTestServer(t)
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+	mod, modConn := newTestClient(1, "192.0.2.2:1234")
+	mod.SetPerms(permissions.NewSet(permModChat))
+
+	ParseCommand(c, "roll", []string{"1d6"})
+	conn.Output()
+	ParseCommand(c, "roll", []string{"1d6"})
+	if out := conn.Output(); !strings.Contains(out, "This command is on cooldown. Try again in 2s.") {
+		t.Errorf("unexpected reply to a command on cooldown: %q", out)
+	}
+	ParseCommand(c, "roll", []string{"1d6"})
+	ParseCommand(c, "roll", []string{"1d6"})
+	if !strings.Contains(modConn.Output(), "was locked out of commands") {
+		t.Errorf("moderators were not told about flooding")
+	}
+	conn.Output()
+	ParseCommand(c, "about", []string{})
+	if out := conn.Output(); !strings.Contains(out, "You are using commands too quickly. Try again in 1m0s.") {
+		t.Errorf("flooding client could still use commands, got %q", out)
+	}
+
+	mod.SetPerms(permissions.NewSet(permBypassCooldown))
+	for i := 0; i < 3; i++ {
+		ParseCommand(mod, "roll", []string{"1d6"})
+	}
+	if out := modConn.Output(); strings.Contains(out, "Try again") {
+		t.Errorf("BYPASS_COOLDOWN did not bypass the cooldown: %q", out)
+	}
+}
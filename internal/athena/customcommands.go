@@ -0,0 +1,224 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+var (
+	builtinCommands map[string]Command // The commands defined by initCommands, before commands.toml is applied.
+	commandsMu      sync.RWMutex       // Guards Commands, which is replaced when commands.toml is reloaded.
+)
+
+// lookupCommand returns the command with the given name, falling back to commands added by scripts.
+func lookupCommand(name string) (Command, bool) {
+	commandsMu.RLock()
+	cmd, ok := Commands[name]
+	commandsMu.RUnlock()
+	if !ok {
+		return scriptCommand(name)
+	}
+	return cmd, ok
+}
+
+// commandPerm returns the permission a built-in command currently needs, for handlers that check it against other users or areas.
+func commandPerm(name string) permissions.Permission {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	if cmd, ok := Commands[name]; ok {
+		return cmd.reqPerm
+	}
+	return builtinCommands[name].reqPerm
+}
+
+// buildCommands returns the built-in commands with a command configuration applied.
+func buildCommands(conf settings.CommandConfig) (map[string]Command, error) {
+	cmds := make(map[string]Command, len(builtinCommands))
+	for name, cmd := range builtinCommands {
+		cmds[name] = cmd
+	}
+	for _, name := range conf.Disabled {
+		if _, ok := cmds[name]; !ok {
+			return nil, fmt.Errorf("cannot disable unknown command %v", name)
+		}
+		delete(cmds, name)
+	}
+	for name, perm := range conf.Permissions {
+		cmd, ok := cmds[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot set the permission of unknown command %v", name)
+		} else if cmd.checksPerms {
+			return nil, fmt.Errorf("cannot set the permission of command %v, which checks permissions itself", name)
+		}
+		p, err := commandPermission(perm)
+		if err != nil {
+			return nil, fmt.Errorf("command %v: %v", name, err)
+		}
+		// The configured permission applies server-wide, as it may not be one that area roles are meant to grant.
+		cmd.reqPerm = p
+		cmd.areaScoped = false
+		cmds[name] = cmd
+	}
+	for _, c := range conf.Command {
+		if _, ok := cmds[c.Name]; ok || c.Name == "help" {
+			return nil, fmt.Errorf("command %v already exists", c.Name)
+		}
+		p, err := commandPermission(c.Permission)
+		if err != nil {
+			return nil, fmt.Errorf("command %v: %v", c.Name, err)
+		}
+		desc := c.Desc
+		if desc == "" {
+			desc = "Custom command."
+		}
+		response := c.Response
+		cmds[c.Name] = Command{
+			handler: func(client *Client, _ []string, _ string) {
+				client.SendServerMessage(expandPlaceholders(client, response))
+			},
+			usage:   "Usage: /" + c.Name,
+			desc:    desc,
+			reqPerm: p,
+		}
+	}
+	for name, limit := range conf.Limits {
+		cmd, ok := cmds[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot limit unknown command %v", name)
+		}
+		l, err := parseLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("command %v: %v", name, err)
+		}
+		cmd.limits = l
+		cmds[name] = cmd
+	}
+	// Aliases are added last so that they may refer to custom commands. They may not refer to other aliases.
+	for alias, target := range conf.Aliases {
+		if _, ok := cmds[alias]; ok || alias == "help" {
+			return nil, fmt.Errorf("alias %v is already a command", alias)
+		}
+		cmd, ok := cmds[target]
+		if _, chained := conf.Aliases[target]; !ok || chained {
+			return nil, fmt.Errorf("alias %v refers to unknown command %v", alias, target)
+		}
+		cmd.desc = fmt.Sprintf("Alias for /%v.", target)
+		cmds[alias] = cmd
+	}
+	return cmds, nil
+}
+
+// parseLimit returns the limits for a command from commands.toml, or nil if it sets none.
+func parseLimit(limit settings.CommandLimit) (*commandLimits, error) {
+	var cooldown, areaCooldown time.Duration
+	var err error
+	if limit.Cooldown != "" {
+		cooldown, err = str2duration.ParseDuration(limit.Cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cooldown: %v", err.Error())
+		}
+	}
+	if limit.AreaCooldown != "" {
+		areaCooldown, err = str2duration.ParseDuration(limit.AreaCooldown)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse area_cooldown: %v", err.Error())
+		}
+	}
+	if cooldown < 0 || areaCooldown < 0 || limit.Daily < 0 {
+		return nil, fmt.Errorf("limits cannot be negative")
+	}
+	if cooldown == 0 && areaCooldown == 0 && limit.Daily == 0 {
+		return nil, nil
+	}
+	return newCommandLimits(cooldown, areaCooldown, limit.Daily), nil
+}
+
+// commandPermission returns the permission with the given name, where an empty name or NONE means anyone may use the command.
+func commandPermission(name string) (permissions.Permission, error) {
+	if name == "" || name == "NONE" {
+		return permissions.None, nil
+	}
+	p, ok := permissions.Lookup(name)
+	if !ok {
+		return permissions.None, fmt.Errorf("unknown permission %v", name)
+	}
+	return p, nil
+}
+
+// expandPlaceholders fills in a custom command's response for the client that used it.
+func expandPlaceholders(client *Client, s string) string {
+	return strings.NewReplacer(
+		"{server}", config.Name,
+		"{area}", client.Area().Name(),
+		"{area_players}", strconv.Itoa(client.Area().PlayerCount()),
+		"{players}", strconv.Itoa(players.GetPlayerCount()),
+		"{max_players}", strconv.Itoa(config.MaxPlayers),
+		"{uid}", strconv.Itoa(client.Uid()),
+	).Replace(s)
+}
+
+// loadCommands applies commands.toml to the built-in commands.
+func loadCommands() error {
+	conf, err := settings.LoadCommands()
+	if err != nil {
+		return err
+	}
+	cmds, err := buildCommands(conf)
+	if err != nil {
+		return err
+	}
+	commandsMu.Lock()
+	for name, cmd := range cmds {
+		if old, ok := Commands[name]; ok && cmd.limits != nil && old.limits != nil {
+			cmd.limits.inherit(old.limits)
+		}
+	}
+	Commands = cmds
+	commandsMu.Unlock()
+	return nil
+}
+
+// ReloadCommands reloads commands.toml, keeping the current commands if the file is invalid.
+func ReloadCommands() error {
+	if err := loadCommands(); err != nil {
+		logger.LogErrorf("Failed to reload commands.toml: %v", err)
+		return err
+	}
+	logger.LogInfo("Reloaded commands.toml.")
+	return nil
+}
+
+// CheckCommands returns an error if commands.toml is invalid.
+func CheckCommands() error {
+	initCommands()
+	conf, err := settings.LoadCommands()
+	if err != nil {
+		return err
+	}
+	_, err = buildCommands(conf)
+	return err
+}
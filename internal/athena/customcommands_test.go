@@ -0,0 +1,113 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+)
+
+func TestCommandConfig(t *testing.T) {
+	setupTestServer(t)
+	path := settings.ConfigPath
+	settings.ConfigPath = t.TempDir()
+	t.Cleanup(func() { settings.ConfigPath = path })
+	write := func(s string) {
+		if err := os.WriteFile(settings.ConfigPath+"/commands.toml", []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`disabled = ["roll"]
+
+[Permissions]
+lock = "MUTE"
+doc = "NONE"
+log = "NONE"
+
+[Aliases]
+g = "global"
+r = "rules"
+
+[Limits.rules]
+cooldown = "1m"
+
+[[Command]]
+name = "rules"
+response = "Welcome to {server}, you are in {area}."
+desc = "Shows the rules."
+`)
+	if err := loadCommands(); err != nil {
+		t.Fatal(err)
+	}
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	ParseCommand(c, "r", []string{})
+	if out := conn.Output(); !strings.Contains(out, "Welcome to Test, you are in Lobby.") {
+		t.Errorf("alias of custom command replied %q", out)
+	}
+	ParseCommand(c, "roll", []string{})
+	if out := conn.Output(); !strings.Contains(out, "Invalid command.") {
+		t.Errorf("disabled command was run, replied %q", out)
+	}
+	if cmd, _ := lookupCommand("lock"); cmd.reqPerm != permMute || cmd.areaScoped {
+		t.Errorf("permission override was not applied, /lock requires %q, area scoped %v", cmd.reqPerm, cmd.areaScoped)
+	}
+	// Handlers that check a command's permission against other areas use the overridden permission.
+	ParseCommand(c, "log", []string{"1"})
+	if out := conn.Output(); strings.Contains(out, "permission") {
+		t.Errorf("/log with its permission lowered replied %q", out)
+	}
+	if rules, _ := lookupCommand("rules"); rules.limits == nil {
+		t.Errorf("limit was not applied")
+	} else if r, _ := lookupCommand("r"); r.limits != rules.limits {
+		t.Errorf("alias does not share its command's limits")
+	}
+	ParseCommand(c, "help", []string{})
+	if out := conn.Output(); !strings.Contains(out, "- /rules: Shows the rules.") || !strings.Contains(out, "- /g: Alias for /global.") {
+		t.Errorf("custom commands missing from /help: %q", out)
+	}
+
+	// An invalid file is rejected, keeping the current commands.
+	for _, bad := range []string{
+		`disabled = ["nonexistent"]`,
+		"[Permissions]\nlock = \"NOT_A_PERMISSION\"",
+		"[Permissions]\nbg = \"NONE\"",
+		"[Aliases]\nx = \"y\"",
+		"[Limits.global]\ncooldown = \"soon\"",
+		"[Aliases]\na = \"global\"\nb = \"a\"",
+		"[[Command]]\nname = \"global\"\nresponse = \"taken\"",
+	} {
+		write(bad)
+		if err := ReloadCommands(); err == nil {
+			t.Errorf("accepted invalid commands.toml %q", bad)
+		}
+	}
+	if _, ok := lookupCommand("rules"); !ok {
+		t.Errorf("failed reload discarded the current commands")
+	}
+
+	c.SetPerms(permissions.NewSet(permissions.Admin))
+	write("")
+	ParseCommand(c, "reloadcommands", []string{})
+	if _, ok := lookupCommand("rules"); ok {
+		t.Errorf("/reloadcommands did not apply the new file")
+	}
+}
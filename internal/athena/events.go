@@ -0,0 +1,66 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/webhook"
+	"github.com/ecnepsnai/discord"
+)
+
+var bus = events.New() // Server events, such as messages and moderator actions.
+
+// initEvents subscribes modcall reports and the Discord webhook to server events.
+func initEvents() {
+	events.Subscribe(bus, "modcall reports", func(e events.Modcall) {
+		logger.WriteReport(e.Area, e.Log)
+	})
+	if config.WebhookURL != "" {
+		webhook.ServerName = config.Name
+		discord.WebhookURL = config.WebhookURL
+		events.Subscribe(bus, "discord webhook", func(e events.Modcall) {
+			if err := webhook.PostModcall(e.Character, e.Area, e.Reason); err != nil {
+				logger.LogError(err.Error())
+			}
+		})
+	}
+}
+
+// writeAudit writes a line to the audit log, then publishes it. The line is written directly rather than by
+// a subscriber, so that it is never dropped when the bus is backed up.
+func writeAudit(line string) {
+	logger.WriteAudit(line)
+	bus.Publish(events.Audit{Line: line})
+}
+
+// player returns the description of a client used in events.
+func player(client *Client) events.Player {
+	p := events.Player{
+		UID:       client.Uid(),
+		IPID:      client.Ipid(),
+		Character: client.CurrentCharacter(),
+		OOCName:   client.OOCName(),
+	}
+	if a := client.Area(); a != nil {
+		p.Area = a.Name()
+	}
+	if client.Authenticated() {
+		p.Moderator = client.ModName()
+	}
+	return p
+}
@@ -0,0 +1,107 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/packet"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+)
+
+func TestEvents(t *testing.T) {
+	setupTestServer(t)
+	config.MaxMsg = 256
+	var got []events.Event
+	s := bus.SubscribeAll("test", func(e events.Event) {
+		if _, ok := e.(events.Audit); !ok {
+			got = append(got, e)
+		}
+	})
+	mod, _ := newTestClient(0, "192.0.2.1:1234")
+	mod.SetAuthenticated(true)
+	mod.SetModName("mod")
+	mod.SetPerms(permissions.NewSet(permissions.Admin))
+	target, _ := newTestClient(1, "192.0.2.2:1234")
+	target.SetOocName("Nick")
+
+	pktOOC(target, &packet.Packet{Header: "CT", Body: []string{"Nick", "hello <num>1"}})
+	pktModcall(target, &packet.Packet{Header: "ZZ", Body: []string{"help"}})
+	ParseCommand(mod, "kick", []string{"-u", "1", "spam"})
+	s.Unsubscribe()
+	<-s.Done()
+
+	var names []string
+	for _, e := range got {
+		names = append(names, e.Name())
+	}
+	if want := []string{"OOCMessage", "Modcall", "Kick", "CommandExecuted"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("published %v, want %v", names, want)
+	}
+	if e := got[0].(events.OOCMessage); e.Message != "hello #1" || e.OOCName != "Nick" || e.UID != 1 {
+		t.Errorf("OOC message event = %+v", e)
+	}
+	if e := got[1].(events.Modcall); e.Reason != "help" || e.Area != "Lobby" || len(e.Log) == 0 {
+		t.Errorf("modcall event = %+v", e)
+	}
+	if e := got[2].(events.Kick); e.By.Moderator != "mod" || e.Target.UID != 1 || e.Reason != "spam" || e.Area {
+		t.Errorf("kick event = %+v", e)
+	}
+	if e := got[3].(events.CommandExecuted); e.Command != "kick" || e.Moderator != "mod" || len(e.Args) != 3 {
+		t.Errorf("command event = %+v", e)
+	}
+}
+
+func TestSensitiveCommandEvents(t *testing.T) {
+	setupTestServer(t)
+	store.CreateUser("mod", []byte("hunter22"), "moderator")
+	got := make(chan events.CommandExecuted, 10)
+	s := events.Subscribe(bus, "test", func(e events.CommandExecuted) { got <- e })
+	c, _ := newTestClient(0, "192.0.2.1:1234")
+	ParseCommand(c, "login", []string{"mod", "hunter22", "123456"})
+	ParseCommand(c, "passwd", []string{"hunter22", "correct horse"})
+	s.Unsubscribe()
+	<-s.Done()
+	close(got)
+	var n int
+	for e := range got {
+		n++
+		if s := fmt.Sprint(e); e.Args != nil || strings.Contains(s, "hunter22") || strings.Contains(s, "123456") {
+			t.Errorf("/%v event carries its arguments: %+v", e.Command, e)
+		}
+	}
+	if n != 2 {
+		t.Errorf("got %v command events, want 2", n)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	setupTestServer(t)
+	c, _ := newTestClient(0, "192.0.2.1:1234")
+	addToBuffer(c, "CMD", "Audited action.", true)
+	// The line is written before addToBuffer returns, without waiting for the bus.
+	b, err := os.ReadFile(logger.LogPath + "/audit.log")
+	if err != nil || !strings.Contains(string(b), "Audited action.") {
+		t.Errorf("audit log = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,71 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// The size, in bytes, of the server's hash secret.
+const secretSize = 32
+
+// hashSecret is the key used to hash IPIDs and HDIDs.
+var hashSecret []byte
+
+// loadSecret reads the server's hash secret from the given path, generating a new secret if one does not exist.
+func loadSecret(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		b = make([]byte, secretSize)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return fmt.Errorf("failed to write hash secret: %v", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read hash secret: %v", err)
+	}
+	if len(b) < secretSize {
+		return fmt.Errorf("hash secret %v is too short", path)
+	}
+	hashSecret = b
+	return nil
+}
+
+// hashID returns the HMAC-SHA256 of s under the server's secret, truncated to 128 bits and encoded in base64.
+// This keeps IDs at the same length as those produced by previous versions.
+func hashID(s string) string {
+	mac := hmac.New(sha256.New, hashSecret)
+	mac.Write([]byte(s))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// legacyHashID returns the unkeyed MD5 hash of s, encoded in base64, as used by previous versions.
+// It is only used to find bans made before keyed hashes were introduced.
+func legacyHashID(s string) string {
+	hash := md5.Sum([]byte(s))
+	return base64.RawStdEncoding.EncodeToString(hash[:])
+}
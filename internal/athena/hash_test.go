@@ -0,0 +1,71 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSecret(t *testing.T) {
+	defer func() { hashSecret = nil }()
+	path := filepath.Join(t.TempDir(), "athena.secret")
+
+	if err := loadSecret(path); err != nil {
+		t.Fatal(err)
+	}
+	first := hashSecret
+	if len(first) != secretSize {
+		t.Fatalf("unexpected secret length, got %d", len(first))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secret is readable by other users, mode %v", info.Mode().Perm())
+	}
+	id := hashID("192.0.2.1")
+
+	// The secret must persist across restarts, otherwise every IPID would change.
+	if err := loadSecret(path); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, hashSecret) || hashID("192.0.2.1") != id {
+		t.Errorf("secret changed after reload")
+	}
+
+	os.WriteFile(path, []byte("short"), 0600)
+	if err := loadSecret(path); err == nil {
+		t.Errorf("expected error for short secret")
+	}
+}
+
+func TestHashID(t *testing.T) {
+	defer func() { hashSecret = nil }()
+	hashSecret = bytes.Repeat([]byte{1}, secretSize)
+	a := hashID("192.0.2.1")
+	if len(a) != 22 {
+		t.Errorf("unexpected ID length, got %d", len(a))
+	}
+	hashSecret = bytes.Repeat([]byte{2}, secretSize)
+	if hashID("192.0.2.1") == a {
+		t.Errorf("ID does not depend on the secret")
+	}
+}
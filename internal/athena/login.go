@@ -0,0 +1,93 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/lockout"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/MangosArentLiterature/Athena/internal/totp"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+const minPasswordLen = 8 // The shortest password /passwd accepts.
+
+var (
+	accountLockout, ipidLockout *lockout.Lockout         // Failed logins, by username and by IPID.
+	totpSteps                   = make(map[string]int64) // The step of each user's last accepted two-factor code, to prevent reuse.
+	totpMu                      sync.Mutex
+)
+
+// initLogin sets up login lockouts from the server's config.
+func initLogin(conf *settings.Config) error {
+	if conf.LoginAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1")
+	}
+	base, err := str2duration.ParseDuration(conf.LockoutLen)
+	if err != nil {
+		return fmt.Errorf("failed to parse lockout: %v", err.Error())
+	}
+	max, err := str2duration.ParseDuration(conf.MaxLockout)
+	if err != nil {
+		return fmt.Errorf("failed to parse max_lockout: %v", err.Error())
+	}
+	accountLockout = lockout.New(conf.LoginAttempts, base, max)
+	ipidLockout = lockout.New(conf.LoginAttempts, base, max)
+	return nil
+}
+
+// loginLockedFor returns how much longer logins to the given user from the IPID are locked out for.
+func loginLockedFor(ipid string, username string) time.Duration {
+	d := accountLockout.Locked(username)
+	if ipd := ipidLockout.Locked(ipid); ipd > d {
+		d = ipd
+	}
+	return d
+}
+
+// failLogin records a failed login to the given user from the IPID, and returns how long logins are now locked out for.
+func failLogin(ipid string, username string) time.Duration {
+	d := accountLockout.Fail(username)
+	if ipd := ipidLockout.Fail(ipid); ipd > d {
+		d = ipd
+	}
+	return d
+}
+
+// requiresTOTP returns whether a role requires two-factor authentication.
+func requiresTOTP(name string) bool {
+	role, err := getRole(name)
+	return err == nil && role.RequireTOTP
+}
+
+// checkTOTP checks a user's two-factor code, refusing codes that have already been used.
+func checkTOTP(username string, secret string, code string) bool {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	totpMu.Lock()
+	defer totpMu.Unlock()
+	if last, ok := totpSteps[username]; ok && step <= last {
+		return false
+	}
+	totpSteps[username] = step
+	return true
+}
@@ -17,8 +17,6 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 package athena
 
 import (
-	"crypto/md5"
-	"encoding/base64"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -26,10 +24,10 @@ import (
 
 	"github.com/MangosArentLiterature/Athena/internal/area"
 	"github.com/MangosArentLiterature/Athena/internal/db"
+	"github.com/MangosArentLiterature/Athena/internal/events"
 	"github.com/MangosArentLiterature/Athena/internal/logger"
 	"github.com/MangosArentLiterature/Athena/internal/packet"
 	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
-	"github.com/MangosArentLiterature/Athena/internal/webhook"
 )
 
 // Documentation for AO2's network protocol can be found here:
@@ -69,11 +67,13 @@ func pktHdid(client *Client, p *packet.Packet) {
 		return
 	}
 
-	// Athena does not store the client's raw HDID, but rather, it's MD5 hash.
+	// Athena does not store the client's raw HDID, but rather, it's keyed hash.
 	// This is done not only for privacy reasons, but to ensure stored HDIDs will be a reasonable length.
-	hash := md5.Sum([]byte(decode(p.Body[0])))
-	client.SetHdid(base64.StdEncoding.EncodeToString(hash[:]))
-	client.SetHdid(client.Hdid()[:len(client.Hdid())-2]) // Removes the trailing padding.
+	hdid := decode(p.Body[0])
+	if config.LegacyLookup {
+		client.legacyHdid = legacyHashID(hdid)
+	}
+	client.SetHdid(hashID(hdid))
 
 	client.CheckBanned(db.HDID)
 
@@ -100,6 +100,11 @@ func pktResCount(client *Client, _ *packet.Packet) {
 	if client.Uid() != -1 || client.Hdid() == "" {
 		return
 	}
+	if ShuttingDown() {
+		client.SendPacket("BD", "This server is shutting down.")
+		client.conn.Close()
+		return
+	}
 	if players.GetPlayerCount() >= config.MaxPlayers {
 		logger.LogInfo("Player limit reached")
 		client.SendPacket("BD", "This server is currently full.")
@@ -127,9 +132,7 @@ func pktReqDone(client *Client, _ *packet.Packet) {
 	}
 	client.SetUid(uids.GetUid())
 	players.AddPlayer()
-	if config.Advertise {
-		updatePlayers <- players.GetPlayerCount()
-	}
+	updateAdvertiser()
 	client.JoinArea(areas[0])
 	client.SendPacket("DONE")
 	sendCMArup()
@@ -139,6 +142,7 @@ func pktReqDone(client *Client, _ *packet.Packet) {
 		client.SendServerMessage(config.Motd)
 	}
 	logger.LogInfof("Client (IPID:%v UID:%v) joined the server", client.Ipid(), client.Uid())
+	bus.Publish(events.ClientJoined{Player: player(client)})
 }
 
 // Handles CC#%
@@ -368,6 +372,7 @@ func pktIC(client *Client, p *packet.Packet) {
 	client.Area().SetLastSpeaker(client.CharID())
 	writeToArea(client.Area(), "MS", args...)
 	addToBuffer(client, "IC", "\""+args[4]+"\"", false)
+	bus.Publish(events.ICMessage{Player: player(client), Showname: client.Showname(), Message: decode(args[4])})
 }
 
 // Handles MC#%
@@ -399,14 +404,19 @@ func pktAM(client *Client, p *packet.Packet) {
 			effects = p.Body[3]
 		}
 		writeToArea(client.Area(), "MC", song, p.Body[1], name, "1", "0", effects)
+		if song == "~stop.mp3" {
+			song = ""
+		}
+		bus.Publish(events.MusicChanged{Player: player(client), Song: song})
 	} else if strings.Contains(areaNames, p.Body[0]) {
 		if decode(p.Body[0]) == client.Area().Name() {
 			return
 		}
 		for _, a := range areas {
 			if a.Name() == decode(p.Body[0]) {
-				if !client.ChangeArea(a) {
-					client.SendServerMessage("You are not invited to that area.")
+				if ok, reason := client.ChangeArea(a); !ok {
+					client.SendServerMessage(reason)
+					return
 				}
 				client.SendServerMessage(fmt.Sprintf("Moved to %v.", a.Name()))
 				return
@@ -493,6 +503,7 @@ func pktOOC(client *Client, p *packet.Packet) {
 	}
 	writeToArea(client.Area(), "CT", encode(client.OOCName()), p.Body[1], "0")
 	addToBuffer(client, "OOC", "\""+p.Body[1]+"\"", false)
+	bus.Publish(events.OOCMessage{Player: player(client), Message: decode(p.Body[1])})
 }
 
 // Handles PE#%
@@ -504,6 +515,8 @@ func pktAddEvi(client *Client, p *packet.Packet) {
 	client.Area().AddEvidence(strings.Join(p.Body, "&"))
 	writeToArea(client.Area(), "LE", client.Area().Evidence()...)
 	addToBuffer(client, "EVI", fmt.Sprintf("Added evidence: %v | %v", p.Body[0], p.Body[1]), false)
+	bus.Publish(events.EvidenceChanged{Player: player(client), Action: events.EvidenceAdded, ID: len(client.Area().Evidence()) - 1,
+		Title: decode(p.Body[0]), Description: decode(p.Body[1])})
 }
 
 // Handles DE#%
@@ -519,6 +532,7 @@ func pktRemoveEvi(client *Client, p *packet.Packet) {
 	client.Area().RemoveEvidence(id)
 	writeToArea(client.Area(), "LE", client.Area().Evidence()...)
 	addToBuffer(client, "EVI", fmt.Sprintf("Removed evidence %v.", id), false)
+	bus.Publish(events.EvidenceChanged{Player: player(client), Action: events.EvidenceRemoved, ID: id})
 }
 
 // Handles EE#%
@@ -534,6 +548,8 @@ func pktEditEvi(client *Client, p *packet.Packet) {
 	client.Area().EditEvidence(id, strings.Join(p.Body[1:], "&"))
 	writeToArea(client.Area(), "LE", client.Area().Evidence()...)
 	addToBuffer(client, "EVI", fmt.Sprintf("Updated evidence %v to %v | %v", id, p.Body[1], p.Body[2]), false)
+	bus.Publish(events.EvidenceChanged{Player: player(client), Action: events.EvidenceEdited, ID: id,
+		Title: decode(p.Body[1]), Description: decode(p.Body[2])})
 }
 
 // Handles CH#%
@@ -554,13 +570,7 @@ func pktModcall(client *Client, p *packet.Packet) {
 				client.Area().Name(), client.Uid(), client.CurrentCharacter(), client.Ipid(), s))
 		}
 	}
-	if enableDiscord {
-		err := webhook.PostModcall(client.CurrentCharacter(), client.Area().Name(), s)
-		if err != nil {
-			logger.LogError(err.Error())
-		}
-	}
-	logger.WriteReport(client.Area().Name(), client.Area().Buffer())
+	bus.Publish(events.Modcall{Player: player(client), Reason: s, Log: client.Area().Buffer()})
 }
 
 // Handles SETCASE#%
@@ -577,6 +587,9 @@ func pktSetCase(client *Client, p *packet.Packet) {
 	}
 }
 
+// The roles a case announcement can ask for, in the order of the packet's arguments.
+var caseRoles = []string{"defense", "prosecutor", "judge", "juror", "stenographer"}
+
 // Handles CASEA#%
 func pktCaseAnn(client *Client, p *packet.Packet) {
 	// Let future generations know I spent far too long trying to make this work.
@@ -589,6 +602,14 @@ func pktCaseAnn(client *Client, p *packet.Packet) {
 	newPacket := fmt.Sprintf("CASEA#CASE ANNOUNCEMENT: %v in %v needs players for %v#%v#1#%%",
 		client.CurrentCharacter(), client.Area().Name(), p.Body[0], strings.Join(p.Body[1:], "#")) // Due to a bug, old client versions require this packet to have an extra arg.
 
+	var needed []string
+	for i, r := range p.Body[1:] {
+		if b, _ := strconv.ParseBool(r); b && i < len(caseRoles) {
+			needed = append(needed, caseRoles[i])
+		}
+	}
+	bus.Publish(events.CaseAnnounced{Player: player(client), Case: decode(p.Body[0]), Roles: needed})
+
 	for c := range clients.GetAllClients() {
 		if c == client {
 			continue
@@ -0,0 +1,97 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses the server's list of trusted proxy CIDRs. Bare addresses are treated as a single host.
+func parseTrustedProxies(l []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range l {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %v", s)
+			}
+			s = fmt.Sprintf("%v/%v", addr.Unmap(), addr.Unmap().BitLen())
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %v", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// parseAddr parses an address with or without a port.
+func parseAddr(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// isTrustedProxy returns whether the given address belongs to a trusted proxy.
+func isTrustedProxy(s string) bool {
+	addr, err := parseAddr(s)
+	if err != nil {
+		return false
+	}
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedAddr returns the address of the client behind a websocket request.
+// Forwarding headers are only used when the request comes from a trusted proxy, since anyone can set them otherwise.
+func forwardedAddr(peer string, h http.Header) string {
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+	if addr, err := parseAddr(h.Get("X-Real-IP")); err == nil {
+		return netip.AddrPortFrom(addr, 0).String()
+	}
+	// Each proxy appends the address it received the request from, so the rightmost untrusted address is the client.
+	hops := strings.Split(strings.Join(h.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseAddr(hops[i])
+		if err != nil {
+			break
+		}
+		s := netip.AddrPortFrom(addr, 0).String()
+		if !isTrustedProxy(s) {
+			return s
+		}
+	}
+	return peer
+}
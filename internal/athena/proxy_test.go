@@ -0,0 +1,55 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestForwardedAddr(t *testing.T) {
+	var err error
+	trustedProxies, err = parseTrustedProxies([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		name   string
+		peer   string
+		header http.Header
+		want   string
+	}{
+		{"untrusted peer", "192.0.2.1:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1:5000"},
+		{"real ip", "10.0.0.1:5000", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1:0"},
+		{"forwarded for", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.9, 198.51.100.1"}}, "198.51.100.1:0"},
+		{"chained proxies", "[::1]:5000", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.1.1.1"}}, "198.51.100.1:0"},
+		{"ipv6 client", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"2001:db8::1"}}, "[2001:db8::1]:0"},
+		{"no header", "10.0.0.1:5000", http.Header{}, "10.0.0.1:5000"},
+		{"garbage header", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"unknown"}}, "10.0.0.1:5000"},
+	}
+	for _, tt := range tests {
+		if got := forwardedAddr(tt.peer, tt.header); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-a-cidr"}); err == nil {
+		t.Errorf("expected an error for an invalid proxy")
+	}
+}
@@ -0,0 +1,219 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/scripting"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+var scriptEngine *scripting.Engine // Runs the server's Lua scripts, or nil if scripting is disabled.
+
+// initScripts loads the scripts in the configured directory.
+func initScripts(conf *settings.Config) error {
+	timeout, err := str2duration.ParseDuration(conf.ScriptTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to parse script timeout: %v", err.Error())
+	} else if timeout <= 0 {
+		return fmt.Errorf("script timeout must be positive")
+	}
+	scriptEngine = scripting.NewEngine(conf.ScriptDir, scriptHost{}, bus, timeout)
+	for _, err := range scriptEngine.LoadAll() {
+		logger.LogErrorf("Failed to load script %v", err)
+	}
+	warnShadowedCommands()
+	return nil
+}
+
+// closeScripts unloads every script.
+func closeScripts() {
+	if scriptEngine != nil {
+		scriptEngine.Close()
+	}
+}
+
+// loadScript loads or reloads a script.
+func loadScript(name string) error {
+	if err := scriptEngine.Load(name); err != nil {
+		return err
+	}
+	warnShadowedCommands()
+	return nil
+}
+
+// warnShadowedCommands logs a warning for each script command that cannot be used because a command with its name already exists.
+func warnShadowedCommands() {
+	for _, sc := range scriptEngine.Commands() {
+		commandsMu.RLock()
+		_, ok := Commands[sc.Name]
+		commandsMu.RUnlock()
+		if ok || sc.Name == "help" {
+			logger.LogWarningf("Command %v from script %v is hidden by an existing command.", sc.Name, sc.Script)
+		}
+	}
+}
+
+// scriptCommand returns the script command with the given name.
+func scriptCommand(name string) (Command, bool) {
+	if scriptEngine == nil {
+		return Command{}, false
+	}
+	sc, ok := scriptEngine.Command(name)
+	if !ok {
+		return Command{}, false
+	}
+	return toCommand(sc), true
+}
+
+// scriptCommands returns every script command, keyed by name.
+func scriptCommands() map[string]Command {
+	cmds := make(map[string]Command)
+	if scriptEngine != nil {
+		for _, sc := range scriptEngine.Commands() {
+			cmds[sc.Name] = toCommand(sc)
+		}
+	}
+	return cmds
+}
+
+// toCommand returns a command that runs a script's command.
+func toCommand(sc scripting.Command) Command {
+	// The script checked that the permission exists when it registered the command.
+	p, _ := commandPermission(sc.Permission)
+	desc := sc.Desc
+	if desc == "" {
+		desc = "Script command."
+	}
+	return Command{
+		handler: func(client *Client, args []string, _ string) {
+			reply, err := scriptEngine.RunCommand(sc.Name, player(client), args)
+			if err != nil {
+				logger.LogErrorf("Script %v failed to run /%v: %v", sc.Script, sc.Name, err)
+				client.SendServerMessage("The command failed.")
+				return
+			}
+			if reply != "" {
+				client.SendServerMessage(reply)
+			}
+		},
+		usage:   fmt.Sprintf("Usage: /%v\nAdded by script %v.", sc.Name, sc.Script),
+		desc:    desc,
+		reqPerm: p,
+	}
+}
+
+// scriptHost gives scripts access to the server.
+type scriptHost struct{}
+
+func (scriptHost) SendMessage(uid int, message string) error {
+	c, err := getClientByUid(uid)
+	if err != nil {
+		return err
+	}
+	c.SendServerMessage(message)
+	return nil
+}
+
+func (scriptHost) SendAreaMessage(name string, message string) error {
+	a, err := findArea(name)
+	if err != nil {
+		return err
+	}
+	sendAreaServerMessage(a, message)
+	return nil
+}
+
+func (scriptHost) Broadcast(message string) {
+	sendGlobalServerMessage(message)
+}
+
+func (scriptHost) MoveUser(uid int, name string) error {
+	c, err := getClientByUid(uid)
+	if err != nil {
+		return err
+	}
+	a, err := findArea(name)
+	if err != nil {
+		return err
+	}
+	if c.Area() == a {
+		return fmt.Errorf("client is already in %v", a.Name())
+	}
+	if ok, reason := c.ChangeArea(a); !ok {
+		return fmt.Errorf("client cannot join %v: %v", a.Name(), reason)
+	}
+	c.SendServerMessage(fmt.Sprintf("You were moved to %v.", a.Name()))
+	return nil
+}
+
+func (scriptHost) SetAreaStatus(name string, status string) error {
+	a, err := findArea(name)
+	if err != nil {
+		return err
+	}
+	s, ok := parseStatus(status)
+	if !ok {
+		return fmt.Errorf("%v is not a status", status)
+	}
+	a.SetStatus(s)
+	sendStatusArup()
+	return nil
+}
+
+func (scriptHost) Area(name string) (scripting.AreaState, error) {
+	a, err := findArea(name)
+	if err != nil {
+		return scripting.AreaState{}, err
+	}
+	return scripting.AreaState{
+		Name:       a.Name(),
+		Status:     a.Status().String(),
+		Lock:       a.Lock().String(),
+		Background: a.Background(),
+		Players:    a.PlayerCount(),
+		CMs:        a.CMs(),
+		Doc:        a.Doc(),
+	}, nil
+}
+
+func (scriptHost) Areas() []string {
+	l := make([]string, len(areas))
+	for i, a := range areas {
+		l[i] = a.Name()
+	}
+	return l
+}
+
+func (scriptHost) Players() []events.Player {
+	var l []events.Player
+	for c := range clients.GetAllClients() {
+		if c.Uid() != -1 {
+			l = append(l, player(c))
+		}
+	}
+	return l
+}
+
+func (scriptHost) ValidPermission(name string) bool {
+	_, err := commandPermission(name)
+	return err == nil
+}
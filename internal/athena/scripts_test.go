@@ -0,0 +1,100 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/area"
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+)
+
+const testScript = `
+athena.command("court", "Moves you to the courtroom.", function(player, args)
+	local ok, err = athena.move(player.uid, "courtroom")
+	if not ok then return err end
+	athena.set_status("Courtroom", "casing")
+	local a = athena.area("Courtroom")
+	return "Players in " .. a.name .. ": " .. a.players .. ", status " .. a.status
+end)
+athena.command("secret", "Needs a permission.", function() return "secret" end, "BAN")
+athena.command("status", "Hidden by /status.", function() return "script status" end)`
+
+func TestScripts(t *testing.T) {
+	setupTestServer(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.lua"), []byte(testScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config.ScriptDir, config.ScriptTimeout = dir, "1s"
+	if err := initScripts(config); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeScripts()
+		scriptEngine = nil
+	})
+	c, conn := newTestClient(0, "192.0.2.1:1234")
+
+	ParseCommand(c, "court", nil)
+	if c.Area() != areas[1] || areas[1].Status() != area.StatusCasing {
+		t.Errorf("/court left the client in %v with status %v", c.Area().Name(), areas[1].Status())
+	}
+	if out := conn.Output(); !strings.Contains(out, "Players in Courtroom: 1, status CASING") {
+		t.Errorf("/court replied %q", out)
+	}
+	ParseCommand(c, "secret", nil)
+	if out := conn.Output(); !strings.Contains(out, "You do not have permission") {
+		t.Errorf("/secret without permission replied %q", out)
+	}
+	c.SetPerms(permissions.NewSet(permBan))
+	ParseCommand(c, "secret", nil)
+	if out := conn.Output(); !strings.Contains(out, "secret") {
+		t.Errorf("/secret replied %q", out)
+	}
+	ParseCommand(c, "help", nil)
+	if out := conn.Output(); !strings.Contains(out, "/court: Moves you to the courtroom.") || strings.Contains(out, "Hidden by /status") {
+		t.Errorf("/help did not list script commands correctly: %q", out)
+	}
+
+	admin, adminConn := newTestClient(1, "192.0.2.2:1234")
+	admin.SetPerms(permissions.NewSet(permissions.Admin))
+	ParseCommand(admin, "status", []string{"gaming"})
+	if out := adminConn.Output(); strings.Contains(out, "script status") || areas[0].Status() != area.StatusGaming {
+		t.Errorf("a script command replaced /status: %q", out)
+	}
+	ParseCommand(admin, "script", []string{"list"})
+	if out := adminConn.Output(); !strings.Contains(out, "- test: /court, /secret, /status") {
+		t.Errorf("/script list replied %q", out)
+	}
+	ParseCommand(admin, "script", []string{"unload", "test"})
+	ParseCommand(c, "court", nil)
+	if out := conn.Output(); !strings.Contains(out, "Invalid command.") {
+		t.Errorf("/court still ran after unloading its script: %q", out)
+	}
+	ParseCommand(admin, "script", []string{"load", "test"})
+	if out := adminConn.Output(); !strings.Contains(out, "Loaded script test.") {
+		t.Errorf("/script load replied %q", out)
+	}
+	ParseCommand(admin, "script", []string{"load", "../test"})
+	if out := adminConn.Output(); !strings.Contains(out, "Failed to load script") {
+		t.Errorf("loaded a script outside the script directory: %q", out)
+	}
+}
@@ -0,0 +1,152 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+)
+
+// An invocation is a use of a command, kept so that mass actions can be repeated once confirmed.
+type invocation struct {
+	name      string
+	args      []string
+	confirmed bool      // Whether the user has confirmed the command's mass action.
+	at        time.Time // When the command was used.
+	targets   []*Client // The users the mass action was resolved to, which are the ones acted on once confirmed.
+}
+
+// targetHelp explains target selectors in command usage.
+const targetHelp = "Targets are UIDs, @all, @area, @area:<area>, char:<name>, ooc:<name>, hdid:<hdid> or ipid:<ipid>. Prefix a target with ! to exclude it."
+
+// How long a mass action waits for /confirm.
+const confirmTimeout = time.Minute
+
+// selectClients returns the clients matched by a target selector, which is one of:
+//
+//	<uid>         The user with the UID.
+//	@all          Every user on the server.
+//	@area         Every user in the client's area.
+//	@area:<area>  Every user in the area with the given name or ID.
+//	char:<name>   Every user playing the character.
+//	ooc:<name>    Every user with the OOC name.
+//	hdid:<hdid>   Every user with the HDID.
+//	ipid:<ipid>   Every user with the IPID.
+func selectClients(client *Client, selector string) ([]*Client, error) {
+	kind, value, hasValue := strings.Cut(selector, ":")
+	if hasValue && value == "" {
+		return nil, fmt.Errorf("%v is not a valid target", selector)
+	}
+	var match func(c *Client) bool
+	switch kind {
+	case "@all":
+		match = func(*Client) bool { return true }
+	case "@area":
+		a := client.Area()
+		if value != "" {
+			var err error
+			a, err = findArea(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		match = func(c *Client) bool { return c.Area() == a }
+	case "char":
+		match = func(c *Client) bool { return c.CharID() != -1 && strings.EqualFold(c.CurrentCharacter(), value) }
+	case "ooc":
+		match = func(c *Client) bool { return strings.EqualFold(c.OOCName(), value) }
+	case "hdid":
+		match = func(c *Client) bool { return c.Hdid() == value }
+	case "ipid":
+		match = func(c *Client) bool { return c.Ipid() == value }
+	default:
+		uid, err := strconv.Atoi(selector)
+		if err != nil || uid < 0 {
+			return nil, fmt.Errorf("%v is not a valid target", selector)
+		}
+		match = func(c *Client) bool { return c.Uid() == uid }
+	}
+	var l []*Client
+	for c := range clients.GetAllClients() {
+		if c.Uid() != -1 && match(c) {
+			l = append(l, c)
+		}
+	}
+	return l, nil
+}
+
+// getTargets returns the clients selected by a list of target selectors, in UID order. Selectors prefixed
+// with ! exclude the clients they match, and selectors other than UIDs never match the client itself.
+// When perm is not None, only clients in areas where the client has that permission are returned.
+// If more clients are selected than the server's mass action threshold, the client is asked to /confirm
+// the command first, and the command then acts on the clients that were selected when it was first used.
+// If the selectors are invalid or the command needs confirming, the client is told so and ok is false.
+func getTargets(client *Client, selectors []string, perm permissions.Permission) (targets []*Client, ok bool) {
+	inv := client.Invocation()
+	if inv.confirmed && inv.targets != nil {
+		all := clients.GetAllClients()
+		for _, c := range inv.targets {
+			if _, connected := all[c]; connected {
+				targets = append(targets, c)
+			}
+		}
+		return targets, true
+	}
+	include := make(map[*Client]bool)
+	exclude := make(map[*Client]bool)
+	for _, s := range selectors {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		set := include
+		if strings.HasPrefix(s, "!") {
+			s, set = s[1:], exclude
+		}
+		l, err := selectClients(client, s)
+		if err != nil {
+			client.SendServerMessage(fmt.Sprintf("Invalid target: %v.", err))
+			return nil, false
+		}
+		_, err = strconv.Atoi(s)
+		isUID := err == nil
+		for _, c := range l {
+			if c != client || isUID {
+				set[c] = true
+			}
+		}
+	}
+	for c := range include {
+		if !exclude[c] && (perm == permissions.None || client.HasPermissionIn(c.Area(), perm)) {
+			targets = append(targets, c)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Uid() < targets[j].Uid() })
+
+	if config.MassThreshold > 0 && len(targets) > config.MassThreshold && !inv.confirmed {
+		inv.at, inv.targets = time.Now(), targets
+		client.SetPendingCommand(&inv)
+		client.SendServerMessage(fmt.Sprintf("This will affect %v users. Use /confirm within %v to continue.", len(targets), confirmTimeout))
+		return nil, false
+	}
+	return targets, true
+}
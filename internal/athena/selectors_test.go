@@ -0,0 +1,90 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+)
+
+func TestGetTargets(t *testing.T) {
+	setupTestServer(t)
+	mod, conn := newTestClient(0, "192.0.2.1:1234")
+	a, _ := newTestClient(1, "192.0.2.2:1234")
+	a.SetCharID(0)
+	a.SetOocName("Nick")
+	b, _ := newTestClient(2, "192.0.2.3:1234")
+	b.SetArea(areas[1])
+	b.SetCharID(1)
+
+	for selectors, want := range map[string]string{
+		"1,2":                     "[1 2]",
+		"@all":                    "[1 2]",
+		"@all,!1":                 "[2]",
+		"@area":                   "[1]",
+		"0,@area":                 "[0 1]",
+		"@area:courtroom":         "[2]",
+		"@area:1":                 "[2]",
+		"char:phoenix":            "[1]",
+		"ooc:nick,char:edgeworth": "[1 2]",
+		"hdid:" + b.Hdid():        "[2]",
+		"ipid:" + a.Ipid():        "[1]",
+		"@all,!@area":             "[2]",
+		"7":                       "[]",
+	} {
+		targets, ok := getTargets(mod, strings.Split(selectors, ","), permissions.None)
+		var uids []int
+		for _, c := range targets {
+			uids = append(uids, c.Uid())
+		}
+		if got := fmt.Sprint(uids); !ok || got != want {
+			t.Errorf("getTargets(%v) = %v, %v; want %v", selectors, got, ok, want)
+		}
+	}
+	for _, bad := range []string{"@area:Nowhere", "char:", "abc", "-1"} {
+		if _, ok := getTargets(mod, []string{bad}, permissions.None); ok {
+			t.Errorf("getTargets accepted invalid selector %v", bad)
+		}
+	}
+	conn.Output()
+
+	config.MassThreshold = 1
+	mod.SetPerms(permissions.NewSet(permMute))
+	ParseCommand(mod, "mute", []string{"@area"})
+	if a.Muted() != ICMuted || mod.Muted() != Unmuted || strings.Contains(conn.Output(), "/confirm") {
+		t.Errorf("mass action below the threshold asked for confirmation, did nothing, or muted the moderator")
+	}
+	ParseCommand(mod, "mute", []string{"2"})
+	ParseCommand(mod, "unmute", []string{"@all"})
+	if a.Muted() != ICMuted || !strings.Contains(conn.Output(), "This will affect 2 users.") {
+		t.Errorf("mass action above the threshold was not held for confirmation")
+	}
+	// Users who join before the action is confirmed are not affected by it.
+	late, _ := newTestClient(3, "192.0.2.4:1234")
+	late.SetMuted(ICMuted)
+	ParseCommand(mod, "confirm", []string{})
+	if a.Muted() != Unmuted || b.Muted() != Unmuted || late.Muted() != ICMuted {
+		t.Errorf("confirmed mass action was not run on the users it was confirmed for")
+	}
+	ParseCommand(mod, "confirm", []string{})
+	if !strings.Contains(conn.Output(), "There is nothing to confirm.") {
+		t.Errorf("mass action could be confirmed twice")
+	}
+}
@@ -18,14 +18,15 @@ package athena
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/base64"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/MangosArentLiterature/Athena/internal/area"
@@ -34,11 +35,10 @@ import (
 	"github.com/MangosArentLiterature/Athena/internal/ms"
 	"github.com/MangosArentLiterature/Athena/internal/permissions"
 	"github.com/MangosArentLiterature/Athena/internal/playercount"
+	"github.com/MangosArentLiterature/Athena/internal/proxyproto"
 	"github.com/MangosArentLiterature/Athena/internal/settings"
 	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
 	"github.com/MangosArentLiterature/Athena/internal/uidmanager"
-	"github.com/MangosArentLiterature/Athena/internal/webhook"
-	"github.com/ecnepsnai/discord"
 	"github.com/xhit/go-str2duration/v2"
 	"nhooyr.io/websocket"
 )
@@ -51,23 +51,31 @@ var (
 	areas                                  []*area.Area
 	areaNames                              string
 	roles                                  []permissions.Role
+	store                                  db.Store
 	uids                                   uidmanager.UidManager
 	players                                playercount.PlayerCount
-	enableDiscord                          bool
+	shutdownLen                            time.Duration
+	tcpListener                            net.Listener
+	wsServers                              []*http.Server
+	listenerMu                             sync.Mutex
+	advertWG                               sync.WaitGroup
 	clients                                ClientList = ClientList{list: make(map[*Client]struct{})}
 	updatePlayers                                     = make(chan int)      // Updates the advertiser's player count.
 	advertDone                                        = make(chan struct{}) // Signals the advertiser to stop.
 	FatalError                                        = make(chan error)    // Signals that the server should stop after a fatal error.
 )
 
-// InitServer initalizes the server's database, uids, configs, and advertiser.
-func InitServer(conf *settings.Config) error {
-	db.Open()
+// InitServer initalizes the server's storage, uids, configs, and advertiser.
+func InitServer(conf *settings.Config, s db.Store) error {
+	store = s
+	err := loadSecret(settings.ConfigPath + "/athena.secret")
+	if err != nil {
+		return err
+	}
 	uids.InitHeap(conf.MaxPlayers)
 	config = conf
 
 	// Load server data.
-	var err error
 	music, err = settings.LoadMusic()
 	if err != nil {
 		return err
@@ -87,6 +95,10 @@ func InitServer(conf *settings.Config) error {
 	if err != nil {
 		return err
 	}
+	err = assignLegacyRoles()
+	if err != nil {
+		return fmt.Errorf("failed to assign roles to users: %v", err)
+	}
 
 	backgrounds, err = settings.LoadFile("/backgrounds.txt")
 	if err != nil {
@@ -105,13 +117,69 @@ func InitServer(conf *settings.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to parse default_ban_duration: %v", err.Error())
 	}
-
-	// Discord webhook.
-	if config.WebhookURL != "" {
-		enableDiscord = true
-		webhook.ServerName = config.Name
-		discord.WebhookURL = config.WebhookURL
+	shutdownLen, err = str2duration.ParseDuration(conf.ShutdownLen)
+	if err != nil {
+		return fmt.Errorf("failed to parse shutdown_delay: %v", err.Error())
+	}
+	err = initFlood(conf)
+	if err != nil {
+		return err
+	}
+	err = initLogin(conf)
+	if err != nil {
+		return err
+	}
+	if conf.EnableApproval {
+		approvalThreshold, err = str2duration.ParseDuration(conf.ApprovalThreshold)
+		if err != nil {
+			return fmt.Errorf("failed to parse ban approval threshold: %v", err.Error())
+		}
+		approvalTimeout, err = str2duration.ParseDuration(conf.ApprovalTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse ban approval timeout: %v", err.Error())
+		}
+	}
+	if conf.IPv6Prefix < 1 || conf.IPv6Prefix > 128 {
+		return fmt.Errorf("ipv6_prefix_length must be between 1 and 128")
+	}
+	var backupInterval time.Duration
+	if conf.EnableBackup {
+		backupInterval, err = str2duration.ParseDuration(conf.BackupInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse backup interval: %v", err.Error())
+		} else if backupInterval <= 0 {
+			return fmt.Errorf("backup interval must be positive")
+		}
+	}
+	lists, err := settings.LoadBanLists()
+	if err != nil {
+		return fmt.Errorf("failed to load ban lists: %v", err)
+	}
+	err = subscribeBanLists(lists)
+	if err != nil {
+		return err
+	}
+	trustedProxies, err = parseTrustedProxies(conf.Proxies)
+	if err != nil {
+		return err
 	}
+	if conf.ProxyProto && len(trustedProxies) == 0 {
+		return fmt.Errorf("proxy_protocol is enabled, but trusted_proxies is empty")
+	}
+	if usesTLS() {
+		err = loadCertificate()
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %v", err.Error())
+		}
+	}
+	if conf.EnableSSH {
+		err = initSSH()
+		if err != nil {
+			return err
+		}
+	}
+
+	initEvents()
 
 	// Load areas.
 	for _, a := range areaData {
@@ -135,6 +203,7 @@ func InitServer(conf *settings.Config) error {
 		areas = append(areas, area.NewArea(a, len(characters), conf.BufSize, evi_mode))
 	}
 	areaNames = strings.TrimSuffix(areaNames, "#")
+	loadAreaState()
 	if config.Advertise {
 		advert := ms.Advertisement{
 			Port:    config.Port,
@@ -144,9 +213,29 @@ func InitServer(conf *settings.Config) error {
 		if config.EnableWS {
 			advert.WSPort = config.WSPort
 		}
-		go ms.Advertise(config.MSAddr, advert, updatePlayers, advertDone)
+		if config.EnableWSS {
+			advert.WSSPort = config.WSSPort
+		}
+		advertWG.Add(1)
+		go func() {
+			defer advertWG.Done()
+			ms.Advertise(config.MSAddr, advert, updatePlayers, advertDone)
+		}()
 	}
 	initCommands()
+	err = loadCommands()
+	if err != nil {
+		return fmt.Errorf("failed to load commands.toml: %v", err)
+	}
+	if conf.EnableScripts {
+		err = initScripts(conf)
+		if err != nil {
+			return err
+		}
+	}
+	if conf.EnableBackup {
+		go scheduleBackups(backupInterval)
+	}
 	return nil
 }
 
@@ -157,35 +246,67 @@ func ListenTCP() {
 		FatalError <- err
 		return
 	}
+	if config.ProxyProto {
+		listener = &proxyproto.Listener{Listener: listener, Trusted: func(a net.Addr) bool { return isTrustedProxy(a.String()) }}
+	}
+	if config.TCPTLS {
+		listener = tls.NewListener(listener, tlsConfig())
+	}
+	listenerMu.Lock()
+	tcpListener = listener
+	listenerMu.Unlock()
 	logger.LogDebug("TCP listener started.")
 	defer listener.Close()
 
 	for {
 		conn, err := listener.Accept()
-		if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
 			logger.LogError(err.Error())
+			continue
 		}
-		ipid := getIpid(conn.RemoteAddr().String())
-		if logger.DebugNetwork {
-			logger.LogDebugf("Connection recieved from %v", ipid)
-		}
-		client := NewClient(conn, ipid)
-		go client.HandleClient()
+		go func() {
+			client := NewClient(conn, conn.RemoteAddr().String()) // This may wait for a PROXY protocol header, so it is done outside the accept loop.
+			if logger.DebugNetwork {
+				logger.LogDebugf("Connection recieved from %v", client.Ipid())
+			}
+			client.HandleClient()
+		}()
 	}
 }
 
 // ListenWS starts the server's websocket listener.
 func ListenWS() {
-	listener, err := net.Listen("tcp", config.Addr+":"+strconv.Itoa(config.WSPort))
+	listenWS(config.WSPort, nil)
+}
+
+// ListenWSS starts the server's secure websocket listener.
+func ListenWSS() {
+	listenWS(config.WSSPort, tlsConfig())
+}
+
+// listenWS serves websocket connections on the given port, using TLS if tlsConf is not nil.
+func listenWS(port int, tlsConf *tls.Config) {
+	listener, err := net.Listen("tcp", config.Addr+":"+strconv.Itoa(port))
 	if err != nil {
 		FatalError <- err
 		return
 	}
-	logger.LogDebug("WS listener started.")
+	if tlsConf != nil {
+		listener = tls.NewListener(listener, tlsConf)
+		logger.LogDebug("WSS listener started.")
+	} else {
+		logger.LogDebug("WS listener started.")
+	}
 	defer listener.Close()
 
-	s := &http.Server{}
-	http.HandleFunc("/", HandleWS)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", HandleWS)
+	s := &http.Server{Handler: mux}
+	listenerMu.Lock()
+	wsServers = append(wsServers, s)
+	listenerMu.Unlock()
 	err = s.Serve(listener)
 	if err != http.ErrServerClosed {
 		FatalError <- err
@@ -194,16 +315,15 @@ func ListenWS() {
 
 // HandleWS handles a websocket connection.
 func HandleWS(w http.ResponseWriter, r *http.Request) {
-	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: []string{"web.aceattorneyonline.com"}}) // WS connections not originating from webAO will be rejected.
+	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: config.WSOrigins}) // WS connections not originating from an allowed webAO host will be rejected.
 	if err != nil {
 		logger.LogError(err.Error())
 		return
 	}
-	ipid := getIpid(r.RemoteAddr)
+	client := NewClient(websocket.NetConn(context.TODO(), c, websocket.MessageText), forwardedAddr(r.RemoteAddr, r.Header))
 	if logger.DebugNetwork {
-		logger.LogDebugf("Connection recieved from %v", ipid)
+		logger.LogDebugf("Connection recieved from %v", client.Ipid())
 	}
-	client := NewClient(websocket.NetConn(context.TODO(), c, websocket.MessageText), ipid)
 	go client.HandleClient()
 }
 
@@ -232,7 +352,18 @@ func addToBuffer(client *Client, action string, message string, audit bool) {
 		time.Now().UTC().Format("15:04:05"), action, client.CurrentCharacter(), client.Ipid(), client.OOCName(), message)
 	client.Area().UpdateBuffer(s)
 	if audit {
-		logger.WriteAudit(s)
+		writeAudit(s)
+	}
+}
+
+// updateAdvertiser sends the current player count to the advertiser, if it is running.
+func updateAdvertiser() {
+	if !config.Advertise {
+		return
+	}
+	select {
+	case updatePlayers <- players.GetPlayerCount():
+	case <-advertDone:
 	}
 }
 
@@ -297,6 +428,96 @@ func getRole(name string) (permissions.Role, error) {
 	return permissions.Role{}, fmt.Errorf("role does not exist")
 }
 
+// userPermissions returns a user's effective permissions: those of their role, with their overrides applied.
+func userPermissions(u db.User) (permissions.Set, error) {
+	if u.Role == "" {
+		return permissions.ApplyOverrides(permissions.FromLegacy(u.LegacyPermissions), u.Grants, u.Denies), nil
+	}
+	perms, err := permissions.RolePermissions(roles, u.Role)
+	if err != nil {
+		return permissions.Set{}, err
+	}
+	return permissions.ApplyOverrides(perms, u.Grants, u.Denies), nil
+}
+
+// userAreaPermissions returns the permissions a user has been granted in individual areas, keyed by area name.
+// The user's denied permissions are removed from these too. Grants of roles that no longer exist are ignored.
+func userAreaPermissions(u db.User) map[string]permissions.Set {
+	grants, err := store.AreaRoles(u.Name)
+	if err != nil {
+		logger.LogErrorf("Error reading area roles of %v: %v", u.Name, err)
+		return nil
+	}
+	perms := make(map[string]permissions.Set)
+	for _, g := range grants {
+		p, err := permissions.RolePermissions(roles, g.Role)
+		if err != nil {
+			logger.LogWarningf("Ignoring %v's role in %v: %v", u.Name, g.Area, err)
+			continue
+		}
+		perms[g.Area] = perms[g.Area].Union(permissions.ApplyOverrides(p, nil, u.Denies))
+	}
+	return perms
+}
+
+// refreshUser updates the role and permissions of every client logged in as the given user.
+func refreshUser(username string) error {
+	u, err := store.GetUser(username)
+	if err != nil {
+		return err
+	}
+	perms, err := userPermissions(u)
+	if err != nil {
+		return err
+	}
+	areaPerms := userAreaPermissions(u)
+	for c := range clients.GetAllClients() {
+		if c.Authenticated() && c.ModName() == username {
+			c.SetRole(u.Role)
+			c.SetPerms(perms)
+			c.SetAreaPerms(areaPerms)
+		}
+	}
+	for _, c := range sshClients() {
+		if c.ModName() == username {
+			c.SetRole(u.Role)
+			c.SetPerms(perms)
+			c.SetAreaPerms(areaPerms)
+		}
+	}
+	return nil
+}
+
+// assignLegacyRoles gives a role to each user from before roles were stored, choosing the role with exactly the user's permissions.
+// Users without a matching role keep their permissions until they are given one with /setrole.
+func assignLegacyRoles() error {
+	users, err := store.Users()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if u.Role != "" {
+			continue
+		}
+		assigned := false
+		for _, r := range roles {
+			if perms, err := permissions.RolePermissions(roles, r.Name); err == nil && perms.Equal(permissions.FromLegacy(u.LegacyPermissions)) {
+				err = store.SetRole(u.Name, r.Name)
+				if err != nil {
+					return err
+				}
+				logger.LogInfof("Assigned role %v to user %v.", r.Name, u.Name)
+				assigned = true
+				break
+			}
+		}
+		if !assigned {
+			logger.LogWarningf("User %v has no role matching their permissions, and will keep them until given a role with /setrole.", u.Name)
+		}
+	}
+	return nil
+}
+
 // getClientByUid returns the client with the given uid.
 func getClientByUid(uid int) (*Client, error) {
 	for c := range clients.GetAllClients() {
@@ -307,6 +528,35 @@ func getClientByUid(uid int) (*Client, error) {
 	return nil, fmt.Errorf("client does not exist")
 }
 
+// findArea returns the area with the given name, ignoring case, or ID.
+func findArea(name string) (*area.Area, error) {
+	for i, a := range areas {
+		if strings.EqualFold(a.Name(), name) || strconv.Itoa(i) == name {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not an area", name)
+}
+
+// parseStatus returns the area status with the given name, such as "casing".
+func parseStatus(name string) (area.Status, bool) {
+	switch strings.ToLower(name) {
+	case "idle":
+		return area.StatusIdle, true
+	case "looking-for-players":
+		return area.StatusPlayers, true
+	case "casing":
+		return area.StatusCasing, true
+	case "recess":
+		return area.StatusRecess, true
+	case "rp":
+		return area.StatusRP, true
+	case "gaming":
+		return area.StatusGaming, true
+	}
+	return area.StatusIdle, false
+}
+
 // getClientsByIpid returns all clients with the given ipid.
 func getClientsByIpid(ipid string) []*Client {
 	var returnlist []*Client
@@ -323,22 +573,48 @@ func sendAreaServerMessage(area *area.Area, message string) {
 	writeToArea(area, "CT", encode(config.Name), encode(message), "1")
 }
 
+// sendGlobalServerMessage sends a server OOC message to all clients.
+func sendGlobalServerMessage(message string) {
+	writeToAll("CT", encode(config.Name), encode(message), "1")
+}
+
 // CleanupServer closes all connections to the server, and closes the server's database.
 func CleanupServer() {
 	for client := range clients.GetAllClients() {
 		client.conn.Close()
 	}
-	db.Close()
+	closeScripts()
+	bus.Close()
+	store.Close()
 }
 
-// Returns the IPID for a given IP address.
+// Returns the IPID for a given IP address, with or without a port.
 func getIpid(s string) string {
 	// For privacy and ease of use, AO servers traditionally use a hashed version of a client's IP address to identify a client.
-	// Athena uses the MD5 hash of the IP address, encoded in base64.
-	addr := strings.Split(s, ":")
-	hash := md5.Sum([]byte(strings.Join(addr[:len(addr)-1], ":")))
-	ipid := base64.StdEncoding.EncodeToString(hash[:])
-	return ipid[:len(ipid)-2] // Removes the trailing padding.
+	// Athena uses a keyed hash of the IP address, so that IPIDs cannot be reversed without the server's secret.
+	return hashID(ipidKey(s, config.IPv6Prefix))
+}
+
+// Returns the IPID given to an IP address by previous versions of athena.
+func getLegacyIpid(s string) string {
+	return legacyHashID(ipidKey(s, config.IPv6Prefix))
+}
+
+// ipidKey returns the value hashed to produce an address's IPID.
+// IPv6 addresses are grouped by their prefix, since a single user is usually given an entire prefix (typically a /64) to use as they please.
+func ipidKey(s string, prefixLen int) string {
+	addr, err := parseAddr(s)
+	if err != nil {
+		return s
+	}
+	if addr.Is6() {
+		p, err := addr.Prefix(prefixLen)
+		if err != nil {
+			return addr.String()
+		}
+		return p.String()
+	}
+	return addr.String()
 }
 
 // getParrotMsg returns a random string from the server's parrot list.
@@ -0,0 +1,72 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+)
+
+func TestIpidKey(t *testing.T) {
+	tests := []struct {
+		addr   string
+		prefix int
+		want   string
+	}{
+		{"192.0.2.1:27016", 64, "192.0.2.1"},
+		{"192.0.2.1", 64, "192.0.2.1"},
+		{"[::ffff:192.0.2.1]:27016", 64, "192.0.2.1"},
+		{"::ffff:192.0.2.1", 64, "192.0.2.1"},
+		{"[2001:db8:1:2:3:4:5:6]:27016", 64, "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:3:4:5:6", 64, "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:3:4:5:6]:27016", 48, "2001:db8:1::/48"},
+		{"[2001:db8:1:2:3:4:5:6]:27016", 128, "2001:db8:1:2:3:4:5:6/128"},
+		{"[fe80::1%eth0]:27016", 64, "fe80::/64"},
+	}
+	for _, tt := range tests {
+		if got := ipidKey(tt.addr, tt.prefix); got != tt.want {
+			t.Errorf("ipidKey(%q, %d) = %q, want %q", tt.addr, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestGetIpid(t *testing.T) {
+	config = &settings.Config{ServerConfig: settings.ServerConfig{IPv6Prefix: 64}}
+	hashSecret = make([]byte, secretSize)
+	defer func() { config, hashSecret = nil, nil }()
+
+	// Legacy IPIDs must match those of previous versions, so existing bans keep working.
+	if got := getLegacyIpid("192.0.2.1:27016"); got != "0PiNbId2cmK6jpPWrMzXhA" {
+		t.Errorf("unexpected legacy IPID, got %v", got)
+	}
+	if getIpid("192.0.2.1:27016") == getLegacyIpid("192.0.2.1:27016") {
+		t.Errorf("IPID is not keyed")
+	}
+	if getIpid("[::ffff:192.0.2.1]:1234") != getIpid("192.0.2.1:27016") {
+		t.Errorf("IPv4-mapped IPv6 address has a different IPID than its IPv4 address")
+	}
+	if getIpid("[2001:db8::1]:1234") != getIpid("[2001:db8::ffff:2]:5678") {
+		t.Errorf("addresses in the same /64 have different IPIDs")
+	}
+	if getIpid("[2001:db8::1]:1234") == getIpid("[2001:db8:0:1::1]:1234") {
+		t.Errorf("addresses in different /64s have the same IPID")
+	}
+	if len(getIpid("[2001:db8::1]:1234")) != 22 {
+		t.Errorf("unexpected IPID length, got %d", len(getIpid("[2001:db8::1]:1234")))
+	}
+}
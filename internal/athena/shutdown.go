@@ -0,0 +1,187 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/area"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/xhit/go-str2duration/v2"
+)
+
+var (
+	shutdownOnce sync.Once
+	shuttingDown bool
+	shutdownMu   sync.Mutex
+	ShutdownDone = make(chan struct{}) // Signals that the server has finished shutting down.
+)
+
+// The remaining times at which players are reminded of a pending shutdown.
+var shutdownWarnings = []time.Duration{10 * time.Minute, 5 * time.Minute, time.Minute, 30 * time.Second, 10 * time.Second, 5 * time.Second}
+
+// Shutdown begins the server's shutdown sequence, returning false if a shutdown is already in progress.
+// A negative delay or empty reason uses the server's configured shutdown delay or message.
+func Shutdown(delay time.Duration, reason string) bool {
+	started := false
+	shutdownOnce.Do(func() {
+		shutdownMu.Lock()
+		shuttingDown = true
+		shutdownMu.Unlock()
+		if delay < 0 {
+			delay = shutdownLen
+		}
+		if reason == "" {
+			reason = config.ShutdownMsg
+		}
+		started = true
+		go runShutdown(delay, reason)
+	})
+	return started
+}
+
+// ShuttingDown returns whether the server is shutting down.
+func ShuttingDown() bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	return shuttingDown
+}
+
+// runShutdown stops the server's listeners, warns players, and then persists server state and disconnects all clients.
+func runShutdown(delay time.Duration, reason string) {
+	logger.LogInfof("Shutting down in %v: %v", delay, reason)
+	stopListeners()
+
+	if delay > 0 {
+		writeToAll("TI", "0", "2")
+		writeToAll("TI", "0", "0", strconv.FormatInt(delay.Milliseconds(), 10))
+		end := time.Now().Add(delay)
+		for remaining := delay; remaining > 0; remaining = time.Until(end) {
+			sendGlobalServerMessage(fmt.Sprintf("%v (%v remaining)", reason, remaining.Round(time.Second)))
+			next := time.Duration(0)
+			for _, w := range shutdownWarnings {
+				if w < remaining-time.Second {
+					next = w
+					break
+				}
+			}
+			time.Sleep(time.Until(end.Add(-next)))
+		}
+		writeToAll("TI", "0", "3")
+	}
+
+	saveAreaState()
+	if config.Advertise {
+		close(advertDone)
+		advertWG.Wait()
+	}
+	for client := range clients.GetAllClients() {
+		client.SendPacket("KK", reason)
+		client.conn.Close()
+	}
+	endSSHSessions("")
+	closeScripts()
+	bus.Close()
+	store.Close()
+	logger.LogInfo("Shutdown complete.")
+	close(ShutdownDone)
+}
+
+// stopListeners stops the server from accepting new connections.
+func stopListeners() {
+	listenerMu.Lock()
+	defer listenerMu.Unlock()
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	for _, s := range wsServers {
+		s.Close()
+	}
+	if sshListener != nil {
+		sshListener.Close()
+	}
+}
+
+// parseShutdownArgs parses the arguments of a shutdown command into a delay and a message.
+// If the first argument is not a duration, the configured delay is used and all arguments form the message.
+func parseShutdownArgs(args []string) (time.Duration, string) {
+	if len(args) > 0 {
+		if d, err := str2duration.ParseDuration(args[0]); err == nil {
+			return d, strings.Join(args[1:], " ")
+		}
+	}
+	return -1, strings.Join(args, " ")
+}
+
+// stateFile returns the path of the file area state is persisted to.
+func stateFile() string {
+	return settings.ConfigPath + "/state.json"
+}
+
+// saveAreaState persists the evidence, docs, and testimonies of all areas.
+func saveAreaState() {
+	var states []area.State
+	for _, a := range areas {
+		states = append(states, a.State())
+	}
+	b, err := json.Marshal(states)
+	if err != nil {
+		logger.LogErrorf("Failed to save area state: %v", err)
+		return
+	}
+	err = os.WriteFile(stateFile(), b, 0644)
+	if err != nil {
+		logger.LogErrorf("Failed to save area state: %v", err)
+	}
+}
+
+// loadAreaState restores area state saved during the last shutdown.
+// The saved state is removed afterwards, so it is only restored once.
+func loadAreaState() {
+	b, err := os.ReadFile(stateFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		logger.LogErrorf("Failed to load area state: %v", err)
+		return
+	}
+	var states []area.State
+	err = json.Unmarshal(b, &states)
+	if err != nil {
+		logger.LogErrorf("Failed to load area state: %v", err)
+		return
+	}
+	for _, s := range states {
+		for _, a := range areas {
+			if a.Name() == s.Name {
+				a.Restore(s)
+			}
+		}
+	}
+	if err := os.Remove(stateFile()); err != nil {
+		logger.LogErrorf("Failed to remove area state: %v", err)
+	}
+	logger.LogInfo("Restored area state from the last shutdown.")
+}
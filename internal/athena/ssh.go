@@ -0,0 +1,401 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+var (
+	sshListener net.Listener
+	sshConfig   *ssh.ServerConfig
+	sshSessions = make(map[*Client]ssh.Conn) // The client of each open SSH session, and its connection.
+	sshMu       sync.Mutex
+
+	sshLoginTimeout = 2 * time.Minute // How long a connection has to finish the handshake and log in, as OpenSSH's LoginGraceTime.
+)
+
+// initSSH sets up the SSH server, loading its host key.
+func initSSH() error {
+	signer, err := loadHostKey(sshPath(config.HostKey, "ssh_host_key"))
+	if err != nil {
+		return fmt.Errorf("failed to load SSH host key: %v", err)
+	}
+	sshConfig = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return sshLogin(conn, string(password), nil)
+		},
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			} else if len(answers) != 1 {
+				return nil, errors.New("no password given")
+			}
+			return sshLogin(conn, answers[0], challenge)
+		},
+		PublicKeyCallback: sshPublicKey,
+		ServerVersion:     "SSH-2.0-Athena",
+	}
+	sshConfig.AddHostKey(signer)
+	return nil
+}
+
+// sshPath returns the configured path of an SSH file, or the file with the given name in the config directory if none is set.
+func sshPath(path string, name string) string {
+	if path == "" {
+		return settings.ConfigPath + "/" + name
+	}
+	return path
+}
+
+// loadHostKey reads the SSH host key at path, generating a new key if the file does not exist.
+func loadHostKey(path string) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		b = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return nil, err
+		}
+		logger.LogInfof("Generated SSH host key %v.", path)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// sshAudit writes an SSH login event to the audit log, in the same format as area buffers.
+func sshAudit(ipid string, username string, message string) {
+	writeAudit(fmt.Sprintf("%v | AUTH | Spectator | %v | %v | %v", time.Now().UTC().Format("15:04:05"), ipid, username, message))
+}
+
+// sshLogin checks an SSH login with a password, following the same rules as /login.
+// If the user has two-factor authentication, their code is asked for with challenge, and the login fails if challenge is nil.
+func sshLogin(conn ssh.ConnMetadata, password string, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	ipid, username := getIpid(conn.RemoteAddr().String()), conn.User()
+	if d := loginLockedFor(ipid, username); d > 0 {
+		sshAudit(ipid, username, fmt.Sprintf("Refused SSH login as %v while locked out.", username))
+		return nil, fmt.Errorf("locked out for %v", d)
+	}
+	fail := func(reason string) error {
+		sshAudit(ipid, username, fmt.Sprintf("Failed SSH login as %v: %v.", username, reason))
+		bus.Publish(events.Login{Username: username, IPID: ipid, Method: "ssh", Reason: reason})
+		if d := failLogin(ipid, username); d > 0 {
+			sshAudit(ipid, username, fmt.Sprintf("Locked out logins as %v for %v.", username, d))
+		}
+		return errors.New(reason)
+	}
+
+	if !store.AuthenticateUser(username, []byte(password)) {
+		return nil, fail("wrong username or password")
+	}
+	user, err := store.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := store.TOTPSecret(username)
+	if err != nil {
+		logger.LogErrorf("while reading two-factor secret of %v: %v", username, err)
+		return nil, err
+	}
+	if secret == "" && requiresTOTP(user.Role) {
+		return nil, fail("role requires two-factor authentication, but none is enrolled")
+	} else if secret != "" {
+		// Password authentication cannot ask for a code, so the client must use keyboard-interactive authentication instead.
+		if challenge == nil {
+			return nil, fail("missing two-factor code")
+		}
+		answers, err := challenge("", "", []string{"Verification code: "}, []bool{true})
+		if err != nil {
+			return nil, err
+		} else if len(answers) != 1 || !checkTOTP(username, secret, answers[0]) {
+			return nil, fail("wrong two-factor code")
+		}
+	}
+	accountLockout.Reset(username)
+	ipidLockout.Reset(ipid)
+	return &ssh.Permissions{}, nil
+}
+
+// sshPublicKey checks an SSH login with a public key against the authorized keys file.
+// Failures are not counted towards lockouts, as clients try each of their keys in turn.
+func sshPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	ipid, username := getIpid(conn.RemoteAddr().String()), conn.User()
+	if d := loginLockedFor(ipid, username); d > 0 {
+		return nil, fmt.Errorf("locked out for %v", d)
+	}
+	if !authorizedKey(username, key) {
+		return nil, errors.New("unauthorized key")
+	}
+	// A key login cannot also ask for a two-factor code, so users who have or need one must log in with their password.
+	user, err := store.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := store.TOTPSecret(username)
+	if err != nil {
+		logger.LogErrorf("while reading two-factor secret of %v: %v", username, err)
+		return nil, err
+	}
+	if secret != "" || requiresTOTP(user.Role) {
+		return nil, errors.New("two-factor authentication required")
+	}
+	return &ssh.Permissions{}, nil
+}
+
+// authorizedKey returns whether key may log in as the given user.
+// The file is read on each login, so that keys can be added and removed without restarting the server.
+func authorizedKey(username string, key ssh.PublicKey) bool {
+	b, err := os.ReadFile(sshPath(config.AuthorizedKeys, "ssh_authorized_keys"))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.LogErrorf("Failed to read SSH authorized keys: %v", err)
+		}
+		return false
+	}
+	for len(b) > 0 {
+		k, comment, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			break
+		}
+		if comment == username && bytes.Equal(k.Marshal(), key.Marshal()) {
+			return store.UserExists(username)
+		}
+		b = rest
+	}
+	return false
+}
+
+// ListenSSH starts the server's SSH listener.
+func ListenSSH() {
+	listener, err := net.Listen("tcp", config.SSHAddr+":"+strconv.Itoa(config.SSHPort))
+	if err != nil {
+		FatalError <- err
+		return
+	}
+	listenerMu.Lock()
+	sshListener = listener
+	listenerMu.Unlock()
+	logger.LogDebug("SSH listener started.")
+	serveSSH(listener)
+}
+
+// serveSSH accepts SSH connections on a listener until it is closed.
+func serveSSH(listener net.Listener) {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			logger.LogError(err.Error())
+			continue
+		}
+		go handleSSH(conn)
+	}
+}
+
+// handleSSH handles an SSH connection, serving a console for each session opened on it.
+func handleSSH(nConn net.Conn) {
+	nConn.SetDeadline(time.Now().Add(sshLoginTimeout))
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, sshConfig)
+	if err != nil {
+		logger.LogDebugf("SSH handshake with %v failed: %v", nConn.RemoteAddr(), err)
+		nConn.Close()
+		return
+	}
+	nConn.SetDeadline(time.Time{})
+	defer conn.Close()
+	bus.Publish(events.Login{Username: conn.User(), IPID: getIpid(conn.RemoteAddr().String()), Method: "ssh", Success: true})
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChan.Accept()
+		if err != nil {
+			logger.LogError(err.Error())
+			continue
+		}
+		go serveSSHSession(conn, channel, requests)
+	}
+}
+
+// serveSSHSession runs a console on an SSH session, as the user who logged in.
+// A session either runs a single command, as in `ssh host command`, or reads commands until it is closed.
+func serveSSHSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	t := term.NewTerminal(channel, "> ")
+	t.AutoCompleteCallback = completeCommand
+
+	start := make(chan *string, 1) // Receives the command to run, or nil for a shell.
+	go func() {
+		started := false
+		for req := range requests {
+			ok := false
+			switch req.Type {
+			case "pty-req":
+				var pty struct {
+					Term                      string
+					Cols, Rows, Width, Height uint32
+					Modes                     string
+				}
+				if ssh.Unmarshal(req.Payload, &pty) == nil {
+					t.SetSize(int(pty.Cols), int(pty.Rows))
+					ok = true
+				}
+			case "window-change":
+				var size struct{ Cols, Rows, Width, Height uint32 }
+				if ssh.Unmarshal(req.Payload, &size) == nil {
+					t.SetSize(int(size.Cols), int(size.Rows))
+					ok = true
+				}
+			case "shell":
+				if !started {
+					started, ok = true, true
+					start <- nil
+				}
+			case "exec":
+				var exec struct{ Command string }
+				if !started && ssh.Unmarshal(req.Payload, &exec) == nil {
+					started, ok = true, true
+					start <- &exec.Command
+				}
+			}
+			req.Reply(ok, nil)
+		}
+		if !started {
+			close(start)
+		}
+	}()
+	command, ok := <-start
+	if !ok {
+		return
+	}
+
+	client, err := newSSHClient(conn.User(), conn.RemoteAddr().String(), t)
+	if err != nil {
+		logger.LogErrorf("User %v cannot start an SSH session: %v", conn.User(), err)
+		fmt.Fprintln(t, "Your account is not valid. Ask an administrator to fix it.")
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+		return
+	}
+	sshMu.Lock()
+	sshSessions[client] = conn
+	sshMu.Unlock()
+	addToBuffer(client, "AUTH", fmt.Sprintf("Started an SSH session as %v.", client.ModName()), true)
+	defer func() {
+		sshMu.Lock()
+		delete(sshSessions, client)
+		sshMu.Unlock()
+		addToBuffer(client, "AUTH", fmt.Sprintf("Ended an SSH session as %v.", client.ModName()), true)
+	}()
+
+	if command != nil {
+		runSSHCommand(client, *command, t)
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+	fmt.Fprintf(t, "Welcome, %v. Type help for a list of commands.\n", client.ModName())
+	for {
+		line, err := t.ReadLine()
+		if err != nil && err != term.ErrPasteIndicator {
+			return
+		}
+		runSSHCommand(client, line, t)
+	}
+}
+
+// newSSHClient returns a console client for an SSH session, with the permissions of the given user.
+func newSSHClient(username string, addr string, out io.Writer) (*Client, error) {
+	user, err := store.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := userPermissions(user)
+	if err != nil {
+		return nil, err
+	}
+	client := newConsoleClient(out, areas[0], false)
+	client.ipid = getIpid(addr)
+	client.SetOocName(username)
+	client.SetModName(username)
+	client.SetRole(user.Role)
+	client.SetPerms(perms)
+	client.SetAreaPerms(userAreaPermissions(user))
+	return client, nil
+}
+
+// runSSHCommand audits and runs a line entered in an SSH session.
+// Only the command's name is recorded, as some commands take passwords.
+func runSSHCommand(client *Client, line string, out io.Writer) {
+	if args, err := splitArgs(line); err == nil && len(args) > 0 {
+		addToBuffer(client, "CMD", fmt.Sprintf("Ran %v over SSH.", strings.TrimPrefix(args[0], "/")), true)
+	}
+	runConsoleCommand(client, line, out)
+}
+
+// sshClients returns the clients of open SSH sessions.
+func sshClients() []*Client {
+	sshMu.Lock()
+	defer sshMu.Unlock()
+	l := make([]*Client, 0, len(sshSessions))
+	for c := range sshSessions {
+		l = append(l, c)
+	}
+	return l
+}
+
+// endSSHSessions closes the SSH sessions logged in as the given user, or every session if username is empty.
+func endSSHSessions(username string) {
+	sshMu.Lock()
+	defer sshMu.Unlock()
+	for c, conn := range sshSessions {
+		if username == "" || c.ModName() == username {
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/permissions"
+	"github.com/MangosArentLiterature/Athena/internal/settings"
+	"github.com/MangosArentLiterature/Athena/internal/totp"
+	"golang.org/x/crypto/ssh"
+)
+
+// runSSH runs a command over SSH as the given user, returning its output.
+func runSSH(addr string, user string, auth ssh.AuthMethod, command string) (string, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	session, err := conn.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	out, err := session.Output(command)
+	return string(out), err
+}
+
+func TestSSH(t *testing.T) {
+	setupTestServer(t)
+	path := settings.ConfigPath
+	settings.ConfigPath = t.TempDir()
+	t.Cleanup(func() { settings.ConfigPath = path })
+	store.CreateUser("mod", []byte("password"), "moderator")
+	store.CreateUser("other", []byte("password"), "moderator")
+	roles = append(roles, permissions.Role{Name: "admin", Permissions: []string{"ADMIN"}})
+	store.CreateUser("admin", []byte("password"), "admin")
+
+	if err := initSSH(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(settings.ConfigPath + "/ssh_host_key"); err != nil {
+		t.Errorf("host key was not generated: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := sshLoginTimeout
+	sshLoginTimeout = time.Second
+	t.Cleanup(func() { sshLoginTimeout = timeout })
+	go serveSSH(listener)
+	t.Cleanup(func() { listener.Close() })
+	addr := listener.Addr().String()
+
+	// Connections that never finish logging in are closed.
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, idle); err != nil {
+		t.Errorf("connection that never logged in was not closed: %v", err)
+	}
+	idle.Close()
+
+	if _, err := runSSH(addr, "mod", ssh.Password("wrong"), "whoami"); err == nil {
+		t.Errorf("logged in with wrong password")
+	}
+	out, err := runSSH(addr, "mod", ssh.Password("password"), "whoami")
+	if err != nil || !strings.Contains(out, "Logged in as mod.") || !strings.Contains(out, "BAN") {
+		t.Errorf("whoami over SSH = %q, %v", out, err)
+	}
+	if out, _ := runSSH(addr, "mod", ssh.Password("password"), "say hello"); !strings.Contains(out, "Invalid command.") {
+		t.Errorf("moderator used a console-only command, got %q", out)
+	}
+	if out, _ := runSSH(addr, "mod", ssh.Password("password"), "mkusr new password moderator"); store.UserExists("new") {
+		t.Errorf("moderator ran a command without permission, got %q", out)
+	}
+	if !strings.Contains(strings.Join(areas[0].Buffer(), "\n"), "| mod | Ran whoami over SSH.") {
+		t.Errorf("command was not audited under the moderator's name")
+	}
+
+	// Console-only commands can read and write the server's files, so even administrators cannot use them over SSH.
+	exported := settings.ConfigPath + "/exported.json"
+	for _, cmd := range []string{"say hello", "ban export " + exported, "totp enroll mod"} {
+		if out, _ := runSSH(addr, "admin", ssh.Password("password"), cmd); strings.Contains(out, "Add this secret") || strings.Contains(out, "xported") {
+			t.Errorf("administrator used console-only command %q over SSH, got %q", cmd, out)
+		}
+	}
+	if secret, _ := store.TOTPSecret("mod"); secret != "" {
+		t.Errorf("administrator enrolled a user in two-factor authentication over SSH")
+	}
+	if _, err := os.Stat(exported); err == nil {
+		t.Errorf("administrator exported bans to a file over SSH")
+	}
+
+	// An SSH session is never counted as a player, wherever it moves.
+	player, _ := newTestClient(0, "192.0.2.1:1234")
+	player.JoinArea(areas[0])
+	out, _ = runSSH(addr, "admin", ssh.Password("password"), "move 1")
+	if !strings.Contains(out, "Moved to") || areas[0].PlayerCount() != 1 || areas[1].PlayerCount() != 0 {
+		t.Errorf("moving over SSH left %v players in area 0 and %v in area 1, got %q", areas[0].PlayerCount(), areas[1].PlayerCount(), out)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(priv)
+	key := ssh.PublicKeys(signer)
+	if _, err := runSSH(addr, "mod", key, "whoami"); err == nil {
+		t.Errorf("logged in with an unauthorized key")
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " mod\n"
+	if err := os.WriteFile(settings.ConfigPath+"/ssh_authorized_keys", []byte(authorized), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runSSH(addr, "mod", key, "whoami"); err != nil || !strings.Contains(out, "Logged in as mod.") {
+		t.Errorf("whoami with key = %q, %v", out, err)
+	}
+	if _, err := runSSH(addr, "other", key, "whoami"); err == nil {
+		t.Errorf("key logged in as another user")
+	}
+
+	// Keys cannot get around two-factor authentication, whether the user's role requires it or they enrolled themselves.
+	roles = append(roles, permissions.Role{Name: "secure", Permissions: []string{"KICK"}, RequireTOTP: true})
+	store.CreateUser("secure", []byte("password"), "secure")
+	authorized += strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " secure\n"
+	authorized += strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " other\n"
+	if err := os.WriteFile(settings.ConfigPath+"/ssh_authorized_keys", []byte(authorized), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runSSH(addr, "secure", key, "whoami"); err == nil {
+		t.Errorf("key logged in as a user whose role requires two-factor authentication")
+	}
+	secret, _ := totp.NewSecret()
+	store.SetTOTPSecret("other", secret)
+	if _, err := runSSH(addr, "other", key, "whoami"); err == nil {
+		t.Errorf("key logged in as a user with two-factor authentication")
+	}
+}
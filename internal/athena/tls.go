@@ -0,0 +1,75 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package athena
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+)
+
+var (
+	certificate *tls.Certificate
+	certMu      sync.RWMutex
+)
+
+// loadCertificate reads the server's TLS certificate and key from disk.
+func loadCertificate() error {
+	if config.TLSCert == "" || config.TLSKey == "" {
+		return fmt.Errorf("tls_cert and tls_key must be set to use TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return err
+	}
+	certMu.Lock()
+	certificate = &cert
+	certMu.Unlock()
+	return nil
+}
+
+// ReloadCertificate reloads the server's TLS certificate, keeping the current certificate if the new one fails to load.
+func ReloadCertificate() {
+	if !usesTLS() {
+		return
+	}
+	if err := loadCertificate(); err != nil {
+		logger.LogErrorf("Failed to reload TLS certificate: %v", err)
+		return
+	}
+	logger.LogInfo("Reloaded TLS certificate.")
+}
+
+// usesTLS returns whether any of the server's listeners use TLS.
+func usesTLS() bool {
+	return config.EnableWSS || config.TCPTLS
+}
+
+// tlsConfig returns the TLS configuration for the server's listeners.
+// Certificates are looked up on each handshake, so reloaded certificates apply to new connections immediately.
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			certMu.RLock()
+			defer certMu.RUnlock()
+			return certificate, nil
+		},
+	}
+}
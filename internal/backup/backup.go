@@ -0,0 +1,175 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package backup writes and prunes timestamped database backups.
+//
+// Each backup is a copy of the database, named athena-<time>.db, and optionally a copy of a secret file kept
+// alongside it as athena-<time>.secret.
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The layout of the timestamp in a backup's file name.
+const nameLayout = "20060102T150405.000Z"
+
+// The layout used to parse a backup's timestamp. time.Parse accepts fractional seconds that the layout leaves
+// out, so this also parses the names of backups made before milliseconds were added.
+const timeLayout = "20060102T150405Z"
+
+const (
+	prefix       = "athena-"
+	suffix       = ".db"
+	secretSuffix = ".secret"
+)
+
+// A Backuper can write a consistent copy of itself to a file.
+type Backuper interface {
+	Backup(path string) error
+}
+
+// Name returns the file name of a backup made at the given time.
+func Name(t time.Time) string {
+	return prefix + t.UTC().Format(nameLayout) + suffix
+}
+
+// parseName returns the time a backup was made from its file name.
+func parseName(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+	return t, err == nil
+}
+
+// Run writes a new backup into dir, along with a copy of the file at secret if it is not empty, then deletes
+// backups that are no longer retained. It returns the path of the new backup.
+func Run(b Backuper, dir string, secret string, keepDaily int, keepWeekly int) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	// Backups are never overwritten, so move past the time of any backup made in the same millisecond.
+	t := time.Now()
+	path := filepath.Join(dir, Name(t))
+	for exists(path) {
+		t = t.Add(time.Millisecond)
+		path = filepath.Join(dir, Name(t))
+	}
+	err = b.Backup(path)
+	if err != nil {
+		return "", err
+	}
+	if secret != "" {
+		err = copyFile(secret, secretPath(path))
+		if err != nil {
+			return "", fmt.Errorf("failed to copy %v: %v", filepath.Base(secret), err)
+		}
+	}
+	return path, Prune(dir, keepDaily, keepWeekly)
+}
+
+// secretPath returns the path of the secret kept alongside the backup at path.
+func secretPath(path string) string {
+	return strings.TrimSuffix(path, suffix) + secretSuffix
+}
+
+// exists reports whether a file exists at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// copyFile copies the file at src to a new file at dst, readable only by its owner.
+func copyFile(src string, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0600)
+}
+
+// Prune deletes all backups in dir, and their secrets, except the newest backup of each of the last keepDaily
+// days and keepWeekly weeks.
+// The newest backup is always kept.
+func Prune(dir string, keepDaily int, keepWeekly int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	backups := make(map[time.Time]string)
+	var times []time.Time
+	for _, e := range entries {
+		if t, ok := parseName(e.Name()); ok && !e.IsDir() {
+			backups[t] = e.Name()
+			times = append(times, t)
+		}
+	}
+	keep := retain(times, keepDaily, keepWeekly)
+	for _, t := range times {
+		if !keep[t] {
+			path := filepath.Join(dir, backups[t])
+			err := os.Remove(path)
+			if err != nil {
+				return err
+			}
+			err = os.Remove(secretPath(path))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retain returns the set of backup times that should be kept.
+func retain(times []time.Time, keepDaily int, keepWeekly int) map[time.Time]bool {
+	sorted := append([]time.Time(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].After(sorted[j]) })
+
+	keep := make(map[time.Time]bool)
+	if len(sorted) > 0 {
+		keep[sorted[0]] = true
+	}
+	var lastDay, lastWeek string
+	var days, weeks int
+	for _, t := range sorted {
+		day := t.UTC().Format("2006-01-02")
+		if day != lastDay {
+			lastDay = day
+			days++
+			if days <= keepDaily {
+				keep[t] = true
+			}
+		}
+		y, w := t.UTC().ISOWeek()
+		week := fmt.Sprintf("%d-%02d", y, w)
+		if week != lastWeek {
+			lastWeek = week
+			weeks++
+			if weeks <= keepWeekly {
+				keep[t] = true
+			}
+		}
+	}
+	return keep
+}
@@ -0,0 +1,121 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRetain(t *testing.T) {
+	// Two backups a day for four weeks, ending on Sunday 2022-05-29.
+	end := time.Date(2022, 5, 29, 18, 0, 0, 0, time.UTC)
+	var times []time.Time
+	for i := 0; i < 56; i++ {
+		times = append(times, end.Add(-time.Duration(i)*12*time.Hour))
+	}
+
+	keep := retain(times, 3, 3)
+	var kept []time.Time
+	for t := range keep {
+		kept = append(kept, t)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].After(kept[j]) })
+	want := []time.Time{
+		end,                           // Newest of 05-29, and of the week ending 05-29.
+		end.Add(-24 * time.Hour),      // Newest of 05-28.
+		end.Add(-48 * time.Hour),      // Newest of 05-27.
+		end.Add(-7 * 24 * time.Hour),  // Newest of the week ending 05-22.
+		end.Add(-14 * 24 * time.Hour), // Newest of the week ending 05-15.
+	}
+	if len(kept) != len(want) {
+		t.Fatalf("kept %v, want %v", kept, want)
+	}
+	for i := range want {
+		if !kept[i].Equal(want[i]) {
+			t.Errorf("kept %v, want %v", kept, want)
+			break
+		}
+	}
+
+	if keep := retain(times, 0, 0); len(keep) != 1 || !keep[end] {
+		t.Errorf("newest backup was not kept, got %v", keep)
+	}
+}
+
+type fileBackuper struct{}
+
+// Backup fails if the file exists, as SQLite's VACUUM INTO does.
+func (fileBackuper) Backup(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func TestParseName(t *testing.T) {
+	want := time.Date(2022, 5, 29, 18, 0, 0, 0, time.UTC)
+	for _, name := range []string{"athena-20220529T180000Z.db", Name(want)} {
+		if got, ok := parseName(name); !ok || !got.Equal(want) {
+			t.Errorf("parseName(%q) = %v, %v", name, got, ok)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	old := filepath.Join(dir, Name(time.Now().Add(-48*time.Hour)))
+	other := filepath.Join(dir, "notes.txt")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(old, nil, 0644)
+	os.WriteFile(other, nil, 0644)
+
+	oldSecret := secretPath(old)
+	os.WriteFile(oldSecret, nil, 0600)
+	secret := filepath.Join(t.TempDir(), "athena.secret")
+	os.WriteFile(secret, []byte("secret"), 0600)
+
+	path, err := Run(fileBackuper{}, dir, secret, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("backup was not written: %v", err)
+	}
+	if b, err := os.ReadFile(secretPath(path)); err != nil || string(b) != "secret" {
+		t.Errorf("secret was not copied: %q, %v", b, err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old backup was not pruned")
+	}
+	if _, err := os.Stat(oldSecret); !os.IsNotExist(err) {
+		t.Errorf("old backup's secret was not pruned")
+	}
+	// Backups made in quick succession must not collide.
+	for i := 0; i < 3; i++ {
+		if _, err := Run(fileBackuper{}, dir, "", 1, 0); err != nil {
+			t.Errorf("backup %v failed: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file was deleted")
+	}
+}
@@ -0,0 +1,371 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package banlist reads and writes ban lists in a portable format, for sharing bans between servers.
+package banlist
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/db"
+)
+
+// Format is a ban list file format.
+type Format string
+
+const (
+	JSON Format = "json"
+	CSV  Format = "csv"
+)
+
+// Expiry value used for permanent bans.
+const perma = "perma"
+
+// Ban is a single ban in a ban list.
+type Ban struct {
+	ID        int      `json:"id"`
+	IPID      string   `json:"ipid"`
+	HDID      string   `json:"hdid"`
+	Start     string   `json:"start"`
+	Expiry    string   `json:"expiry"`
+	Reason    string   `json:"reason"`
+	Moderator string   `json:"moderator"`
+	Source    string   `json:"source,omitempty"`
+	Areas     []string `json:"areas,omitempty"`
+}
+
+// List is a ban list, as written to a JSON file.
+type List struct {
+	Server   string `json:"server"`
+	Exported string `json:"exported"`
+	Bans     []Ban  `json:"bans"`
+}
+
+var csvHeader = []string{"id", "ipid", "hdid", "start", "expiry", "reason", "moderator", "source", "areas"}
+
+// The separator between a ban's areas in a CSV file.
+const csvAreaSep = ";"
+
+// FormatOf returns the format of a ban list file, based on its extension.
+func FormatOf(path string) (Format, error) {
+	switch f := Format(strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")); f {
+	case JSON, CSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown ban list format %q, expected .json or .csv", filepath.Ext(path))
+	}
+}
+
+// FromBanInfo converts a ban from the database into a ban list entry.
+func FromBanInfo(b db.BanInfo) Ban {
+	expiry := perma
+	if b.Duration != -1 {
+		expiry = time.Unix(b.Duration, 0).UTC().Format(time.RFC3339)
+	}
+	return Ban{
+		ID:        b.Id,
+		IPID:      b.Ipid,
+		HDID:      b.Hdid,
+		Start:     time.Unix(b.Time, 0).UTC().Format(time.RFC3339),
+		Expiry:    expiry,
+		Reason:    b.Reason,
+		Moderator: b.Moderator,
+		Source:    b.Source,
+		Areas:     b.Areas,
+	}
+}
+
+// BanInfo converts a ban list entry into a ban for the database.
+func (b Ban) BanInfo() (db.BanInfo, error) {
+	start, err := time.Parse(time.RFC3339, b.Start)
+	if err != nil {
+		return db.BanInfo{}, fmt.Errorf("invalid start time for ban %v: %v", b.ID, err)
+	}
+	var duration int64 = -1
+	if b.Expiry != perma {
+		expiry, err := time.Parse(time.RFC3339, b.Expiry)
+		if err != nil {
+			return db.BanInfo{}, fmt.Errorf("invalid expiry for ban %v: %v", b.ID, err)
+		}
+		duration = expiry.Unix()
+	}
+	if b.IPID == "" && b.HDID == "" {
+		return db.BanInfo{}, fmt.Errorf("ban %v has neither an IPID nor an HDID", b.ID)
+	}
+	return db.BanInfo{
+		Id:        b.ID,
+		Ipid:      b.IPID,
+		Hdid:      b.HDID,
+		Time:      start.Unix(),
+		Duration:  duration,
+		Reason:    b.Reason,
+		Moderator: b.Moderator,
+		Source:    b.Source,
+		Areas:     b.Areas,
+	}, nil
+}
+
+// Write writes a ban list in the given format.
+func Write(w io.Writer, f Format, l List) error {
+	switch f {
+	case JSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(l)
+	case CSV:
+		c := csv.NewWriter(w)
+		c.Write(csvHeader)
+		for _, b := range l.Bans {
+			c.Write([]string{strconv.Itoa(b.ID), b.IPID, b.HDID, b.Start, b.Expiry, b.Reason, b.Moderator, b.Source, strings.Join(b.Areas, csvAreaSep)})
+		}
+		c.Flush()
+		return c.Error()
+	default:
+		return fmt.Errorf("unknown ban list format %q", f)
+	}
+}
+
+// Read reads a ban list in the given format.
+// CSV files do not record the exporting server, so the returned list's Server is empty.
+func Read(r io.Reader, f Format) (List, error) {
+	switch f {
+	case JSON:
+		var l List
+		err := json.NewDecoder(r).Decode(&l)
+		return l, err
+	case CSV:
+		c := csv.NewReader(r)
+		c.FieldsPerRecord = -1
+		records, err := c.ReadAll()
+		if err != nil {
+			return List{}, err
+		}
+		var l List
+		for i, rec := range records {
+			if i == 0 && rec[0] == csvHeader[0] {
+				continue
+			}
+			// Files written before area bans were added have no areas column.
+			if len(rec) != len(csvHeader) && len(rec) != len(csvHeader)-1 {
+				return List{}, fmt.Errorf("line %v: wrong number of fields", i+1)
+			}
+			id, err := strconv.Atoi(rec[0])
+			if err != nil {
+				return List{}, fmt.Errorf("line %v: invalid ban ID %q", i+1, rec[0])
+			}
+			b := Ban{ID: id, IPID: rec[1], HDID: rec[2], Start: rec[3], Expiry: rec[4], Reason: rec[5], Moderator: rec[6], Source: rec[7]}
+			if len(rec) == len(csvHeader) && rec[8] != "" {
+				b.Areas = strings.Split(rec[8], csvAreaSep)
+			}
+			l.Bans = append(l.Bans, b)
+		}
+		return l, nil
+	default:
+		return List{}, fmt.Errorf("unknown ban list format %q", f)
+	}
+}
+
+// Export writes every ban in the store to a file, in the format given by its extension.
+// It returns the number of bans written.
+func Export(s db.BanStore, path string, server string) (int, error) {
+	f, err := FormatOf(path)
+	if err != nil {
+		return 0, err
+	}
+	bans, err := s.AllBans()
+	if err != nil {
+		return 0, err
+	}
+	l := List{Server: server, Exported: time.Now().UTC().Format(time.RFC3339)}
+	for _, b := range bans {
+		l.Bans = append(l.Bans, FromBanInfo(b))
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	err = Write(file, f, l)
+	if err != nil {
+		return 0, err
+	}
+	return len(l.Bans), file.Close()
+}
+
+// ImportResult reports the outcome of an import.
+type ImportResult struct {
+	Added      int // Bans added, or that would be added in a dry run.
+	Duplicates int // Bans skipped because they already exist.
+}
+
+// Import adds the bans in a file to the store, in the format given by its extension.
+// Bans are identified by their IPID, HDID and start time, and are skipped if they already exist.
+// Imported bans are tagged with the server they came from: their existing source if they were themselves imported,
+// otherwise source, otherwise the server named in the file.
+// If dryRun is set, the store is not modified.
+func Import(s db.BanStore, path string, source string, dryRun bool) (ImportResult, error) {
+	var res ImportResult
+	f, err := FormatOf(path)
+	if err != nil {
+		return res, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	defer file.Close()
+	l, err := Read(file, f)
+	if err != nil {
+		return res, err
+	}
+	if source == "" {
+		source = l.Server
+	}
+	if source == "" {
+		return res, fmt.Errorf("the ban list does not name its server, so a source must be given")
+	}
+
+	// Parse every ban first, so a malformed file is not partially imported.
+	bans := make([]db.BanInfo, 0, len(l.Bans))
+	for _, b := range l.Bans {
+		info, err := b.BanInfo()
+		if err != nil {
+			return res, err
+		}
+		if info.Source == "" {
+			info.Source = source
+		}
+		bans = append(bans, info)
+	}
+
+	type key struct {
+		ipid, hdid string
+		time       int64
+	}
+	seen := make(map[key]bool)
+	for _, b := range bans {
+		k := key{b.Ipid, b.Hdid, b.Time}
+		exists, err := s.BanExists(b.Ipid, b.Hdid, b.Time)
+		if err != nil {
+			return res, err
+		}
+		if exists || seen[k] {
+			res.Duplicates++
+			continue
+		}
+		seen[k] = true
+		if !dryRun {
+			_, err := s.AddBan(b)
+			if err != nil {
+				return res, err
+			}
+		}
+		res.Added++
+	}
+	return res, nil
+}
+
+// Command runs a ban list command, writing its result to out. The recognized commands are:
+//
+//	export <file>
+//	import [-dry-run] [-source <server>] <file>
+//
+// server is the name of this server, recorded in exported JSON files.
+func Command(s db.BanStore, args []string, server string, out io.Writer) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: ban export <file> | ban import [-dry-run] [-source <server>] <file>")
+	}
+	switch args[0] {
+	case "export":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: ban export <file>")
+		}
+		n, err := Export(s, args[1], server)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Exported %v bans to %v.\n", n, args[1])
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		dryRun := flags.Bool("dry-run", false, "")
+		source := flags.String("source", "", "")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+			return fmt.Errorf("usage: ban import [-dry-run] [-source <server>] <file>")
+		}
+		res, err := Import(s, flags.Arg(0), *source, *dryRun)
+		if err != nil {
+			return err
+		}
+		if *dryRun {
+			fmt.Fprintf(out, "Dry run: would import %v bans, skipping %v duplicates.\n", res.Added, res.Duplicates)
+		} else {
+			fmt.Fprintf(out, "Imported %v bans, skipping %v duplicates.\n", res.Added, res.Duplicates)
+		}
+	default:
+		return fmt.Errorf("unknown ban command %q", args[0])
+	}
+	return nil
+}
+
+// fetchClient is used to fetch ban lists over HTTP.
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// Fetch reads the ban list at the given source, which is either an HTTP(S) URL or a local path.
+// The format is given by the source's extension, defaulting to JSON.
+func Fetch(ctx context.Context, source string) (List, error) {
+	var r io.Reader
+	var name string
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return List{}, err
+		}
+		resp, err := fetchClient.Do(req)
+		if err != nil {
+			return List{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return List{}, fmt.Errorf("unexpected response: %v", resp.Status)
+		}
+		r, name = resp.Body, u.Path
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return List{}, err
+		}
+		defer f.Close()
+		r, name = f, source
+	}
+	format, err := FormatOf(name)
+	if err != nil {
+		format = JSON
+	}
+	return Read(r, format)
+}
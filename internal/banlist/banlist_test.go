@@ -0,0 +1,151 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package banlist
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/MangosArentLiterature/Athena/internal/db"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, ext := range []string{".json", ".csv"} {
+		t.Run(ext, func(t *testing.T) {
+			src := db.NewMemoryStore()
+			src.AddBan(db.BanInfo{Ipid: "ipid1", Hdid: "hdid1", Time: 1650000000, Duration: -1, Reason: "spam, with a comma", Moderator: "mod"})
+			src.AddBan(db.BanInfo{Ipid: "ipid2", Hdid: "hdid2", Time: 1650000100, Duration: 1660000000, Reason: "trolling", Moderator: "mod", Areas: []string{"Courtroom 1", "Courtroom 2"}})
+			path := filepath.Join(t.TempDir(), "bans"+ext)
+			if n, err := Export(src, path, "Sister Server"); err != nil || n != 2 {
+				t.Fatalf("Export = %v, %v", n, err)
+			}
+
+			dst := db.NewMemoryStore()
+			dst.AddBan(db.BanInfo{Ipid: "ipid1", Hdid: "hdid1", Time: 1650000000, Duration: -1, Reason: "spam, with a comma", Moderator: "mod"}) // Already shared.
+
+			res, err := Import(dst, path, "other", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Added != 1 || res.Duplicates != 1 {
+				t.Errorf("dry run result %+v, want 1 added and 1 duplicate", res)
+			}
+			if bans, _ := dst.AllBans(); len(bans) != 1 {
+				t.Errorf("dry run modified the store")
+			}
+
+			res, err = Import(dst, path, "other", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Added != 1 || res.Duplicates != 1 {
+				t.Errorf("import result %+v, want 1 added and 1 duplicate", res)
+			}
+			bans, _ := dst.GetBan(db.IPID, "ipid2")
+			if len(bans) != 1 {
+				t.Fatalf("ban was not imported")
+			}
+			want := db.BanInfo{Id: 2, Ipid: "ipid2", Hdid: "hdid2", Time: 1650000100, Duration: 1660000000, Reason: "trolling", Moderator: "mod", Source: "other", Areas: []string{"Courtroom 1", "Courtroom 2"}}
+			if !reflect.DeepEqual(bans[0], want) {
+				t.Errorf("imported %+v, want %+v", bans[0], want)
+			}
+
+			// Importing again adds nothing.
+			if res, _ := Import(dst, path, "other", false); res.Added != 0 || res.Duplicates != 2 {
+				t.Errorf("reimport result %+v, want 2 duplicates", res)
+			}
+		})
+	}
+}
+
+func TestImportSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bans.json")
+	os.WriteFile(path, []byte(`{"server": "Sister Server", "bans": [
+		{"id": 1, "ipid": "a", "hdid": "b", "start": "2022-04-15T05:20:00Z", "expiry": "perma", "reason": "r", "moderator": "m"},
+		{"id": 2, "ipid": "c", "hdid": "d", "start": "2022-04-15T05:20:00Z", "expiry": "perma", "reason": "r", "moderator": "m", "source": "Third Server"}
+	]}`), 0644)
+	s := db.NewMemoryStore()
+	if _, err := Import(s, path, "", false); err != nil {
+		t.Fatal(err)
+	}
+	bans, _ := s.AllBans()
+	if len(bans) != 2 || bans[0].Source != "Sister Server" || bans[1].Source != "Third Server" {
+		t.Errorf("unexpected sources, got %+v", bans)
+	}
+
+	// CSV files do not name their server.
+	csvPath := filepath.Join(dir, "bans.csv")
+	os.WriteFile(csvPath, []byte("id,ipid,hdid,start,expiry,reason,moderator,source\n1,e,f,2022-04-15T05:20:00Z,perma,r,m,\n"), 0644)
+	if _, err := Import(s, csvPath, "", false); err == nil {
+		t.Errorf("imported CSV without a source")
+	}
+}
+
+func TestImportMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.csv")
+	os.WriteFile(path, []byte("1,a,b,2022-04-15T05:20:00Z,perma,r,m,\n2,c,d,yesterday,perma,r,m,\n"), 0644)
+	s := db.NewMemoryStore()
+	if _, err := Import(s, path, "src", false); err == nil {
+		t.Errorf("imported ban with invalid start time")
+	}
+	if bans, _ := s.AllBans(); len(bans) != 0 {
+		t.Errorf("malformed file was partially imported")
+	}
+	if _, err := Import(s, filepath.Join(t.TempDir(), "bans.txt"), "src", false); err == nil {
+		t.Errorf("imported file with unknown format")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bans.json":
+			w.Write([]byte(`{"server": "Sister Server", "bans": [{"id": 1, "ipid": "a", "hdid": "b", "start": "2022-04-15T05:20:00Z", "expiry": "perma", "reason": "r", "moderator": "m"}]}`))
+		case "/bans.csv":
+			w.Write([]byte("id,ipid,hdid,start,expiry,reason,moderator,source\n1,a,b,2022-04-15T05:20:00Z,perma,r,m,\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/bans.json", "/bans.csv"} {
+		l, err := Fetch(context.Background(), srv.URL+path)
+		if err != nil {
+			t.Errorf("Fetch(%v): %v", path, err)
+			continue
+		}
+		if len(l.Bans) != 1 || l.Bans[0].IPID != "a" {
+			t.Errorf("Fetch(%v) = %+v", path, l)
+		}
+	}
+	if _, err := Fetch(context.Background(), srv.URL+"/missing.json"); err == nil {
+		t.Errorf("fetched missing list")
+	}
+
+	file := filepath.Join(t.TempDir(), "bans.csv")
+	os.WriteFile(file, []byte("1,a,b,2022-04-15T05:20:00Z,perma,r,m,\n"), 0644)
+	if l, err := Fetch(context.Background(), file); err != nil || len(l.Bans) != 1 {
+		t.Errorf("Fetch(file) = %+v, %v", l, err)
+	}
+}
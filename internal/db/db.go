@@ -14,15 +14,23 @@ GNU Affero General Public License for more details.
 You should have received a copy of the GNU Affero General Public License
 along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 
+// Package db implements the server's persistent storage.
 package db
 
 import (
-	"database/sql"
-	"strconv"
+	"errors"
 	"time"
+)
+
+var (
+	// ErrNoBackup is returned when backing up a store that cannot be backed up.
+	ErrNoBackup = errors.New("this store cannot be backed up")
+
+	// ErrNoUser is returned when looking up a user that does not exist.
+	ErrNoUser = errors.New("user does not exist")
 
-	"golang.org/x/crypto/bcrypt"
-	_ "modernc.org/sqlite"
+	// ErrUserExists is returned when creating a user with the name of an existing user.
+	ErrUserExists = errors.New("user already exists")
 )
 
 type BanInfo struct {
@@ -33,6 +41,8 @@ type BanInfo struct {
 	Duration  int64
 	Reason    string
 	Moderator string
+	Source    string   // The server an imported ban came from, or empty for local bans.
+	Areas     []string // The areas the ban applies to, or empty for server-wide bans.
 }
 
 type BanLookup int
@@ -43,227 +53,198 @@ const (
 	BANID
 )
 
-var DBPath string
-var db *sql.DB
-
-// Database version.
-// This should be incremented whenever changes are made to the DB that require existing databases to upgrade.
-const ver = 1
-
-// Opens the server's database connection.
-func Open() error {
-	var err error
-	db, err = sql.Open("sqlite", DBPath)
-	if err != nil {
-		return err
-	}
-	var v int
-	r := db.QueryRow("PRAGMA user_version")
-	r.Scan(&v)
-	if v < ver {
-		err := upgradeDB(v)
-		if err != nil {
-			return err
-		}
-	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS BANS(ID INTEGER PRIMARY KEY, IPID TEXT, HDID TEXT, TIME INTEGER, DURATION INTEGER, REASON TEXT, MODERATOR TEXT)")
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS USERS(USERNAME TEXT PRIMARY KEY, PASSWORD TEXT, PERMISSIONS TEXT)")
-	if err != nil {
-		return err
-	}
-	return nil
-}
+// BanStatus selects bans by whether they are still in effect.
+type BanStatus int
 
-// upgradeDB upgrades the server's database to the latest version.
-func upgradeDB(v int) error {
-	switch v {
-	case 0:
-		_, err := db.Exec("PRAGMA user_version = " + "1")
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
+const (
+	AnyStatus BanStatus = iota
+	Active
+	Expired
+)
 
-// UserExists returns whether a user exists within the server's database.
-func UserExists(username string) bool {
-	result := db.QueryRow("SELECT USERNAME FROM USERS WHERE USERNAME = ?", username)
-	if result.Scan() == sql.ErrNoRows {
-		return false
-	} else {
-		return true
-	}
+// BanFilter selects bans to search for. Zero-valued fields match every ban.
+type BanFilter struct {
+	Ipid      string
+	Hdid      string
+	Moderator string
+	Reason    string // Matches bans whose reason contains this, ignoring case.
+	After     int64  // Matches bans placed at or after this time.
+	Before    int64  // Matches bans placed before this time.
+	Status    BanStatus
 }
 
-// CreateUser adds a new user to the server's database.
-func CreateUser(username string, password []byte, permissions uint64) error {
-	hashed, err := bcrypt.GenerateFromPassword(password, 12)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec("INSERT INTO USERS VALUES(?, ?, ?)", username, hashed, strconv.FormatUint(permissions, 10))
-	if err != nil {
-		return err
-	}
-	return nil
+// Count is the number of bans sharing a key, such as a moderator or reason.
+type Count struct {
+	Key   string
+	Count int
 }
 
-// RemoveUser deletes a user from the server's database.
-func RemoveUser(username string) error {
-	_, err := db.Exec("DELETE FROM USERS WHERE USERNAME = ?", username)
-	if err != nil {
-		return err
-	}
-	return nil
+// BanStats summarises the server's bans.
+type BanStats struct {
+	Total      int
+	Active     int
+	Moderators []Count // Bans per moderator, most bans first.
+	Weeks      []Count // Bans per week, keyed by the week's Monday as 2006-01-02, most recent first.
+	Reasons    []Count // The most common reasons, most bans first.
 }
 
-// AuthenticateUser returns whether or not the user's credentials match those in the database, and that user's permissions.
-func AuthenticateUser(username string, password []byte) (bool, uint64) {
-	var rpass, rperms string
-	result := db.QueryRow("SELECT PASSWORD, PERMISSIONS FROM USERS WHERE USERNAME = ?", username)
-	result.Scan(&rpass, &rperms)
-	err := bcrypt.CompareHashAndPassword([]byte(rpass), password)
-	if err != nil {
-		return false, 0
-	}
-	p, err := strconv.ParseUint(rperms, 10, 64)
-	if err != nil {
-		return false, 0
-	}
-	return true, p
-}
+// Store is the server's persistent storage.
+type Store interface {
+	UserStore
+	BanStore
+	SharedBanStore
 
-// ChangePermissions updated the permissions of a user in the database.
-func ChangePermissions(username string, permissions uint64) error {
-	_, err := db.Exec("UPDATE USERS SET PERMISSIONS = ? WHERE USERNAME = ?", strconv.FormatUint(permissions, 10), username)
-	if err != nil {
-		return err
-	}
-	return nil
+	// Backup writes a consistent copy of the store to the given path.
+	Backup(path string) error
+
+	// Close closes the store.
+	Close() error
 }
 
-// AddBan adds a new ban to the database.
-func AddBan(ipid string, hdid string, time int64, duration int64, reason string, moderator string) (int, error) {
-	result, err := db.Exec("INSERT INTO BANS VALUES(NULL, ?, ?, ?, ?, ?, ?)", ipid, hdid, time, duration, reason, moderator)
-	if err != nil {
-		return 0, err
-	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-	return int(id), nil
+// User is a moderator account.
+type User struct {
+	Name   string
+	Role   string   // The name of the user's role, or empty for accounts from before roles were stored.
+	Grants []string // Permissions granted to the user in addition to their role's.
+	Denies []string // Permissions of the user's role that are taken away from the user.
+
+	// The permissions of an account from before roles were stored. These are only used if Role is empty.
+	LegacyPermissions uint64
 }
 
-// UnBan nullifies a ban in the database.
-func UnBan(id int) error {
-	_, err := db.Exec("UPDATE BANS SET DURATION = 0 WHERE ID = ?", id)
-	if err != nil {
-		return err
-	}
-	return nil
+// AreaRole grants a user a role in a single area.
+type AreaRole struct {
+	Username string
+	Area     string
+	Role     string
 }
 
-// GetBan returns a list of bans matching a given value.
-func GetBan(by BanLookup, value any) ([]BanInfo, error) {
-	var stmt *sql.Stmt
-	var err error
-	switch by {
-	case BANID:
-		stmt, err = db.Prepare("SELECT * FROM BANS WHERE ID = ?")
-	case IPID:
-		stmt, err = db.Prepare("SELECT * FROM BANS WHERE IPID = ? ORDER BY TIME DESC")
-	}
-	if err != nil {
-		return []BanInfo{}, err
-	}
-	result, err := stmt.Query(value)
-	if err != nil {
-		return []BanInfo{}, err
-	}
-	stmt.Close()
-	defer result.Close()
-	var bans []BanInfo
-	for result.Next() {
-		var b BanInfo
-		result.Scan(&b.Id, &b.Ipid, &b.Hdid, &b.Time, &b.Duration, &b.Reason, &b.Moderator)
-		bans = append(bans, b)
-	}
-	return bans, nil
+// UserStore stores moderator accounts.
+type UserStore interface {
+	// UserExists returns whether a user exists.
+	UserExists(username string) bool
+
+	// CreateUser adds a new user with the given role.
+	CreateUser(username string, password []byte, role string) error
+
+	// RemoveUser deletes a user.
+	RemoveUser(username string) error
+
+	// AuthenticateUser returns whether or not the user's credentials are correct.
+	AuthenticateUser(username string, password []byte) bool
+
+	// GetUser returns a user, or ErrNoUser if they do not exist.
+	GetUser(username string) (User, error)
+
+	// Users returns every user, in order of name.
+	Users() ([]User, error)
+
+	// SetRole changes the role of a user.
+	SetRole(username string, role string) error
+
+	// SetOverride grants a permission to a user if allow is true, or denies it if not, regardless of their role.
+	SetOverride(username string, permission string, allow bool) error
+
+	// ClearOverride removes a user's grant or denial of a permission.
+	ClearOverride(username string, permission string) error
+
+	// GrantAreaRole gives a user a role in an area.
+	GrantAreaRole(username string, area string, role string) error
+
+	// RevokeAreaRole takes a role in an area away from a user.
+	RevokeAreaRole(username string, area string, role string) error
+
+	// AreaRoles returns the roles a user has been granted in areas, ordered by area.
+	AreaRoles(username string) ([]AreaRole, error)
+
+	// AreaRoleHolders returns the users who have been granted roles in an area, ordered by username.
+	AreaRoleHolders(area string) ([]AreaRole, error)
+
+	// ChangePassword updates the password of a user.
+	ChangePassword(username string, password []byte) error
+
+	// TOTPSecret returns a user's two-factor authentication secret, or an empty string if they have not enrolled.
+	TOTPSecret(username string) (string, error)
+
+	// SetTOTPSecret sets a user's two-factor authentication secret. An empty secret disables two-factor authentication.
+	SetTOTPSecret(username string, secret string) error
 }
 
-// GetRecentBans returns the 5 most recent bans.
-func GetRecentBans() ([]BanInfo, error) {
-	result, err := db.Query("SELECT * FROM BANS ORDER BY TIME DESC LIMIT 5")
-	if err != nil {
-		return []BanInfo{}, err
-	}
-	defer result.Close()
-	var bans []BanInfo
-	for result.Next() {
-		var b BanInfo
-		result.Scan(&b.Id, &b.Ipid, &b.Hdid, &b.Time, &b.Duration, &b.Reason, &b.Moderator)
-		bans = append(bans, b)
-	}
-	return bans, nil
+// BanStore stores bans.
+type BanStore interface {
+	// AddBan adds a new ban, returning its ID. The ban's Id is ignored.
+	AddBan(b BanInfo) (int, error)
+
+	// UnBan nullifies a ban.
+	UnBan(id int) error
+
+	// GetBan returns a list of bans matching a given value, most recent first.
+	GetBan(by BanLookup, value any) ([]BanInfo, error)
+
+	// AllBans returns every ban, oldest first.
+	AllBans() ([]BanInfo, error)
+
+	// SearchBans returns up to limit bans matching a filter, most recent first, skipping the first offset matches.
+	// It also returns the total number of matching bans.
+	SearchBans(f BanFilter, offset int, limit int) ([]BanInfo, int, error)
+
+	// BanStats returns statistics about the server's bans, keeping at most limit entries in each list.
+	BanStats(limit int) (BanStats, error)
+
+	// BanExists returns whether a ban with the given ipid, hdid and start time exists.
+	BanExists(ipid string, hdid string, time int64) (bool, error)
+
+	// IsBanned returns whether the given ipid/hdid is banned from the server, and the info of the ban.
+	IsBanned(by BanLookup, value string) (bool, BanInfo, error)
+
+	// IsAreaBanned returns whether a client with the given ipid and hdid is banned from an area, and the info of the ban.
+	// An empty ipid or hdid matches no bans.
+	IsAreaBanned(ipid string, hdid string, area string) (bool, BanInfo, error)
+
+	// RekeyBans replaces a legacy ipid/hdid with its current value in all bans that have not yet been converted.
+	RekeyBans(by BanLookup, legacy string, value string) error
+
+	// UpdateReason updates the reason of a ban.
+	UpdateReason(id int, reason string) error
+
+	// UpdateDuration updates the duration of a ban.
+	UpdateDuration(id int, duration int64) error
 }
 
-// IsBanned returns whether the given ipid/hdid is banned, and the info of the ban.
-func IsBanned(by BanLookup, value string) (bool, BanInfo, error) {
-	var stmt *sql.Stmt
-	var err error
-	switch by {
-	case IPID:
-		stmt, err = db.Prepare("SELECT ID, DURATION, REASON FROM BANS WHERE IPID = ?")
-	case HDID:
-		stmt, err = db.Prepare("SELECT ID, DURATION, REASON FROM BANS WHERE HDID = ?")
-	}
-	if err != nil {
-		return false, BanInfo{}, err
-	}
-	result, err := stmt.Query(value)
-	if err != nil {
-		return false, BanInfo{}, err
-	}
-	stmt.Close()
-	defer result.Close()
-	for result.Next() {
-		var (
-			duration int64
-			id       int
-			reason   string
-		)
-		result.Scan(&id, &duration, &reason)
-		if duration == -1 || time.Unix(duration, 0).UTC().After(time.Now().UTC()) {
-			return true, BanInfo{Id: id, Duration: duration, Reason: reason}, nil
-		}
-	}
-	return false, BanInfo{}, nil
+// BanListInfo describes a subscribed ban list.
+type BanListInfo struct {
+	Name    string
+	Enabled bool
+	Updated int64 // When the list was last fetched, or 0 if it never has been.
+	Bans    int
 }
 
-// UpdateReason updates the reason of a ban.
-func UpdateReason(id int, reason string) error {
-	_, err := db.Exec("UPDATE BANS SET REASON = ? WHERE ID = ?", reason, id)
-	if err != nil {
-		return err
-	}
-	return nil
+// SharedBanStore stores bans from subscribed ban lists, separately from local bans.
+type SharedBanStore interface {
+	// ReplaceSharedBans replaces every ban from the given list.
+	ReplaceSharedBans(list string, bans []BanInfo) error
+
+	// IsSharedBanned returns whether the given ipid/hdid is banned by an enabled list, and the info of the ban.
+	// The returned ban's Source is the name of the list.
+	IsSharedBanned(by BanLookup, value string) (bool, BanInfo, error)
+
+	// BanLists returns every ban list that has been fetched or toggled.
+	BanLists() ([]BanListInfo, error)
+
+	// SetBanListEnabled enables or disables a ban list.
+	SetBanListEnabled(list string, enabled bool) error
+
+	// RemoveBanList deletes a ban list and every ban from it.
+	RemoveBanList(list string) error
 }
 
-// UpdateDuration updates the duration of a ban.
-func UpdateDuration(id int, duration int64) error {
-	_, err := db.Exec("UPDATE BANS SET DURATION = ? WHERE ID = ?", duration, id)
-	if err != nil {
-		return err
-	}
-	return nil
+// weekOf returns the Monday of the week containing the given time, formatted as 2006-01-02.
+func weekOf(t int64) string {
+	d := time.Unix(t, 0).UTC()
+	return d.AddDate(0, 0, -(int(d.Weekday())+6)%7).Format("2006-01-02")
 }
 
-// Closes the server's database connection.
-func Close() {
-	db.Close()
+// isActive returns whether a ban with the given duration is still in effect.
+func isActive(duration int64) bool {
+	return duration == -1 || time.Unix(duration, 0).UTC().After(time.Now().UTC())
 }
@@ -0,0 +1,342 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Every Store implementation must pass the same tests.
+func TestStores(t *testing.T) {
+	forEachStore(t, testStore)
+}
+
+func TestSearchBans(t *testing.T) {
+	forEachStore(t, testSearchBans)
+}
+
+// forEachStore runs a test against a new instance of every Store implementation.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("SQLite", func(t *testing.T) {
+		s, err := OpenSQLite(filepath.Join(t.TempDir(), "athena.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		test(t, s)
+	})
+	t.Run("Memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+}
+
+func testStore(t *testing.T, s Store) {
+	// Users
+	if s.UserExists("mod") {
+		t.Errorf("user exists before being created")
+	}
+	if err := s.CreateUser("mod", []byte("password"), "moderator"); err != nil {
+		t.Fatal(err)
+	}
+	if !s.UserExists("mod") {
+		t.Errorf("user does not exist after being created")
+	}
+	if err := s.CreateUser("mod", []byte("other"), "admin"); err != ErrUserExists {
+		t.Errorf("creating an existing user returned %v, want ErrUserExists", err)
+	}
+	if s.AuthenticateUser("mod", []byte("wrong")) || s.AuthenticateUser("nobody", []byte("")) {
+		t.Errorf("authenticated with wrong credentials")
+	}
+	if !s.AuthenticateUser("mod", []byte("password")) {
+		t.Errorf("failed to authenticate")
+	}
+	if u, err := s.GetUser("mod"); err != nil || u.Name != "mod" || u.Role != "moderator" {
+		t.Errorf("GetUser = %+v, %v", u, err)
+	}
+	if _, err := s.GetUser("nobody"); err != ErrNoUser {
+		t.Errorf("GetUser(nobody) returned %v, want ErrNoUser", err)
+	}
+	if err := s.SetRole("mod", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	s.SetOverride("mod", "KICK", true)
+	s.SetOverride("mod", "BAN", false)
+	s.SetOverride("mod", "MUTE", true)
+	s.ClearOverride("mod", "MUTE")
+	if u, _ := s.GetUser("mod"); u.Role != "admin" || fmt.Sprint(u.Grants) != "[KICK]" || fmt.Sprint(u.Denies) != "[BAN]" {
+		t.Errorf("role or overrides not changed, got %+v", u)
+	}
+	s.GrantAreaRole("mod", "Courtroom 2", "host")
+	s.GrantAreaRole("mod", "Courtroom 1", "host")
+	s.GrantAreaRole("mod", "Courtroom 1", "host")
+	s.GrantAreaRole("other", "Courtroom 1", "owner")
+	if roles, _ := s.AreaRoles("mod"); fmt.Sprint(roles) != "[{mod Courtroom 1 host} {mod Courtroom 2 host}]" {
+		t.Errorf("AreaRoles = %v", roles)
+	}
+	if holders, _ := s.AreaRoleHolders("Courtroom 1"); fmt.Sprint(holders) != "[{mod Courtroom 1 host} {other Courtroom 1 owner}]" {
+		t.Errorf("AreaRoleHolders = %v", holders)
+	}
+	s.RevokeAreaRole("mod", "Courtroom 2", "host")
+	if roles, _ := s.AreaRoles("mod"); len(roles) != 1 {
+		t.Errorf("area role was not revoked, got %v", roles)
+	}
+	s.CreateUser("other", []byte("password"), "moderator")
+	if users, _ := s.Users(); len(users) != 2 || users[0].Name != "mod" || users[1].Name != "other" {
+		t.Errorf("Users = %+v", users)
+	}
+	s.RemoveUser("other")
+	if holders, _ := s.AreaRoleHolders("Courtroom 1"); len(holders) != 1 {
+		t.Errorf("removed user's area roles were kept, got %v", holders)
+	}
+	if err := s.ChangePassword("mod", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if s.AuthenticateUser("mod", []byte("password")) {
+		t.Errorf("old password still works")
+	}
+	if !s.AuthenticateUser("mod", []byte("new")) {
+		t.Errorf("password not changed")
+	}
+	if secret, err := s.TOTPSecret("mod"); secret != "" || err != nil {
+		t.Errorf("TOTPSecret before enrolling = %q, %v", secret, err)
+	}
+	s.SetTOTPSecret("mod", "SECRET")
+	if secret, _ := s.TOTPSecret("mod"); secret != "SECRET" {
+		t.Errorf("TOTP secret not set, got %q", secret)
+	}
+	s.SetTOTPSecret("mod", "")
+	if secret, _ := s.TOTPSecret("mod"); secret != "" {
+		t.Errorf("TOTP secret not cleared, got %q", secret)
+	}
+	if err := s.RemoveUser("mod"); err != nil {
+		t.Fatal(err)
+	}
+	if s.UserExists("mod") {
+		t.Errorf("user exists after being removed")
+	}
+
+	// Bans
+	now := time.Now().UTC()
+	expired, _ := s.AddBan(BanInfo{Ipid: "ipid1", Hdid: "hdid1", Time: now.Add(-2 * time.Hour).Unix(), Duration: now.Add(-time.Hour).Unix(), Reason: "expired", Moderator: "mod"})
+	perma, _ := s.AddBan(BanInfo{Ipid: "ipid1", Hdid: "hdid2", Time: now.Add(-time.Hour).Unix(), Duration: -1, Reason: "perma", Moderator: "mod"})
+	for i := 0; i < 5; i++ {
+		s.AddBan(BanInfo{Ipid: "ipid2", Hdid: "hdid3", Time: now.Unix() + int64(i), Duration: now.Add(time.Hour).Unix(), Reason: "recent", Moderator: "mod"})
+	}
+
+	bans, err := s.GetBan(BANID, expired)
+	if err != nil || len(bans) != 1 || bans[0].Reason != "expired" || bans[0].Hdid != "hdid1" {
+		t.Errorf("GetBan(BANID, %v) = %+v, %v", expired, bans, err)
+	}
+	bans, _ = s.GetBan(IPID, "ipid1")
+	if len(bans) != 2 || bans[0].Id != perma || bans[1].Id != expired {
+		t.Errorf("GetBan(IPID) did not return bans most recent first, got %+v", bans)
+	}
+	bans, _, _ = s.SearchBans(BanFilter{}, 0, 5)
+	if len(bans) != 5 || bans[0].Time != now.Unix()+4 {
+		t.Errorf("SearchBans returned %+v", bans)
+	}
+
+	if banned, _, _ := s.IsBanned(HDID, "hdid1"); banned {
+		t.Errorf("expired ban is active")
+	}
+	if banned, info, _ := s.IsBanned(IPID, "ipid1"); !banned || info.Id != perma || info.Reason != "perma" {
+		t.Errorf("IsBanned(IPID) = %v, %+v", banned, info)
+	}
+	s.UpdateReason(perma, "updated")
+	if _, info, _ := s.IsBanned(HDID, "hdid2"); info.Reason != "updated" {
+		t.Errorf("reason not updated, got %v", info.Reason)
+	}
+	s.UpdateDuration(expired, -1)
+	if banned, _, _ := s.IsBanned(HDID, "hdid1"); !banned {
+		t.Errorf("duration not updated")
+	}
+	if exists, _ := s.BanExists("ipid1", "hdid2", now.Add(-time.Hour).Unix()); !exists {
+		t.Errorf("BanExists did not find ban")
+	}
+	if exists, _ := s.BanExists("ipid1", "hdid2", now.Unix()); exists {
+		t.Errorf("BanExists found ban with a different start time")
+	}
+	imported, err := s.AddBan(BanInfo{Ipid: "ipid4", Hdid: "hdid4", Time: 1, Duration: -1, Reason: "imported", Moderator: "mod", Source: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bans, _ := s.GetBan(BANID, imported); len(bans) != 1 || bans[0].Source != "other" {
+		t.Errorf("imported ban was not stored with its source, got %+v", bans)
+	}
+	if all, _ := s.AllBans(); len(all) != 8 || all[0].Id != expired || all[7].Id != imported {
+		t.Errorf("AllBans returned %+v", all)
+	}
+
+	s.UnBan(perma)
+	s.UnBan(expired)
+	if banned, _, _ := s.IsBanned(IPID, "ipid1"); banned {
+		t.Errorf("ban is active after unban")
+	}
+
+	// Area bans
+	area, _ := s.AddBan(BanInfo{Ipid: "", Hdid: "hdid7", Time: 1, Duration: -1, Reason: "area", Moderator: "mod", Areas: []string{"Courtroom 1", "Courtroom 2"}})
+	if banned, _, _ := s.IsBanned(HDID, "hdid7"); banned {
+		t.Errorf("area ban applies to the whole server")
+	}
+	if banned, info, _ := s.IsAreaBanned("ipid7", "hdid7", "Courtroom 2"); !banned || info.Id != area || len(info.Areas) != 2 {
+		t.Errorf("IsAreaBanned = %v, %+v", banned, info)
+	}
+	if banned, _, _ := s.IsAreaBanned("ipid7", "hdid7", "Lobby"); banned {
+		t.Errorf("area ban applies to other areas")
+	}
+	if banned, _, _ := s.IsAreaBanned("", "hdid8", "Courtroom 1"); banned {
+		t.Errorf("HDID-only area ban matched an empty IPID")
+	}
+	if bans, _ := s.GetBan(BANID, area); len(bans) != 1 || len(bans[0].Areas) != 2 || bans[0].Areas[0] != "Courtroom 1" {
+		t.Errorf("ban areas were not stored, got %+v", bans)
+	}
+	s.UnBan(area)
+	if banned, _, _ := s.IsAreaBanned("", "hdid7", "Courtroom 1"); banned {
+		t.Errorf("area ban is active after unban")
+	}
+
+	// Shared bans
+	shared := []BanInfo{
+		{Id: 7, Ipid: "ipid5", Hdid: "hdid5", Time: 1, Duration: -1, Reason: "shared", Moderator: "other"},
+		{Id: 8, Ipid: "ipid6", Hdid: "hdid6", Time: 1, Duration: 1, Reason: "expired", Moderator: "other"},
+	}
+	if err := s.ReplaceSharedBans("list", shared); err != nil {
+		t.Fatal(err)
+	}
+	if banned, _, _ := s.IsBanned(IPID, "ipid5"); banned {
+		t.Errorf("shared ban was added to local bans")
+	}
+	if banned, info, _ := s.IsSharedBanned(HDID, "hdid5"); !banned || info.Source != "list" || info.Id != 7 || info.Reason != "shared" {
+		t.Errorf("IsSharedBanned = %v, %+v", banned, info)
+	}
+	if banned, _, _ := s.IsSharedBanned(IPID, "ipid6"); banned {
+		t.Errorf("expired shared ban is active")
+	}
+	if lists, _ := s.BanLists(); len(lists) != 1 || lists[0].Name != "list" || !lists[0].Enabled || lists[0].Bans != 2 || lists[0].Updated == 0 {
+		t.Errorf("BanLists = %+v", lists)
+	}
+	s.SetBanListEnabled("list", false)
+	if banned, _, _ := s.IsSharedBanned(IPID, "ipid5"); banned {
+		t.Errorf("disabled list is active")
+	}
+	s.ReplaceSharedBans("list", shared[1:])
+	s.SetBanListEnabled("list", true)
+	if lists, _ := s.BanLists(); len(lists) != 1 || lists[0].Bans != 1 {
+		t.Errorf("list was not replaced, got %+v", lists)
+	}
+	if banned, _, _ := s.IsSharedBanned(IPID, "ipid5"); banned {
+		t.Errorf("ban removed from list is active")
+	}
+	s.RemoveBanList("list")
+	if banned, _, _ := s.IsSharedBanned(IPID, "ipid6"); banned {
+		t.Errorf("removed list is active")
+	}
+	if lists, _ := s.BanLists(); len(lists) != 0 {
+		t.Errorf("list was not removed, got %+v", lists)
+	}
+}
+
+func testSearchBans(t *testing.T, s Store) {
+	// 2024-01-01 was a Monday.
+	monday := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Unix()
+	week := int64(7 * 24 * 60 * 60)
+	s.AddBan(BanInfo{Ipid: "ipid1", Hdid: "hdid1", Time: monday, Duration: 1, Reason: "Spam", Moderator: "alice"})
+	s.AddBan(BanInfo{Ipid: "ipid2", Hdid: "hdid2", Time: monday + 1, Duration: -1, Reason: "spamming OOC", Moderator: "bob"})
+	s.AddBan(BanInfo{Ipid: "ipid3", Hdid: "hdid1", Time: monday + week, Duration: -1, Reason: "Spam", Moderator: "alice"})
+	s.AddBan(BanInfo{Ipid: "ipid4", Hdid: "hdid4", Time: monday + week + 60*60, Duration: -1, Reason: "trolling", Moderator: "alice"})
+
+	tests := []struct {
+		name   string
+		filter BanFilter
+		want   []int
+	}{
+		{"all", BanFilter{}, []int{4, 3, 2, 1}},
+		{"hdid", BanFilter{Hdid: "hdid1"}, []int{3, 1}},
+		{"moderator", BanFilter{Moderator: "alice"}, []int{4, 3, 1}},
+		{"reason", BanFilter{Reason: "SPAM"}, []int{3, 2, 1}},
+		{"range", BanFilter{After: monday + 1, Before: monday + week + 1}, []int{3, 2}},
+		{"active", BanFilter{Status: Active}, []int{4, 3, 2}},
+		{"expired", BanFilter{Status: Expired}, []int{1}},
+		{"combined", BanFilter{Moderator: "alice", Reason: "spam", Status: Active}, []int{3}},
+	}
+	for _, tt := range tests {
+		bans, total, err := s.SearchBans(tt.filter, 0, 10)
+		var got []int
+		for _, b := range bans {
+			got = append(got, b.Id)
+		}
+		if err != nil || total != len(tt.want) || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%v: SearchBans = %v (total %v), %v; want %v", tt.name, got, total, err, tt.want)
+		}
+	}
+	if bans, total, _ := s.SearchBans(BanFilter{}, 2, 1); total != 4 || len(bans) != 1 || bans[0].Id != 2 {
+		t.Errorf("SearchBans(offset 2, limit 1) = %+v, %v", bans, total)
+	}
+	if bans, _, _ := s.SearchBans(BanFilter{}, 10, 1); len(bans) != 0 {
+		t.Errorf("SearchBans past the last page = %+v", bans)
+	}
+
+	stats, err := s.BanStats(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Total != 4 || stats.Active != 3 {
+		t.Errorf("BanStats total %v, active %v", stats.Total, stats.Active)
+	}
+	if want := "[{alice 3} {bob 1}]"; fmt.Sprint(stats.Moderators) != want {
+		t.Errorf("BanStats moderators = %v, want %v", stats.Moderators, want)
+	}
+	if want := "[{2024-01-08 2} {2024-01-01 2}]"; fmt.Sprint(stats.Weeks) != want {
+		t.Errorf("BanStats weeks = %v, want %v", stats.Weeks, want)
+	}
+	if want := "[{Spam 2} {spamming OOC 1}]"; fmt.Sprint(stats.Reasons) != want {
+		t.Errorf("BanStats reasons = %v, want %v", stats.Reasons, want)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSQLite(filepath.Join(dir, "athena.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddBan(BanInfo{Ipid: "ipid", Hdid: "hdid", Time: 0, Duration: -1, Reason: "reason", Moderator: "mod"})
+
+	path := filepath.Join(dir, "backup.db")
+	if err := s.Backup(path); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if banned, _, _ := b.IsBanned(IPID, "ipid"); !banned {
+		t.Errorf("backup is missing data")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.db")
+	os.WriteFile(corrupt, []byte("not a database"), 0644)
+	if err := CheckIntegrity(corrupt); err == nil {
+		t.Errorf("integrity check passed for a corrupt database")
+	}
+}
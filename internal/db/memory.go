@@ -0,0 +1,565 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type memUser struct {
+	password   []byte
+	role       string
+	overrides  map[string]bool // Whether each overridden permission is granted or denied.
+	totpSecret string
+}
+
+type memBan struct {
+	BanInfo
+	legacyIpid string
+	legacyHdid string
+}
+
+// MemoryStore is a Store that keeps all data in memory.
+// It is intended for tests, and for running a server that does not need to persist bans or users.
+type MemoryStore struct {
+	mu        sync.Mutex
+	users     map[string]*memUser
+	areaRoles []AreaRole
+	bans      []*memBan
+	shared    map[string]*memList
+}
+
+type memList struct {
+	enabled bool
+	updated int64
+	bans    []BanInfo
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[string]*memUser), shared: make(map[string]*memList)}
+}
+
+// UserExists returns whether a user exists.
+func (m *MemoryStore) UserExists(username string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.users[username]
+	return ok
+}
+
+// CreateUser adds a new user with the given role.
+func (m *MemoryStore) CreateUser(username string, password []byte, role string) error {
+	// The minimum cost is used, as this store is never written to disk.
+	hashed, err := bcrypt.GenerateFromPassword(password, bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[username]; ok {
+		return ErrUserExists
+	}
+	m.users[username] = &memUser{password: hashed, role: role, overrides: make(map[string]bool)}
+	return nil
+}
+
+// RemoveUser deletes a user.
+func (m *MemoryStore) RemoveUser(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, username)
+	roles := m.areaRoles[:0]
+	for _, r := range m.areaRoles {
+		if r.Username != username {
+			roles = append(roles, r)
+		}
+	}
+	m.areaRoles = roles
+	return nil
+}
+
+// AuthenticateUser returns whether or not the user's credentials are correct.
+func (m *MemoryStore) AuthenticateUser(username string, password []byte) bool {
+	m.mu.Lock()
+	u, ok := m.users[username]
+	m.mu.Unlock()
+	return ok && bcrypt.CompareHashAndPassword(u.password, password) == nil
+}
+
+// GetUser returns a user, or ErrNoUser if they do not exist.
+func (m *MemoryStore) GetUser(username string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[username]
+	if !ok {
+		return User{}, ErrNoUser
+	}
+	return u.user(username), nil
+}
+
+// Users returns every user, in order of name.
+func (m *MemoryStore) Users() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var users []User
+	for name, u := range m.users {
+		users = append(users, u.user(name))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+// user returns the User for a memUser.
+func (u *memUser) user(name string) User {
+	user := User{Name: name, Role: u.role}
+	for perm, allow := range u.overrides {
+		if allow {
+			user.Grants = append(user.Grants, perm)
+		} else {
+			user.Denies = append(user.Denies, perm)
+		}
+	}
+	sort.Strings(user.Grants)
+	sort.Strings(user.Denies)
+	return user
+}
+
+// SetRole changes the role of a user.
+func (m *MemoryStore) SetRole(username string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		u.role = role
+	}
+	return nil
+}
+
+// SetOverride grants a permission to a user if allow is true, or denies it if not.
+func (m *MemoryStore) SetOverride(username string, permission string, allow bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		u.overrides[permission] = allow
+	}
+	return nil
+}
+
+// ClearOverride removes a user's grant or denial of a permission.
+func (m *MemoryStore) ClearOverride(username string, permission string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		delete(u.overrides, permission)
+	}
+	return nil
+}
+
+// GrantAreaRole gives a user a role in an area.
+func (m *MemoryStore) GrantAreaRole(username string, area string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := AreaRole{Username: username, Area: area, Role: role}
+	for _, existing := range m.areaRoles {
+		if existing == r {
+			return nil
+		}
+	}
+	m.areaRoles = append(m.areaRoles, r)
+	return nil
+}
+
+// RevokeAreaRole takes a role in an area away from a user.
+func (m *MemoryStore) RevokeAreaRole(username string, area string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := AreaRole{Username: username, Area: area, Role: role}
+	for i, existing := range m.areaRoles {
+		if existing == r {
+			m.areaRoles = append(m.areaRoles[:i], m.areaRoles[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// AreaRoles returns the roles a user has been granted in areas, ordered by area.
+func (m *MemoryStore) AreaRoles(username string) ([]AreaRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var roles []AreaRole
+	for _, r := range m.areaRoles {
+		if r.Username == username {
+			roles = append(roles, r)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Area < roles[j].Area || (roles[i].Area == roles[j].Area && roles[i].Role < roles[j].Role)
+	})
+	return roles, nil
+}
+
+// AreaRoleHolders returns the users who have been granted roles in an area, ordered by username.
+func (m *MemoryStore) AreaRoleHolders(area string) ([]AreaRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var roles []AreaRole
+	for _, r := range m.areaRoles {
+		if r.Area == area {
+			roles = append(roles, r)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Username < roles[j].Username || (roles[i].Username == roles[j].Username && roles[i].Role < roles[j].Role)
+	})
+	return roles, nil
+}
+
+// ChangePassword updates the password of a user.
+func (m *MemoryStore) ChangePassword(username string, password []byte) error {
+	hashed, err := bcrypt.GenerateFromPassword(password, bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		u.password = hashed
+	}
+	return nil
+}
+
+// TOTPSecret returns a user's two-factor authentication secret, or an empty string if they have not enrolled.
+func (m *MemoryStore) TOTPSecret(username string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		return u.totpSecret, nil
+	}
+	return "", nil
+}
+
+// SetTOTPSecret sets a user's two-factor authentication secret.
+func (m *MemoryStore) SetTOTPSecret(username string, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[username]; ok {
+		u.totpSecret = secret
+	}
+	return nil
+}
+
+// AddBan adds a new ban, returning its ID.
+func (m *MemoryStore) AddBan(b BanInfo) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b.Id = len(m.bans) + 1
+	b.Areas = append([]string(nil), b.Areas...)
+	m.bans = append(m.bans, &memBan{BanInfo: b})
+	return b.Id, nil
+}
+
+// ban returns the ban with the given ID, or nil if it does not exist.
+// The caller must hold m.mu.
+func (m *MemoryStore) ban(id int) *memBan {
+	if id < 1 || id > len(m.bans) {
+		return nil
+	}
+	return m.bans[id-1]
+}
+
+// UnBan nullifies a ban.
+func (m *MemoryStore) UnBan(id int) error {
+	return m.UpdateDuration(id, 0)
+}
+
+// GetBan returns a list of bans matching a given value, most recent first.
+func (m *MemoryStore) GetBan(by BanLookup, value any) ([]BanInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var bans []BanInfo
+	for _, b := range m.bans {
+		switch by {
+		case BANID:
+			if id, ok := value.(int); ok && b.Id == id {
+				bans = append(bans, b.BanInfo)
+			}
+		case IPID:
+			if b.Ipid == value {
+				bans = append(bans, b.BanInfo)
+			}
+		case HDID:
+			if b.Hdid == value {
+				bans = append(bans, b.BanInfo)
+			}
+		}
+	}
+	sortRecent(bans)
+	return bans, nil
+}
+
+// AllBans returns every ban, oldest first.
+func (m *MemoryStore) AllBans() ([]BanInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var bans []BanInfo
+	for _, b := range m.bans {
+		bans = append(bans, b.BanInfo)
+	}
+	return bans, nil
+}
+
+// SearchBans returns up to limit bans matching a filter, most recent first, skipping the first offset matches.
+func (m *MemoryStore) SearchBans(f BanFilter, offset int, limit int) ([]BanInfo, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var bans []BanInfo
+	for _, b := range m.bans {
+		if f.matches(b.BanInfo) {
+			bans = append(bans, b.BanInfo)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Time != bans[j].Time {
+			return bans[i].Time > bans[j].Time
+		}
+		return bans[i].Id > bans[j].Id
+	})
+	total := len(bans)
+	if offset > total {
+		offset = total
+	}
+	bans = bans[offset:]
+	if len(bans) > limit {
+		bans = bans[:limit]
+	}
+	return bans, total, nil
+}
+
+// matches returns whether a ban matches the filter.
+func (f BanFilter) matches(b BanInfo) bool {
+	switch {
+	case f.Ipid != "" && b.Ipid != f.Ipid,
+		f.Hdid != "" && b.Hdid != f.Hdid,
+		f.Moderator != "" && b.Moderator != f.Moderator,
+		f.Reason != "" && !strings.Contains(strings.ToLower(b.Reason), strings.ToLower(f.Reason)),
+		f.After != 0 && b.Time < f.After,
+		f.Before != 0 && b.Time >= f.Before,
+		f.Status == Active && !isActive(b.Duration),
+		f.Status == Expired && isActive(b.Duration):
+		return false
+	}
+	return true
+}
+
+// BanStats returns statistics about the server's bans, keeping at most limit entries in each list.
+func (m *MemoryStore) BanStats(limit int) (BanStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var stats BanStats
+	mods, weeks, reasons := make(map[string]int), make(map[string]int), make(map[string]int)
+	for _, b := range m.bans {
+		stats.Total++
+		if isActive(b.Duration) {
+			stats.Active++
+		}
+		mods[b.Moderator]++
+		weeks[weekOf(b.Time)]++
+		reasons[b.Reason]++
+	}
+	stats.Moderators = topCounts(mods, limit, func(a, b Count) bool { return a.Count > b.Count || (a.Count == b.Count && a.Key < b.Key) })
+	stats.Weeks = topCounts(weeks, limit, func(a, b Count) bool { return a.Key > b.Key })
+	stats.Reasons = topCounts(reasons, limit, func(a, b Count) bool { return a.Count > b.Count || (a.Count == b.Count && a.Key < b.Key) })
+	return stats, nil
+}
+
+// topCounts returns the first limit counts of m, in the given order.
+func topCounts(m map[string]int, limit int, less func(a, b Count) bool) []Count {
+	var counts []Count
+	for k, n := range m {
+		counts = append(counts, Count{Key: k, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool { return less(counts[i], counts[j]) })
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+// BanExists returns whether a ban with the given ipid, hdid and start time exists.
+func (m *MemoryStore) BanExists(ipid string, hdid string, time int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.bans {
+		if b.Ipid == ipid && b.Hdid == hdid && b.Time == time {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sortRecent sorts bans from most to least recent.
+func sortRecent(bans []BanInfo) {
+	sort.SliceStable(bans, func(i, j int) bool { return bans[i].Time > bans[j].Time })
+}
+
+// IsBanned returns whether the given ipid/hdid is banned, and the info of the ban.
+func (m *MemoryStore) IsBanned(by BanLookup, value string) (bool, BanInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.bans {
+		if len(b.Areas) == 0 && ((by == IPID && b.Ipid == value) || (by == HDID && b.Hdid == value)) && isActive(b.Duration) {
+			return true, BanInfo{Id: b.Id, Duration: b.Duration, Reason: b.Reason}, nil
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// IsAreaBanned returns whether a client with the given ipid and hdid is banned from an area, and the info of the ban.
+func (m *MemoryStore) IsAreaBanned(ipid string, hdid string, area string) (bool, BanInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.bans {
+		if !isActive(b.Duration) || ((ipid == "" || b.Ipid != ipid) && (hdid == "" || b.Hdid != hdid)) {
+			continue
+		}
+		for _, a := range b.Areas {
+			if a == area {
+				return true, b.BanInfo, nil
+			}
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// list returns the ban list with the given name, creating it if needed.
+// The caller must hold m.mu.
+func (m *MemoryStore) list(name string) *memList {
+	l, ok := m.shared[name]
+	if !ok {
+		l = &memList{enabled: true}
+		m.shared[name] = l
+	}
+	return l
+}
+
+// ReplaceSharedBans replaces every ban from the given list.
+func (m *MemoryStore) ReplaceSharedBans(list string, bans []BanInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.list(list)
+	l.bans = append([]BanInfo(nil), bans...)
+	l.updated = time.Now().UTC().Unix()
+	return nil
+}
+
+// IsSharedBanned returns whether the given ipid/hdid is banned by an enabled list, and the info of the ban.
+func (m *MemoryStore) IsSharedBanned(by BanLookup, value string) (bool, BanInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, l := range m.shared {
+		if !l.enabled {
+			continue
+		}
+		for _, b := range l.bans {
+			if ((by == IPID && b.Ipid == value) || (by == HDID && b.Hdid == value)) && isActive(b.Duration) {
+				return true, BanInfo{Id: b.Id, Duration: b.Duration, Reason: b.Reason, Source: name}, nil
+			}
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// BanLists returns every ban list that has been fetched or toggled.
+func (m *MemoryStore) BanLists() ([]BanListInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var lists []BanListInfo
+	for name, l := range m.shared {
+		lists = append(lists, BanListInfo{Name: name, Enabled: l.enabled, Updated: l.updated, Bans: len(l.bans)})
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	return lists, nil
+}
+
+// SetBanListEnabled enables or disables a ban list.
+func (m *MemoryStore) SetBanListEnabled(list string, enabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list(list).enabled = enabled
+	return nil
+}
+
+// RemoveBanList deletes a ban list and every ban from it.
+func (m *MemoryStore) RemoveBanList(list string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.shared, list)
+	return nil
+}
+
+// RekeyBans replaces a legacy ipid/hdid with its current value in all bans that have not yet been converted.
+func (m *MemoryStore) RekeyBans(by BanLookup, legacy string, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range m.bans {
+		switch {
+		case by == IPID && b.legacyIpid != "" && b.legacyIpid == legacy:
+			b.Ipid, b.legacyIpid = value, ""
+		case by == HDID && b.legacyHdid != "" && b.legacyHdid == legacy:
+			b.Hdid, b.legacyHdid = value, ""
+		}
+	}
+	return nil
+}
+
+// UpdateReason updates the reason of a ban.
+func (m *MemoryStore) UpdateReason(id int, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b := m.ban(id); b != nil {
+		b.Reason = reason
+	}
+	return nil
+}
+
+// UpdateDuration updates the duration of a ban.
+func (m *MemoryStore) UpdateDuration(id int, duration int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b := m.ban(id); b != nil {
+		b.Duration = duration
+	}
+	return nil
+}
+
+// Backup returns ErrNoBackup, as an in-memory store has nothing to back up.
+func (m *MemoryStore) Backup(path string) error {
+	return ErrNoBackup
+}
+
+// Close closes the store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
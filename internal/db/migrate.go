@@ -0,0 +1,188 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A migration upgrades the database by one version.
+type migration func(tx *sql.Tx) error
+
+// migrations contains every change made to the database's schema, in order.
+// migrations[i] upgrades a database from version i to version i+1.
+// Released migrations must never be edited; make further changes by appending a new migration.
+var migrations = []migration{
+	// v1: Initial schema.
+	// Databases created before versioning was introduced already have these tables at version 0.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE IF NOT EXISTS BANS(ID INTEGER PRIMARY KEY, IPID TEXT, HDID TEXT, TIME INTEGER, DURATION INTEGER, REASON TEXT, MODERATOR TEXT)")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE TABLE IF NOT EXISTS USERS(USERNAME TEXT PRIMARY KEY, PASSWORD TEXT, PERMISSIONS TEXT)")
+		return err
+	},
+
+	// v2: IPIDs and HDIDs are now keyed hashes, so existing bans are kept in legacy columns until the banned user reconnects.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE BANS ADD COLUMN LEGACY_IPID TEXT")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("ALTER TABLE BANS ADD COLUMN LEGACY_HDID TEXT")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE BANS SET LEGACY_IPID = IPID, LEGACY_HDID = HDID")
+		return err
+	},
+
+	// v3: Bans imported from other servers record the server they came from.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE BANS ADD COLUMN SOURCE TEXT")
+		return err
+	},
+
+	// v4: Bans from subscribed ban lists are kept apart from local bans.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE BAN_LISTS(NAME TEXT PRIMARY KEY, ENABLED INTEGER NOT NULL DEFAULT 1, UPDATED INTEGER)")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE TABLE SHARED_BANS(LIST TEXT NOT NULL, ID INTEGER, IPID TEXT, HDID TEXT, TIME INTEGER, DURATION INTEGER, REASON TEXT, MODERATOR TEXT)")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX SHARED_BANS_LIST ON SHARED_BANS(LIST)")
+		return err
+	},
+
+	// v5: Bans can be scoped to a set of areas. Bans without areas apply to the whole server.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE BAN_AREAS(BAN_ID INTEGER NOT NULL REFERENCES BANS(ID), AREA TEXT NOT NULL)")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX BAN_AREAS_BAN_ID ON BAN_AREAS(BAN_ID)")
+		return err
+	},
+
+	// v6: Indexes for looking up and searching bans.
+	func(tx *sql.Tx) error {
+		for _, column := range []string{"IPID", "HDID", "TIME"} {
+			_, err := tx.Exec(fmt.Sprintf("CREATE INDEX BANS_%[1]v ON BANS(%[1]v)", column))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	// v7: Users may enroll in two-factor authentication.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE USERS ADD COLUMN TOTP_SECRET TEXT")
+		return err
+	},
+
+	// v8: Users store the name of their role rather than its permissions, and may have per-user permission overrides.
+	// Existing users keep their permissions until the server assigns them a role.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE USERS ADD COLUMN ROLE TEXT")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE TABLE USER_PERMISSIONS(USERNAME TEXT NOT NULL, PERMISSION TEXT NOT NULL, ALLOW INTEGER NOT NULL, PRIMARY KEY(USERNAME, PERMISSION))")
+		return err
+	},
+
+	// v9: Users can be granted roles in individual areas.
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE AREA_ROLES(USERNAME TEXT NOT NULL, AREA TEXT NOT NULL, ROLE TEXT NOT NULL, PRIMARY KEY(USERNAME, AREA, ROLE))")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("CREATE INDEX AREA_ROLES_AREA ON AREA_ROLES(AREA)")
+		return err
+	},
+}
+
+// Version returns the database version supported by this version of athena.
+func Version() int {
+	return len(migrations)
+}
+
+// migrate upgrades the database to the latest version, backing it up first.
+func (s *SQLiteStore) migrate() error {
+	v, err := s.userVersion()
+	if err != nil {
+		return err
+	}
+	if v > Version() {
+		return fmt.Errorf("database is version %v, but this version of athena only supports up to version %v", v, Version())
+	}
+	if v == Version() {
+		return nil
+	}
+
+	var tables int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables)
+	if err != nil {
+		return err
+	}
+	if tables > 0 {
+		err = s.Backup(fmt.Sprintf("%v.v%v-%v.bak", s.path, v, time.Now().UTC().Format("20060102T150405")))
+		if err != nil {
+			return fmt.Errorf("failed to back up database before upgrading: %v", err)
+		}
+	}
+
+	for ; v < Version(); v++ {
+		err = s.runMigration(v)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade database to version %v: %v", v+1, err)
+		}
+	}
+	return nil
+}
+
+// runMigration runs the migration from version v in a transaction, and bumps the database's version.
+func (s *SQLiteStore) runMigration(v int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = migrations[v](tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", v+1))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// userVersion returns the version of the database.
+func (s *SQLiteStore) userVersion() (int, error) {
+	var v int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&v)
+	return v, err
+}
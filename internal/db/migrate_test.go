@@ -0,0 +1,155 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFixture opens a copy of the given test database.
+func openFixture(t *testing.T, name string) *SQLiteStore {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "athena.db")
+	err = os.WriteFile(path, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Every released database version must have a fixture in testdata, named v<version>.db.
+// Each fixture contains a single ban with ID 1, IPID "ipid" and HDID "hdid", and a user "admin" with the password "hunter2".
+func TestMigrateFixtures(t *testing.T) {
+	for v := 0; v <= Version(); v++ {
+		t.Run(fmt.Sprintf("v%v", v), func(t *testing.T) {
+			s := openFixture(t, fmt.Sprintf("v%v.db", v))
+
+			got, err := s.userVersion()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != Version() {
+				t.Errorf("database is version %v, want %v", got, Version())
+			}
+			backups, _ := filepath.Glob(s.path + ".v*.bak")
+			if v < Version() && len(backups) != 1 {
+				t.Errorf("expected a backup before upgrading, got %v", backups)
+			} else if v == Version() && len(backups) != 0 {
+				t.Errorf("unexpected backup of an up to date database: %v", backups)
+			}
+
+			bans, err := s.GetBan(BANID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bans) != 1 || bans[0].Ipid != "ipid" || bans[0].Hdid != "hdid" || bans[0].Reason != "reason" {
+				t.Errorf("ban was not preserved, got %+v", bans)
+			}
+			for by, value := range map[BanLookup]string{IPID: "ipid", HDID: "hdid"} {
+				if banned, _, err := s.IsBanned(by, value); !banned || err != nil {
+					t.Errorf("IsBanned(%v, %q) = %v, %v", by, value, banned, err)
+				}
+				// Bans from before keyed hashes must be found by their legacy ID.
+				if err := s.RekeyBans(by, value, "new"+value); err != nil {
+					t.Fatal(err)
+				}
+				if banned, _, _ := s.IsBanned(by, "new"+value); !banned {
+					t.Errorf("legacy ban was not rekeyed for %v", by)
+				}
+			}
+
+			if !s.AuthenticateUser("admin", []byte("hunter2")) {
+				t.Errorf("user's password was not preserved")
+			}
+			if u, err := s.GetUser("admin"); err != nil || u.Role != "" || u.LegacyPermissions != ^uint64(0) {
+				t.Errorf("user's permissions were not preserved, got %+v, %v", u, err)
+			}
+		})
+	}
+}
+
+func TestMigrateNew(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "athena.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _ := s.userVersion(); v != Version() {
+		t.Errorf("new database is version %v, want %v", v, Version())
+	}
+	if backups, _ := filepath.Glob(s.path + ".v*.bak"); len(backups) != 0 {
+		t.Errorf("unexpected backup of a new database: %v", backups)
+	}
+	if _, err := s.AddBan(BanInfo{Ipid: "ipid", Hdid: "hdid", Time: 0, Duration: -1, Reason: "reason", Moderator: "mod"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefuseNewer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "athena.db")
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Exec(fmt.Sprintf("PRAGMA user_version = %d", Version()+1))
+	d.Close()
+	if s, err := OpenSQLite(path); err == nil {
+		s.Close()
+		t.Errorf("opened a database newer than supported")
+	}
+}
+
+func TestMigrationRollback(t *testing.T) {
+	migrations = append(migrations, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE BROKEN(ID INTEGER)"); err != nil {
+			return err
+		}
+		return errors.New("broken migration")
+	})
+	defer func() { migrations = migrations[:len(migrations)-1] }()
+
+	path := filepath.Join(t.TempDir(), "athena.db")
+	if s, err := OpenSQLite(path); err == nil {
+		s.Close()
+		t.Fatal("expected error from broken migration")
+	}
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var v, tables int
+	d.QueryRow("PRAGMA user_version").Scan(&v)
+	d.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'BROKEN'").Scan(&tables)
+	if v != Version()-1 || tables != 0 {
+		t.Errorf("failed migration was not rolled back, version %v, %v tables", v, tables)
+	}
+}
@@ -0,0 +1,641 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// The columns of BANS read into a BanInfo.
+// A ban's areas are joined with areaSep, which cannot appear in an area name.
+const banColumns = "ID, IPID, HDID, TIME, DURATION, REASON, MODERATOR, COALESCE(SOURCE, ''), " +
+	"COALESCE((SELECT GROUP_CONCAT(AREA, char(31)) FROM BAN_AREAS WHERE BAN_ID = BANS.ID), '')"
+
+const areaSep = "\x1f"
+
+// Matches server-wide bans.
+const serverWide = "NOT EXISTS (SELECT 1 FROM BAN_AREAS WHERE BAN_ID = BANS.ID)"
+
+// SQLiteStore is a Store backed by an SQLite database.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// OpenSQLite opens the SQLite database at the given path, creating or upgrading it if needed.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLiteStore{db: d, path: path}
+	err = s.migrate()
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// UserExists returns whether a user exists within the server's database.
+func (s *SQLiteStore) UserExists(username string) bool {
+	result := s.db.QueryRow("SELECT USERNAME FROM USERS WHERE USERNAME = ?", username)
+	if result.Scan() == sql.ErrNoRows {
+		return false
+	} else {
+		return true
+	}
+}
+
+// CreateUser adds a new user to the server's database.
+func (s *SQLiteStore) CreateUser(username string, password []byte, role string) error {
+	hashed, err := bcrypt.GenerateFromPassword(password, 12)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT INTO USERS(USERNAME, PASSWORD, ROLE) VALUES(?, ?, ?)", username, hashed, role)
+	if err != nil {
+		if s.UserExists(username) {
+			return ErrUserExists
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveUser deletes a user from the server's database.
+func (s *SQLiteStore) RemoveUser(username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM USER_PERMISSIONS WHERE USERNAME = ?", username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM AREA_ROLES WHERE USERNAME = ?", username)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM USERS WHERE USERNAME = ?", username)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AuthenticateUser returns whether or not the user's credentials match those in the database.
+func (s *SQLiteStore) AuthenticateUser(username string, password []byte) bool {
+	var rpass string
+	result := s.db.QueryRow("SELECT PASSWORD FROM USERS WHERE USERNAME = ?", username)
+	result.Scan(&rpass)
+	return bcrypt.CompareHashAndPassword([]byte(rpass), password) == nil
+}
+
+// GetUser returns a user, or ErrNoUser if they do not exist.
+func (s *SQLiteStore) GetUser(username string) (User, error) {
+	users, err := s.users("WHERE USERNAME = ?", username)
+	if err != nil {
+		return User{}, err
+	}
+	if len(users) == 0 {
+		return User{}, ErrNoUser
+	}
+	return users[0], nil
+}
+
+// Users returns every user, in order of name.
+func (s *SQLiteStore) Users() ([]User, error) {
+	return s.users("")
+}
+
+// users returns the users matching a WHERE clause, with their overrides.
+func (s *SQLiteStore) users(where string, args ...any) ([]User, error) {
+	result, err := s.db.Query("SELECT USERNAME, COALESCE(ROLE, ''), COALESCE(PERMISSIONS, '0') FROM USERS "+where+" ORDER BY USERNAME", args...)
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	for result.Next() {
+		var u User
+		var perms string
+		result.Scan(&u.Name, &u.Role, &perms)
+		u.LegacyPermissions, _ = strconv.ParseUint(perms, 10, 64)
+		users = append(users, u)
+	}
+	result.Close()
+	for i := range users {
+		overrides, err := s.db.Query("SELECT PERMISSION, ALLOW FROM USER_PERMISSIONS WHERE USERNAME = ? ORDER BY PERMISSION", users[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		for overrides.Next() {
+			var perm string
+			var allow bool
+			overrides.Scan(&perm, &allow)
+			if allow {
+				users[i].Grants = append(users[i].Grants, perm)
+			} else {
+				users[i].Denies = append(users[i].Denies, perm)
+			}
+		}
+		overrides.Close()
+	}
+	return users, nil
+}
+
+// SetRole changes the role of a user in the database.
+func (s *SQLiteStore) SetRole(username string, role string) error {
+	_, err := s.db.Exec("UPDATE USERS SET ROLE = ? WHERE USERNAME = ?", role, username)
+	return err
+}
+
+// SetOverride grants a permission to a user if allow is true, or denies it if not.
+func (s *SQLiteStore) SetOverride(username string, permission string, allow bool) error {
+	_, err := s.db.Exec("INSERT INTO USER_PERMISSIONS(USERNAME, PERMISSION, ALLOW) VALUES(?, ?, ?) "+
+		"ON CONFLICT(USERNAME, PERMISSION) DO UPDATE SET ALLOW = excluded.ALLOW", username, permission, allow)
+	return err
+}
+
+// ClearOverride removes a user's grant or denial of a permission.
+func (s *SQLiteStore) ClearOverride(username string, permission string) error {
+	_, err := s.db.Exec("DELETE FROM USER_PERMISSIONS WHERE USERNAME = ? AND PERMISSION = ?", username, permission)
+	return err
+}
+
+// GrantAreaRole gives a user a role in an area.
+func (s *SQLiteStore) GrantAreaRole(username string, area string, role string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO AREA_ROLES(USERNAME, AREA, ROLE) VALUES(?, ?, ?)", username, area, role)
+	return err
+}
+
+// RevokeAreaRole takes a role in an area away from a user.
+func (s *SQLiteStore) RevokeAreaRole(username string, area string, role string) error {
+	_, err := s.db.Exec("DELETE FROM AREA_ROLES WHERE USERNAME = ? AND AREA = ? AND ROLE = ?", username, area, role)
+	return err
+}
+
+// AreaRoles returns the roles a user has been granted in areas, ordered by area.
+func (s *SQLiteStore) AreaRoles(username string) ([]AreaRole, error) {
+	return s.areaRoles("SELECT USERNAME, AREA, ROLE FROM AREA_ROLES WHERE USERNAME = ? ORDER BY AREA, ROLE", username)
+}
+
+// AreaRoleHolders returns the users who have been granted roles in an area, ordered by username.
+func (s *SQLiteStore) AreaRoleHolders(area string) ([]AreaRole, error) {
+	return s.areaRoles("SELECT USERNAME, AREA, ROLE FROM AREA_ROLES WHERE AREA = ? ORDER BY USERNAME, ROLE", area)
+}
+
+// areaRoles returns the area roles selected by a query.
+func (s *SQLiteStore) areaRoles(query string, arg string) ([]AreaRole, error) {
+	result, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	var roles []AreaRole
+	for result.Next() {
+		var r AreaRole
+		result.Scan(&r.Username, &r.Area, &r.Role)
+		roles = append(roles, r)
+	}
+	return roles, result.Err()
+}
+
+// ChangePassword updates the password of a user in the database.
+func (s *SQLiteStore) ChangePassword(username string, password []byte) error {
+	hashed, err := bcrypt.GenerateFromPassword(password, 12)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE USERS SET PASSWORD = ? WHERE USERNAME = ?", hashed, username)
+	return err
+}
+
+// TOTPSecret returns a user's two-factor authentication secret, or an empty string if they have not enrolled.
+func (s *SQLiteStore) TOTPSecret(username string) (string, error) {
+	var secret string
+	err := s.db.QueryRow("SELECT COALESCE(TOTP_SECRET, '') FROM USERS WHERE USERNAME = ?", username).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return secret, err
+}
+
+// SetTOTPSecret sets a user's two-factor authentication secret.
+func (s *SQLiteStore) SetTOTPSecret(username string, secret string) error {
+	_, err := s.db.Exec("UPDATE USERS SET TOTP_SECRET = NULLIF(?, '') WHERE USERNAME = ?", secret, username)
+	return err
+}
+
+// AddBan adds a new ban to the database, returning its ID.
+func (s *SQLiteStore) AddBan(b BanInfo) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO BANS(IPID, HDID, TIME, DURATION, REASON, MODERATOR, SOURCE) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, ''))",
+		b.Ipid, b.Hdid, b.Time, b.Duration, b.Reason, b.Moderator, b.Source)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range b.Areas {
+		_, err = tx.Exec("INSERT INTO BAN_AREAS(BAN_ID, AREA) VALUES(?, ?)", id, a)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(id), tx.Commit()
+}
+
+// UnBan nullifies a ban in the database.
+func (s *SQLiteStore) UnBan(id int) error {
+	_, err := s.db.Exec("UPDATE BANS SET DURATION = 0 WHERE ID = ?", id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetBan returns a list of bans matching a given value.
+func (s *SQLiteStore) GetBan(by BanLookup, value any) ([]BanInfo, error) {
+	var stmt *sql.Stmt
+	var err error
+	switch by {
+	case BANID:
+		stmt, err = s.db.Prepare("SELECT " + banColumns + " FROM BANS WHERE ID = ?")
+	case IPID:
+		stmt, err = s.db.Prepare("SELECT " + banColumns + " FROM BANS WHERE IPID = ? ORDER BY TIME DESC")
+	case HDID:
+		stmt, err = s.db.Prepare("SELECT " + banColumns + " FROM BANS WHERE HDID = ? ORDER BY TIME DESC")
+	}
+	if err != nil {
+		return []BanInfo{}, err
+	}
+	result, err := stmt.Query(value)
+	if err != nil {
+		return []BanInfo{}, err
+	}
+	stmt.Close()
+	defer result.Close()
+	return scanBans(result), nil
+}
+
+// AllBans returns every ban, oldest first.
+func (s *SQLiteStore) AllBans() ([]BanInfo, error) {
+	result, err := s.db.Query("SELECT " + banColumns + " FROM BANS ORDER BY ID")
+	if err != nil {
+		return []BanInfo{}, err
+	}
+	defer result.Close()
+	return scanBans(result), nil
+}
+
+// SearchBans returns up to limit bans matching a filter, most recent first, skipping the first offset matches.
+func (s *SQLiteStore) SearchBans(f BanFilter, offset int, limit int) ([]BanInfo, int, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, values ...any) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+	if f.Ipid != "" {
+		add("IPID = ?", f.Ipid)
+	}
+	if f.Hdid != "" {
+		add("HDID = ?", f.Hdid)
+	}
+	if f.Moderator != "" {
+		add("MODERATOR = ?", f.Moderator)
+	}
+	if f.Reason != "" {
+		add("INSTR(LOWER(REASON), LOWER(?)) > 0", f.Reason)
+	}
+	if f.After != 0 {
+		add("TIME >= ?", f.After)
+	}
+	if f.Before != 0 {
+		add("TIME < ?", f.Before)
+	}
+	now := time.Now().UTC().Unix()
+	switch f.Status {
+	case Active:
+		add("(DURATION = -1 OR DURATION > ?)", now)
+	case Expired:
+		add("DURATION != -1 AND DURATION <= ?", now)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM BANS"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := s.db.Query("SELECT "+banColumns+" FROM BANS"+where+" ORDER BY TIME DESC, ID DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer result.Close()
+	return scanBans(result), total, nil
+}
+
+// BanStats returns statistics about the server's bans, keeping at most limit entries in each list.
+func (s *SQLiteStore) BanStats(limit int) (BanStats, error) {
+	var stats BanStats
+	err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(DURATION = -1 OR DURATION > ?), 0) FROM BANS", time.Now().UTC().Unix()).
+		Scan(&stats.Total, &stats.Active)
+	if err != nil {
+		return stats, err
+	}
+	counts := func(query string) ([]Count, error) {
+		result, err := s.db.Query(query, limit)
+		if err != nil {
+			return nil, err
+		}
+		defer result.Close()
+		var counts []Count
+		for result.Next() {
+			var c Count
+			result.Scan(&c.Key, &c.Count)
+			counts = append(counts, c)
+		}
+		return counts, result.Err()
+	}
+	stats.Moderators, err = counts("SELECT MODERATOR, COUNT(*) AS N FROM BANS GROUP BY MODERATOR ORDER BY N DESC, MODERATOR LIMIT ?")
+	if err != nil {
+		return stats, err
+	}
+	// A week starts on the Monday on or before the ban: the following Sunday, less 6 days.
+	stats.Weeks, err = counts("SELECT DATE(TIME, 'unixepoch', 'weekday 0', '-6 days') AS WEEK, COUNT(*) FROM BANS GROUP BY WEEK ORDER BY WEEK DESC LIMIT ?")
+	if err != nil {
+		return stats, err
+	}
+	stats.Reasons, err = counts("SELECT REASON, COUNT(*) AS N FROM BANS GROUP BY REASON ORDER BY N DESC, REASON LIMIT ?")
+	return stats, err
+}
+
+// scanBans reads a list of bans from rows selected with banColumns.
+func scanBans(rows *sql.Rows) []BanInfo {
+	var bans []BanInfo
+	for rows.Next() {
+		var b BanInfo
+		var areas string
+		rows.Scan(&b.Id, &b.Ipid, &b.Hdid, &b.Time, &b.Duration, &b.Reason, &b.Moderator, &b.Source, &areas)
+		if areas != "" {
+			b.Areas = strings.Split(areas, areaSep)
+		}
+		bans = append(bans, b)
+	}
+	return bans
+}
+
+// BanExists returns whether a ban with the given ipid, hdid and start time exists.
+func (s *SQLiteStore) BanExists(ipid string, hdid string, time int64) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM BANS WHERE IPID = ? AND HDID = ? AND TIME = ?", ipid, hdid, time).Scan(&n)
+	return n > 0, err
+}
+
+// IsBanned returns whether the given ipid/hdid is banned, and the info of the ban.
+func (s *SQLiteStore) IsBanned(by BanLookup, value string) (bool, BanInfo, error) {
+	var stmt *sql.Stmt
+	var err error
+	switch by {
+	case IPID:
+		stmt, err = s.db.Prepare("SELECT ID, DURATION, REASON FROM BANS WHERE IPID = ? AND " + serverWide)
+	case HDID:
+		stmt, err = s.db.Prepare("SELECT ID, DURATION, REASON FROM BANS WHERE HDID = ? AND " + serverWide)
+	}
+	if err != nil {
+		return false, BanInfo{}, err
+	}
+	result, err := stmt.Query(value)
+	if err != nil {
+		return false, BanInfo{}, err
+	}
+	stmt.Close()
+	defer result.Close()
+	for result.Next() {
+		var (
+			duration int64
+			id       int
+			reason   string
+		)
+		result.Scan(&id, &duration, &reason)
+		if isActive(duration) {
+			return true, BanInfo{Id: id, Duration: duration, Reason: reason}, nil
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// IsAreaBanned returns whether a client with the given ipid and hdid is banned from an area, and the info of the ban.
+func (s *SQLiteStore) IsAreaBanned(ipid string, hdid string, area string) (bool, BanInfo, error) {
+	result, err := s.db.Query("SELECT "+banColumns+" FROM BANS WHERE ID IN (SELECT BAN_ID FROM BAN_AREAS WHERE AREA = ?) "+
+		"AND ((IPID = ? AND IPID != '') OR (HDID = ? AND HDID != ''))", area, ipid, hdid)
+	if err != nil {
+		return false, BanInfo{}, err
+	}
+	defer result.Close()
+	for _, b := range scanBans(result) {
+		if isActive(b.Duration) {
+			return true, b, nil
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// ReplaceSharedBans replaces every ban from the given list.
+func (s *SQLiteStore) ReplaceSharedBans(list string, bans []BanInfo) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM SHARED_BANS WHERE LIST = ?", list)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO SHARED_BANS(LIST, ID, IPID, HDID, TIME, DURATION, REASON, MODERATOR) VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, b := range bans {
+		_, err = stmt.Exec(list, b.Id, b.Ipid, b.Hdid, b.Time, b.Duration, b.Reason, b.Moderator)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO BAN_LISTS(NAME, UPDATED) VALUES(?, ?) ON CONFLICT(NAME) DO UPDATE SET UPDATED = excluded.UPDATED",
+		list, time.Now().UTC().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsSharedBanned returns whether the given ipid/hdid is banned by an enabled list, and the info of the ban.
+func (s *SQLiteStore) IsSharedBanned(by BanLookup, value string) (bool, BanInfo, error) {
+	var column string
+	switch by {
+	case IPID:
+		column = "IPID"
+	case HDID:
+		column = "HDID"
+	default:
+		return false, BanInfo{}, nil
+	}
+	result, err := s.db.Query("SELECT S.ID, S.DURATION, S.REASON, S.LIST FROM SHARED_BANS S JOIN BAN_LISTS L ON L.NAME = S.LIST WHERE L.ENABLED AND S."+column+" = ?", value)
+	if err != nil {
+		return false, BanInfo{}, err
+	}
+	defer result.Close()
+	for result.Next() {
+		var b BanInfo
+		result.Scan(&b.Id, &b.Duration, &b.Reason, &b.Source)
+		if isActive(b.Duration) {
+			return true, b, nil
+		}
+	}
+	return false, BanInfo{}, nil
+}
+
+// BanLists returns every ban list that has been fetched or toggled.
+func (s *SQLiteStore) BanLists() ([]BanListInfo, error) {
+	result, err := s.db.Query("SELECT L.NAME, L.ENABLED, COALESCE(L.UPDATED, 0), (SELECT COUNT(*) FROM SHARED_BANS S WHERE S.LIST = L.NAME) FROM BAN_LISTS L ORDER BY L.NAME")
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+	var lists []BanListInfo
+	for result.Next() {
+		var l BanListInfo
+		result.Scan(&l.Name, &l.Enabled, &l.Updated, &l.Bans)
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+// RemoveBanList deletes a ban list and every ban from it.
+func (s *SQLiteStore) RemoveBanList(list string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM SHARED_BANS WHERE LIST = ?", list)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM BAN_LISTS WHERE NAME = ?", list)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetBanListEnabled enables or disables a ban list.
+func (s *SQLiteStore) SetBanListEnabled(list string, enabled bool) error {
+	_, err := s.db.Exec("INSERT INTO BAN_LISTS(NAME, ENABLED) VALUES(?, ?) ON CONFLICT(NAME) DO UPDATE SET ENABLED = excluded.ENABLED", list, enabled)
+	return err
+}
+
+// RekeyBans replaces a legacy ipid/hdid with its current value in all bans that have not yet been converted.
+func (s *SQLiteStore) RekeyBans(by BanLookup, legacy string, value string) error {
+	var err error
+	switch by {
+	case IPID:
+		_, err = s.db.Exec("UPDATE BANS SET IPID = ?, LEGACY_IPID = NULL WHERE LEGACY_IPID = ?", value, legacy)
+	case HDID:
+		_, err = s.db.Exec("UPDATE BANS SET HDID = ?, LEGACY_HDID = NULL WHERE LEGACY_HDID = ?", value, legacy)
+	}
+	return err
+}
+
+// UpdateReason updates the reason of a ban.
+func (s *SQLiteStore) UpdateReason(id int, reason string) error {
+	_, err := s.db.Exec("UPDATE BANS SET REASON = ? WHERE ID = ?", reason, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateDuration updates the duration of a ban.
+func (s *SQLiteStore) UpdateDuration(id int, duration int64) error {
+	_, err := s.db.Exec("UPDATE BANS SET DURATION = ? WHERE ID = ?", duration, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Backup writes a copy of the database to the given path, and checks the copy's integrity.
+func (s *SQLiteStore) Backup(path string) error {
+	_, err := s.db.Exec("VACUUM INTO ?", path)
+	if err != nil {
+		return err
+	}
+	err = CheckIntegrity(path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+// CheckIntegrity checks the SQLite database at the given path for corruption.
+func CheckIntegrity(path string) error {
+	d, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	var result string
+	err = d.QueryRow("PRAGMA integrity_check").Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check of %v failed: %v", path, result)
+	}
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
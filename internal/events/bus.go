@@ -0,0 +1,207 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package events delivers server events, such as chat messages and moderator actions, to subscribers.
+package events
+
+import (
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+)
+
+const queueSize = 256 // How many events each subscriber can have waiting.
+
+var publishTimeout = time.Second // How long a publisher waits for a full queue before dropping the event.
+
+// A Bus delivers published events to its subscribers.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   []*Subscription
+	closed bool
+}
+
+// A Subscription receives events from a bus, in the order they were published, on its own goroutine.
+type Subscription struct {
+	name    string
+	bus     *Bus
+	accepts func(Event) bool
+	handle  func(Event)
+	queue   chan Event
+	quit    chan struct{} // Closed when the subscription ends.
+	done    chan struct{} // Closed once the remaining queued events have been handled.
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// New returns a new bus with no subscribers.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers a handler for events of type E on the bus. The name identifies the subscriber in logs.
+func Subscribe[E Event](b *Bus, name string, handler func(E)) *Subscription {
+	return b.subscribe(name, func(e Event) bool {
+		_, ok := e.(E)
+		return ok
+	}, func(e Event) {
+		handler(e.(E))
+	})
+}
+
+// SubscribeAll registers a handler for every event on the bus.
+func (b *Bus) SubscribeAll(name string, handler func(Event)) *Subscription {
+	return b.subscribe(name, func(Event) bool { return true }, handler)
+}
+
+func (b *Bus) subscribe(name string, accepts func(Event) bool, handle func(Event)) *Subscription {
+	s := &Subscription{
+		name:    name,
+		bus:     b,
+		accepts: accepts,
+		handle:  handle,
+		queue:   make(chan Event, queueSize),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	b.mu.Lock()
+	if b.closed {
+		s.stop()
+	} else {
+		b.subs = append(b.subs, s)
+	}
+	b.mu.Unlock()
+	go s.run()
+	return s
+}
+
+// Publish queues an event for each of its subscribers, and returns without waiting for them to handle it.
+// If a subscriber's queue is full, Publish waits for it to make room, and drops the event for that subscriber
+// if it does not do so in time.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	subs := b.subs
+	closed := b.closed
+	b.mu.RUnlock()
+	if closed {
+		return
+	}
+	for _, s := range subs {
+		if s.accepts(e) {
+			s.send(e)
+		}
+	}
+}
+
+// Close ends every subscription, waiting for them to handle the events already queued.
+// Events published after Close are discarded.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.closed = true
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.stop()
+	}
+	for _, s := range subs {
+		<-s.done
+	}
+}
+
+// Unsubscribe ends the subscription. Events already queued are still handled, but no new events are received.
+func (s *Subscription) Unsubscribe() {
+	b := s.bus
+	b.mu.Lock()
+	for i, x := range b.subs {
+		if x == s {
+			// The slice is copied, as Publish may be reading the old one.
+			b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+	s.stop()
+}
+
+// Done returns a channel that is closed once the subscription has ended and handled its remaining events.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns how many events the subscription has missed because its queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) stop() {
+	s.once.Do(func() { close(s.quit) })
+}
+
+// send queues an event for the subscriber, waiting up to publishTimeout if its queue is full.
+func (s *Subscription) send(e Event) {
+	select {
+	case s.queue <- e:
+		return
+	case <-s.quit:
+		return
+	default:
+	}
+	t := time.NewTimer(publishTimeout)
+	defer t.Stop()
+	select {
+	case s.queue <- e:
+	case <-s.quit:
+	case <-t.C:
+		if n := s.dropped.Add(1); n == 1 || n%100 == 0 {
+			logger.LogWarningf("Event subscriber %v is not keeping up, and has dropped %v events.", s.name, n)
+		}
+	}
+}
+
+// run handles queued events until the subscription ends.
+func (s *Subscription) run() {
+	defer close(s.done)
+	for {
+		select {
+		case e := <-s.queue:
+			s.deliver(e)
+		case <-s.quit:
+			for {
+				select {
+				case e := <-s.queue:
+					s.deliver(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver passes an event to the subscriber's handler, recovering from any panic so that one faulty subscriber
+// cannot bring down the server.
+func (s *Subscription) deliver(e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.LogErrorf("Event subscriber %v panicked while handling %v: %v\n%s", s.name, e.Name(), r, debug.Stack())
+		}
+	}()
+	s.handle(e)
+}
@@ -0,0 +1,115 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	b := New()
+	var messages []string
+	var all []string
+	Subscribe(b, "messages", func(e OOCMessage) {
+		messages = append(messages, e.Message)
+	})
+	b.SubscribeAll("all", func(e Event) {
+		all = append(all, e.Name())
+	})
+	b.Publish(OOCMessage{Message: "first"})
+	b.Publish(ClientJoined{})
+	b.Publish(OOCMessage{Message: "second"})
+	b.Close()
+
+	if want := []string{"first", "second"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("typed subscriber got %q, want %q", messages, want)
+	}
+	if want := []string{"OOCMessage", "ClientJoined", "OOCMessage"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("subscriber to all events got %q, want %q", all, want)
+	}
+	b.Publish(OOCMessage{Message: "after close"})
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := New()
+	got := make(chan string, 10)
+	s := Subscribe(b, "test", func(e Audit) { got <- e.Line })
+	b.Publish(Audit{Line: "before"})
+	s.Unsubscribe()
+	<-s.Done()
+	b.Publish(Audit{Line: "after"})
+	b.Close()
+	close(got)
+	var lines []string
+	for l := range got {
+		lines = append(lines, l)
+	}
+	if !reflect.DeepEqual(lines, []string{"before"}) {
+		t.Errorf("got %q after unsubscribing", lines)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	b := New()
+	var handled int
+	Subscribe(b, "faulty", func(e Kick) {
+		if e.Reason == "panic" {
+			panic("handler failed")
+		}
+		handled++
+	})
+	b.Publish(Kick{Reason: "panic"})
+	b.Publish(Kick{Reason: "fine"})
+	b.Close()
+	if handled != 1 {
+		t.Errorf("subscriber handled %v events after panicking, want 1", handled)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	timeout := publishTimeout
+	publishTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { publishTimeout = timeout })
+
+	b := New()
+	started, block := make(chan struct{}, 1), make(chan struct{})
+	var handled int
+	s := Subscribe(b, "slow", func(e Audit) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-block
+		handled++
+	})
+	// The first event is taken by the handler, and the rest fill the queue.
+	b.Publish(Audit{})
+	<-started
+	for i := 0; i < queueSize+2; i++ {
+		b.Publish(Audit{})
+	}
+	if s.Dropped() != 2 {
+		t.Errorf("dropped %v events, want 2", s.Dropped())
+	}
+	close(block)
+	b.Close()
+	if handled != queueSize+1 {
+		t.Errorf("handled %v events, want %v", handled, queueSize+1)
+	}
+}
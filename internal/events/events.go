@@ -0,0 +1,172 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package events
+
+import "time"
+
+// An Event is something that happened on the server.
+type Event interface {
+	// Name returns the name of the event's type, such as "ICMessage".
+	Name() string
+}
+
+// A Player describes a client involved in an event, as it was when the event happened.
+type Player struct {
+	UID       int
+	IPID      string
+	Character string // The client's character, or "Spectator".
+	OOCName   string
+	Area      string
+	Moderator string // The account the client is logged in as, if any.
+}
+
+// ClientJoined is published when a client finishes joining the server.
+type ClientJoined struct {
+	Player
+}
+
+// ClientLeft is published when a client that had joined the server disconnects.
+type ClientLeft struct {
+	Player
+}
+
+// AreaChanged is published when a client moves to another area. Player.Area is the area the client moved to.
+type AreaChanged struct {
+	Player
+	From string
+}
+
+// ICMessage is published when a client speaks in IC.
+type ICMessage struct {
+	Player
+	Showname string
+	Message  string
+}
+
+// OOCMessage is published when a client speaks in OOC. Commands are published as CommandExecuted instead.
+type OOCMessage struct {
+	Player
+	Message string
+}
+
+// MusicChanged is published when a client changes an area's music. Song is empty if the music was stopped.
+type MusicChanged struct {
+	Player
+	Song string
+}
+
+// An EvidenceAction is a change made to an area's evidence.
+type EvidenceAction string
+
+const (
+	EvidenceAdded   EvidenceAction = "add"
+	EvidenceEdited  EvidenceAction = "edit"
+	EvidenceRemoved EvidenceAction = "remove"
+	EvidenceSwapped EvidenceAction = "swap"
+)
+
+// EvidenceChanged is published when a client changes an area's evidence.
+// ID is the evidence's position in the list; for swaps, Other is the position it was swapped with.
+type EvidenceChanged struct {
+	Player
+	Action      EvidenceAction
+	ID          int
+	Other       int
+	Title       string
+	Description string
+}
+
+// Modcall is published when a client calls for a moderator. Log is the area's log at the time of the call.
+type Modcall struct {
+	Player
+	Reason string
+	Log    []string
+}
+
+// Ban is published when a moderator bans a user. Until is a Unix time, or -1 for a permanent ban.
+type Ban struct {
+	By     Player
+	ID     int
+	IPID   string
+	HDID   string
+	Reason string
+	Until  int64
+}
+
+// Kick is published when a moderator kicks a client from the server, or from an area if Area is true.
+type Kick struct {
+	By     Player
+	Target Player
+	Reason string
+	Area   bool
+}
+
+// Mute is published when a moderator mutes or unmutes a client. Until is zero if the mute does not expire.
+type Mute struct {
+	By     Player
+	Target Player
+	Kind   string // What the client is muted from, such as "IC" or "OOC", or "" when unmuted.
+	Until  time.Time
+}
+
+// Login is published when someone tries to log in to a moderator account.
+type Login struct {
+	Username string
+	IPID     string
+	Method   string // How the login was made: "game" or "ssh".
+	Success  bool
+	Reason   string // Why the login failed.
+}
+
+// CommandExecuted is published when a client runs a command.
+type CommandExecuted struct {
+	Player
+	Command string
+	Args    []string // Nil for commands whose arguments are sensitive, such as /login.
+}
+
+// CaseAnnounced is published when a client announces a case. Roles are the roles the case needs players for.
+type CaseAnnounced struct {
+	Player
+	Case  string
+	Roles []string
+}
+
+// Audit is published after each line is written to the server's audit log.
+type Audit struct {
+	Line string
+}
+
+// Names lists the name of every type of event.
+var Names = []string{"ClientJoined", "ClientLeft", "AreaChanged", "ICMessage", "OOCMessage", "MusicChanged", "EvidenceChanged",
+	"Modcall", "Ban", "Kick", "Mute", "Login", "CommandExecuted", "CaseAnnounced", "Audit"}
+
+func (ClientJoined) Name() string    { return "ClientJoined" }
+func (ClientLeft) Name() string      { return "ClientLeft" }
+func (AreaChanged) Name() string     { return "AreaChanged" }
+func (ICMessage) Name() string       { return "ICMessage" }
+func (OOCMessage) Name() string      { return "OOCMessage" }
+func (MusicChanged) Name() string    { return "MusicChanged" }
+func (EvidenceChanged) Name() string { return "EvidenceChanged" }
+func (Modcall) Name() string         { return "Modcall" }
+func (Ban) Name() string             { return "Ban" }
+func (Kick) Name() string            { return "Kick" }
+func (Mute) Name() string            { return "Mute" }
+func (Login) Name() string           { return "Login" }
+func (CommandExecuted) Name() string { return "CommandExecuted" }
+func (CaseAnnounced) Name() string   { return "CaseAnnounced" }
+func (Audit) Name() string           { return "Audit" }
@@ -0,0 +1,104 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package lockout locks out keys, such as accounts or IPIDs, after repeated failures.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+type entry struct {
+	failures int
+	last     time.Time // The time of the most recent failure.
+	until    time.Time // The end of the current lockout.
+}
+
+// Lockout tracks failures per key. Once a key has failed threshold times, it is locked out for base,
+// and each further failure doubles the lockout, up to max. A key's failures are forgotten once it has
+// not failed for max.
+type Lockout struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	entries   map[string]*entry
+	now       func() time.Time
+}
+
+// New returns a new Lockout.
+func New(threshold int, base time.Duration, max time.Duration) *Lockout {
+	return &Lockout{threshold: threshold, base: base, max: max, entries: make(map[string]*entry), now: time.Now}
+}
+
+// Locked returns how much longer a key is locked out for, or 0 if it is not locked out.
+func (l *Lockout) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	if d := e.until.Sub(l.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Fail records a failure for a key, and returns how long the key is now locked out for, or 0 if it is not.
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+	if e.failures < l.threshold {
+		return 0
+	}
+	d := l.base
+	for i := l.threshold; i < e.failures && d < l.max; i++ {
+		d *= 2
+	}
+	if d > l.max {
+		d = l.max
+	}
+	e.until = now.Add(d)
+	return d
+}
+
+// Reset forgets a key's failures.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// prune forgets keys that have not failed for max, and are not locked out.
+// The caller must hold l.mu.
+func (l *Lockout) prune(now time.Time) {
+	for k, e := range l.entries {
+		if now.Sub(e.last) > l.max && !now.Before(e.until) {
+			delete(l.entries, k)
+		}
+	}
+}
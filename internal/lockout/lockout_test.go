@@ -0,0 +1,66 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(3, time.Minute, 4*time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if d := l.Fail("key"); d != 0 {
+			t.Fatalf("locked out after %v failures for %v", i+1, d)
+		}
+	}
+	if d := l.Fail("key"); d != time.Minute {
+		t.Errorf("third failure locked out for %v, want 1m", d)
+	}
+	if d := l.Locked("key"); d != time.Minute {
+		t.Errorf("Locked = %v, want 1m", d)
+	}
+	if d := l.Locked("other"); d != 0 {
+		t.Errorf("unrelated key is locked out for %v", d)
+	}
+
+	// Each further failure doubles the lockout, up to the maximum.
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		if d := l.Fail("key"); d != want {
+			t.Errorf("Fail = %v, want %v", d, want)
+		}
+	}
+	now = now.Add(4 * time.Minute)
+	if d := l.Locked("key"); d != 0 {
+		t.Errorf("still locked out after the lockout ended, %v", d)
+	}
+
+	l.Reset("key")
+	if d := l.Fail("key"); d != 0 {
+		t.Errorf("failures were not reset, locked out for %v", d)
+	}
+
+	// Failures are forgotten after max without failing.
+	l.Fail("key")
+	now = now.Add(5 * time.Minute)
+	if d := l.Fail("key"); d != 0 {
+		t.Errorf("old failures were not forgotten, locked out for %v", d)
+	}
+}
@@ -18,6 +18,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -51,8 +52,16 @@ var (
 	outputLock   sync.Mutex
 	fileLock     sync.Mutex
 	DebugNetwork bool
+	stdout       io.Writer = os.Stdout
 )
 
+// SetOutput sets where messages for standard output are written, such as to a terminal that is also reading input.
+func SetOutput(w io.Writer) {
+	outputLock.Lock()
+	stdout = w
+	outputLock.Unlock()
+}
+
 // log writes a message to standard output and/or the log file if the level matches the server's set log level.
 func log(level LogLevel, s string) {
 	if level < CurrentLevel {
@@ -60,7 +69,7 @@ func log(level LogLevel, s string) {
 	}
 	if LogStdOut {
 		outputLock.Lock()
-		fmt.Printf("%v: %v: %v\n", time.Now().UTC().Format(time.StampMilli), levelToString[level], s)
+		fmt.Fprintf(stdout, "%v: %v: %v\n", time.Now().UTC().Format(time.StampMilli), levelToString[level], s)
 		outputLock.Unlock()
 	}
 	if LogFile {
@@ -28,6 +28,7 @@ import (
 type Advertisement struct {
 	Port    int    `json:"port"`
 	WSPort  int    `json:"ws_port,omitempty"`
+	WSSPort int    `json:"wss_port,omitempty"`
 	Players int    `json:"players"`
 	Name    string `json:"name"`
 	Desc    string `json:"description"`
@@ -45,6 +46,7 @@ func Advertise(msUrl string, advert Advertisement, updatePlayers chan (int), don
 			postServer(msUrl, advert)
 		case <-done:
 			ticker.Stop()
+			removeServer(msUrl, advert)
 			return
 		}
 	}
@@ -65,3 +67,26 @@ func postServer(msUrl string, advert Advertisement) {
 	}
 	resp.Body.Close()
 }
+
+// removeServer asks the master server to remove the server's advertisement.
+// This is best effort; master servers that do not support removal will drop the server once its advertisement expires.
+func removeServer(msUrl string, advert Advertisement) {
+	data, err := json.Marshal(advert)
+	if err != nil {
+		logger.LogErrorf("Failed to remove advertisement: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodDelete, msUrl, bytes.NewBuffer(data))
+	if err != nil {
+		logger.LogErrorf("Failed to remove advertisement: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.LogErrorf("Failed to remove advertisement: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
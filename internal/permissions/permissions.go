@@ -17,43 +17,241 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>. */
 package permissions
 
 import (
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 )
 
 type Role struct {
 	Name        string   `toml:"name"`
+	Extends     string   `toml:"extends"` // The name of a role whose permissions this role also has.
 	Permissions []string `toml:"permissions"`
+	Junior      bool     `toml:"junior"`       // Whether long bans by this role need another moderator's approval.
+	RequireTOTP bool     `toml:"require_totp"` // Whether users with this role must use two-factor authentication.
 }
 
-var PermissionField = map[string]uint64{
-	"NONE":        0,
-	"CM":          1,
-	"KICK":        1 << 1,
-	"BAN":         1 << 2,
-	"BYPASS_LOCK": 1 << 3,
-	"MOD_EVI":     1 << 4,
-	"MODIFY_AREA": 1 << 5,
-	"MOVE_USERS":  1 << 6,
-	"MOD_SPEAK":   1 << 7,
-	"BAN_INFO":    1 << 8,
-	"MOD_CHAT":    1 << 9,
-	"MUTE":        1 << 10,
-	"LOG":         1 << 11,
-	"ADMIN":       math.MaxUint64,
-}
-
-// GetPermissions returns the permissions for a role.
-func (r *Role) GetPermissions() uint64 {
-	var last uint64
-	var current uint64
-	for _, perm := range r.Permissions {
-		current = PermissionField[perm] | last
-		last = current
-	}
-	return current
-}
-
-// HasPermission checks if the supplied permissions matches the required permissions.
-func HasPermission(perm uint64, required uint64) bool {
-	return required == (perm & required)
+// Permission is the name of a registered permission.
+type Permission string
+
+// None is the permission required by commands that anyone can use. Every set has it.
+const None Permission = ""
+
+// registry maps each registered permission to its description.
+var registry = make(map[Permission]string)
+
+// Register adds a permission to the registry and returns it.
+// Permissions are registered while packages are initialized, so registering a name twice panics.
+func Register(name string, description string) Permission {
+	p := Permission(name)
+	if p == None {
+		panic("permissions: empty permission name")
+	}
+	if _, ok := registry[p]; ok {
+		panic("permissions: " + name + " is registered twice")
+	}
+	registry[p] = description
+	return p
+}
+
+// Lookup returns the registered permission with the given name.
+func Lookup(name string) (Permission, bool) {
+	_, ok := registry[Permission(name)]
+	return Permission(name), ok
+}
+
+// Description returns the description the permission was registered with.
+func (p Permission) Description() string {
+	return registry[p]
+}
+
+// Admin grants every permission.
+var Admin = Register("ADMIN", "Grants all permissions.")
+
+// legacyBits lists the permissions that accounts from before the registry stored as bits, in bit order.
+var legacyBits = []Permission{"CM", "KICK", "BAN", "BYPASS_LOCK", "MOD_EVI", "MODIFY_AREA", "MOVE_USERS", "MOD_SPEAK", "BAN_INFO", "MOD_CHAT", "MUTE", "LOG"}
+
+// Set is a set of permissions. Sets are never modified; methods that change a set return a new one.
+// The zero value is an empty set.
+type Set struct {
+	perms map[Permission]bool
+}
+
+// NewSet returns a set of the given permissions.
+func NewSet(perms ...Permission) Set {
+	return Set{}.With(perms...)
+}
+
+// FromLegacy returns the set of permissions stored as bits by older versions.
+func FromLegacy(bits uint64) Set {
+	if bits == math.MaxUint64 {
+		return NewSet(Admin)
+	}
+	var perms []Permission
+	for i, p := range legacyBits {
+		if bits&(1<<i) != 0 {
+			perms = append(perms, p)
+		}
+	}
+	return NewSet(perms...)
+}
+
+// Has returns whether the set grants a permission, either directly or through ADMIN.
+func (s Set) Has(p Permission) bool {
+	return p == None || s.perms[p] || s.perms[Admin]
+}
+
+// With returns the set with the given permissions added.
+func (s Set) With(perms ...Permission) Set {
+	m := make(map[Permission]bool, len(s.perms)+len(perms))
+	for p := range s.perms {
+		m[p] = true
+	}
+	for _, p := range perms {
+		if p != None {
+			m[p] = true
+		}
+	}
+	return Set{m}
+}
+
+// Without returns the set with the given permissions removed.
+// Removing a permission other than ADMIN from a set with ADMIN replaces ADMIN with every other registered permission.
+func (s Set) Without(perms ...Permission) Set {
+	m := make(map[Permission]bool, len(s.perms))
+	for p := range s.perms {
+		m[p] = true
+	}
+	if m[Admin] && len(perms) > 0 && !NewSet(perms...).perms[Admin] {
+		for p := range registry {
+			m[p] = true
+		}
+		delete(m, Admin)
+	}
+	for _, p := range perms {
+		delete(m, p)
+	}
+	return Set{m}
+}
+
+// Union returns the permissions in either set.
+func (s Set) Union(o Set) Set {
+	return s.With(o.list()...)
+}
+
+// Equal returns whether two sets hold the same permissions.
+func (s Set) Equal(o Set) bool {
+	if len(s.perms) != len(o.perms) {
+		return false
+	}
+	for p := range s.perms {
+		if !o.perms[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the permissions in the set, in alphabetical order.
+func (s Set) list() []Permission {
+	var l []Permission
+	for p := range s.perms {
+		l = append(l, p)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	return l
+}
+
+// Names returns the names of the permissions in the set, in alphabetical order.
+// A set with ADMIN is shown as just ADMIN.
+func (s Set) Names() []string {
+	if s.perms[Admin] {
+		return []string{string(Admin)}
+	}
+	var names []string
+	for _, p := range s.list() {
+		names = append(names, string(p))
+	}
+	return names
+}
+
+func (s Set) String() string {
+	return strings.Join(s.Names(), ", ")
+}
+
+// GetPermissions returns the permissions for a role. Names that are not registered are ignored.
+func (r *Role) GetPermissions() Set {
+	var perms []Permission
+	for _, name := range r.Permissions {
+		if p, ok := Lookup(name); ok {
+			perms = append(perms, p)
+		}
+	}
+	return NewSet(perms...)
+}
+
+// findRole returns the role with the given name.
+func findRole(roles []Role, name string) (Role, bool) {
+	for _, r := range roles {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Role{}, false
+}
+
+// CheckRoles returns an error if any role is defined twice, names a permission that does not exist,
+// or extends a role that does not exist or extends itself.
+func CheckRoles(roles []Role) error {
+	seen := make(map[string]bool)
+	for _, r := range roles {
+		if seen[r.Name] {
+			return fmt.Errorf("role %v is defined more than once", r.Name)
+		}
+		seen[r.Name] = true
+		for _, name := range r.Permissions {
+			if _, ok := Lookup(name); !ok {
+				return fmt.Errorf("role %v has unknown permission %v", r.Name, name)
+			}
+		}
+	}
+	for _, r := range roles {
+		if _, err := RolePermissions(roles, r.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RolePermissions returns the permissions of a role, including those of the roles it extends.
+func RolePermissions(roles []Role, name string) (Set, error) {
+	var perms Set
+	visited := make(map[string]bool)
+	for name != "" {
+		if visited[name] {
+			return Set{}, fmt.Errorf("role %v extends itself", name)
+		}
+		visited[name] = true
+		r, ok := findRole(roles, name)
+		if !ok {
+			return Set{}, fmt.Errorf("role %v does not exist", name)
+		}
+		perms = perms.Union(r.GetPermissions())
+		name = r.Extends
+	}
+	return perms, nil
+}
+
+// ApplyOverrides returns perms with the granted permissions added and the denied permissions removed.
+// Overrides of permissions that are no longer registered are ignored.
+func ApplyOverrides(perms Set, grants []string, denies []string) Set {
+	for _, name := range grants {
+		if p, ok := Lookup(name); ok {
+			perms = perms.With(p)
+		}
+	}
+	for _, name := range denies {
+		perms = perms.Without(Permission(name))
+	}
+	return perms
 }
@@ -0,0 +1,80 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package permissions
+
+import (
+	"fmt"
+	"testing"
+)
+
+var (
+	kick = Register("KICK", "Kick users.")
+	ban  = Register("BAN", "Ban users.")
+	log  = Register("LOG", "View logs.")
+	mute = Register("MUTE", "Mute users.")
+)
+
+func TestRolePermissions(t *testing.T) {
+	roles := []Role{
+		{Name: "helper", Permissions: []string{"KICK"}},
+		{Name: "moderator", Extends: "helper", Permissions: []string{"BAN"}},
+		{Name: "senior", Extends: "moderator", Permissions: []string{"LOG"}},
+	}
+	perms, err := RolePermissions(roles, "senior")
+	if want := NewSet(kick, ban, log); err != nil || !perms.Equal(want) {
+		t.Errorf("RolePermissions(senior) = %v, %v; want %v", perms, err, want)
+	}
+	if err := CheckRoles(roles); err != nil {
+		t.Errorf("CheckRoles = %v", err)
+	}
+	if got := fmt.Sprint(ApplyOverrides(perms, []string{"MUTE"}, []string{"KICK"}).Names()); got != "[BAN LOG MUTE]" {
+		t.Errorf("overridden permissions are %v", got)
+	}
+
+	for name, bad := range map[string][]Role{
+		"missing parent": {{Name: "a", Extends: "b"}},
+		"cycle":          {{Name: "a", Extends: "b"}, {Name: "b", Extends: "a"}},
+		"duplicate":      {{Name: "a"}, {Name: "a"}},
+		"unknown":        {{Name: "a", Permissions: []string{"KICK", "NOT_A_PERMISSION"}}},
+	} {
+		if err := CheckRoles(bad); err == nil {
+			t.Errorf("%v: CheckRoles accepted invalid roles", name)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	admin := NewSet(Admin)
+	if !admin.Has(kick) || !admin.Has(None) || NewSet(kick).Has(ban) {
+		t.Errorf("Has is wrong")
+	}
+	if s := admin.Without(kick); s.Has(kick) || !s.Has(ban) || s.Has(Admin) {
+		t.Errorf("removing KICK from ADMIN gave %v", s)
+	}
+	if s := admin.Without(Admin); s.Has(kick) {
+		t.Errorf("removing ADMIN gave %v", s)
+	}
+	if got := FromLegacy(1<<1 | 1<<2); !got.Equal(NewSet(kick, ban)) {
+		t.Errorf("FromLegacy = %v", got)
+	}
+	if got := FromLegacy(^uint64(0)); !got.Equal(admin) {
+		t.Errorf("FromLegacy(admin) = %v", got)
+	}
+	if kick.Description() != "Kick users." {
+		t.Errorf("Description = %q", kick.Description())
+	}
+}
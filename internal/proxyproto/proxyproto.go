@@ -0,0 +1,187 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package proxyproto implements the receiving side of the PROXY protocol (versions 1 and 2),
+// which reverse proxies use to pass on the address of the client they are forwarding.
+//
+// The specification can be found here: https://www.haproxy.org/download/2.6/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The signature that begins every version 2 header.
+var v2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// HeaderTimeout is the time a proxy has to send its header after connecting.
+var HeaderTimeout = 5 * time.Second
+
+// ReadHeader reads a PROXY protocol header from r, returning the original source address.
+// A nil address is returned if the header does not carry one, such as for health checks sent by the proxy itself.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(v2Sig))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, v2Sig) {
+		return readV2(r)
+	} else if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readV1(r)
+	}
+	return nil, fmt.Errorf("missing proxy protocol header")
+}
+
+// readV1 reads a human-readable version 1 header.
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 { // The maximum length of a v1 header.
+			return nil, fmt.Errorf("proxy protocol header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid proxy protocol header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid proxy protocol header")
+		}
+		addr, err := netip.ParseAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(fields[4], 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+	}
+	return nil, fmt.Errorf("unsupported proxy protocol family %v", fields[1])
+}
+
+// readV2 reads a binary version 2 header.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version %v", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if hdr[12]&0xF == 0 { // LOCAL command; the connection was made by the proxy itself.
+		return nil, nil
+	}
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, fmt.Errorf("invalid proxy protocol header")
+		}
+		addr := netip.AddrFrom4(*(*[4]byte)(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[8:10]))), nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("invalid proxy protocol header")
+		}
+		addr := netip.AddrFrom16(*(*[16]byte)(body[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[32:34]))), nil
+	}
+	return nil, nil // Unspecified or unix socket addresses carry no usable client address.
+}
+
+// Conn is a connection from a proxy that begins with a PROXY protocol header.
+// The header is read on the first call to Read or RemoteAddr.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// NewConn returns a new Conn reading its header from c.
+func NewConn(c net.Conn) *Conn {
+	return &Conn{Conn: c, r: bufio.NewReader(c)}
+}
+
+// readHeader reads the connection's header, if it hasn't been read yet.
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(HeaderTimeout))
+		c.remote, c.err = ReadHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Read reads data from the connection, following the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address given by the proxy, or the address of the proxy if none was given.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// Listener wraps a listener, expecting PROXY protocol headers from trusted peers.
+// Connections from untrusted peers are returned as is.
+type Listener struct {
+	net.Listener
+	Trusted func(net.Addr) bool
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted != nil && !l.Trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return NewConn(c), nil
+}
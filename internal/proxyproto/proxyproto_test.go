@@ -0,0 +1,99 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2Header builds a version 2 header with the given command, family and address block.
+func v2Header(cmd byte, fam byte, addrs []byte) string {
+	h := append([]byte{}, v2Sig...)
+	h = append(h, 0x20|cmd, fam<<4|1, 0, 0)
+	binary.BigEndian.PutUint16(h[14:], uint16(len(addrs)))
+	return string(append(h, addrs...))
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x69, 0x78}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, []byte{0x20, 0x01, 0x0d, 0xb8})
+	ipv6[15] = 1
+	binary.BigEndian.PutUint16(ipv6[32:], 443)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    string // The expected address, or "" if none.
+		wantErr bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 12345 27016\r\nHI#%", "192.0.2.1:12345", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 443 27016\r\nHI#%", "[2001:db8::1]:443", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\nHI#%", "", false},
+		{"v1 malformed", "PROXY TCP4 192.0.2.1\r\nHI#%", "", true},
+		{"v1 bad address", "PROXY TCP4 not.an.ip 198.51.100.1 1 2\r\nHI#%", "", true},
+		{"v2 ipv4", v2Header(1, 1, ipv4) + "HI#%", "192.0.2.1:12345", false},
+		{"v2 ipv6", v2Header(1, 2, ipv6) + "HI#%", "[2001:db8::1]:443", false},
+		{"v2 local", v2Header(0, 0, nil) + "HI#%", "", false},
+		{"v2 truncated", v2Header(1, 1, ipv4[:6]) + "HI#%", "", true},
+		{"no header", "HI#abcdef#%HI#abcdef#%", "", true},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.input))
+		addr, err := ReadHeader(r)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: unexpected error state, got %v", tt.name, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		var got string
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%v: got address %q, want %q", tt.name, got, tt.want)
+		}
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "HI#%" {
+			t.Errorf("%v: unexpected data after header, got %q", tt.name, rest)
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 27016\r\nHI#abc#%"))
+		client.Close()
+	}()
+	c := NewConn(server)
+	if c.RemoteAddr().String() != "192.0.2.1:12345" {
+		t.Errorf("unexpected remote address, got %v", c.RemoteAddr())
+	}
+	b, _ := io.ReadAll(c)
+	if string(b) != "HI#abc#%" {
+		t.Errorf("unexpected data, got %q", b)
+	}
+}
@@ -0,0 +1,290 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package scripting
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	"github.com/MangosArentLiterature/Athena/internal/sliceutil"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// openAPI adds the athena table, through which scripts interact with the server, to the script's Lua state.
+func (s *Script) openAPI() {
+	L := s.state
+	api := L.NewTable()
+	L.SetFuncs(api, map[string]lua.LGFunction{
+		"command":    s.luaCommand,
+		"on":         s.luaOn,
+		"send":       s.luaSend,
+		"send_area":  s.luaSendArea,
+		"broadcast":  s.luaBroadcast,
+		"move":       s.luaMove,
+		"set_status": s.luaSetStatus,
+		"area":       s.luaArea,
+		"areas":      s.luaAreas,
+		"players":    s.luaPlayers,
+		"log":        s.luaLog,
+	})
+	kv := L.NewTable()
+	L.SetFuncs(kv, map[string]lua.LGFunction{
+		"get":  s.luaGet,
+		"set":  s.luaSet,
+		"keys": s.luaKeys,
+	})
+	api.RawSetString("kv", kv)
+	L.SetGlobal("athena", api)
+	L.SetGlobal("print", L.NewFunction(s.luaLog))
+}
+
+// result returns true to Lua if err is nil, and false and the error's message otherwise.
+func result(L *lua.LState, err error) int {
+	if err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// athena.command(name, description, handler[, permission])
+// Registers a command. The handler is called with the player who used the command and a table of its arguments,
+// and may return a message to send back to them.
+func (s *Script) luaCommand(L *lua.LState) int {
+	name := L.CheckString(1)
+	desc := L.CheckString(2)
+	fn := L.CheckFunction(3)
+	perm := L.OptString(4, "")
+	if !s.loading {
+		L.RaiseError("commands can only be registered when the script is loaded")
+	}
+	if !commandName.MatchString(name) {
+		L.ArgError(1, "command names may only contain lowercase letters")
+	}
+	if perm != "" && !s.engine.host.ValidPermission(perm) {
+		L.ArgError(4, "unknown permission "+perm)
+	}
+	for _, other := range s.engine.loadedScripts() {
+		if other.name == s.name {
+			continue
+		}
+		other.mu.Lock()
+		_, taken := other.commands[name]
+		other.mu.Unlock()
+		if taken {
+			L.ArgError(1, fmt.Sprintf("command %v is already registered by script %v", name, other.name))
+		}
+	}
+	s.commands[name] = Command{Name: name, Desc: desc, Permission: perm, Script: s.name, fn: fn}
+	return 0
+}
+
+// hiddenEvents are the events scripts cannot handle, as they carry login attempts and audit log lines.
+var hiddenEvents = []string{"Login", "Audit"}
+
+// athena.on(event, handler)
+// Calls the handler with a table describing each event of the given type, such as "OOCMessage".
+func (s *Script) luaOn(L *lua.LState) int {
+	name := L.CheckString(1)
+	fn := L.CheckFunction(2)
+	if !sliceutil.ContainsString(events.Names, name) {
+		L.ArgError(1, "unknown event "+name)
+	} else if sliceutil.ContainsString(hiddenEvents, name) {
+		L.ArgError(1, "scripts cannot handle "+name+" events")
+	}
+	s.handlers[name] = append(s.handlers[name], fn)
+	return 0
+}
+
+// athena.send(uid, message)
+func (s *Script) luaSend(L *lua.LState) int {
+	return result(L, s.engine.host.SendMessage(L.CheckInt(1), L.CheckString(2)))
+}
+
+// athena.send_area(area, message)
+func (s *Script) luaSendArea(L *lua.LState) int {
+	return result(L, s.engine.host.SendAreaMessage(L.CheckString(1), L.CheckString(2)))
+}
+
+// athena.broadcast(message)
+func (s *Script) luaBroadcast(L *lua.LState) int {
+	s.engine.host.Broadcast(L.CheckString(1))
+	return 0
+}
+
+// athena.move(uid, area)
+func (s *Script) luaMove(L *lua.LState) int {
+	return result(L, s.engine.host.MoveUser(L.CheckInt(1), L.CheckString(2)))
+}
+
+// athena.set_status(area, status)
+func (s *Script) luaSetStatus(L *lua.LState) int {
+	return result(L, s.engine.host.SetAreaStatus(L.CheckString(1), L.CheckString(2)))
+}
+
+// athena.area(name) returns a table describing the area, or nil and an error message.
+func (s *Script) luaArea(L *lua.LState) int {
+	a, err := s.engine.host.Area(L.CheckString(1))
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(toValue(a))
+	return 1
+}
+
+// athena.areas() returns a list of area names.
+func (s *Script) luaAreas(L *lua.LState) int {
+	L.Push(toValue(s.engine.host.Areas()))
+	return 1
+}
+
+// athena.players() returns a list of tables describing each player.
+func (s *Script) luaPlayers(L *lua.LState) int {
+	L.Push(toValue(s.engine.host.Players()))
+	return 1
+}
+
+// athena.log(...) writes its arguments to the server log. print is the same.
+func (s *Script) luaLog(L *lua.LState) int {
+	parts := make([]string, L.GetTop())
+	for i := range parts {
+		parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	logger.LogInfof("[%v] %v", s.name, strings.Join(parts, " "))
+	return 0
+}
+
+// athena.kv.get(key) returns the stored value, or nil.
+func (s *Script) luaGet(L *lua.LState) int {
+	v, ok := s.kv.get(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(toValue(v))
+	return 1
+}
+
+// athena.kv.set(key, value) stores a string, number or boolean, or removes the key if value is nil.
+func (s *Script) luaSet(L *lua.LState) int {
+	key := L.CheckString(1)
+	var value interface{}
+	switch v := L.Get(2).(type) {
+	case *lua.LNilType:
+	case lua.LString:
+		value = string(v)
+	case lua.LNumber:
+		value = float64(v)
+	case lua.LBool:
+		value = bool(v)
+	default:
+		L.ArgError(2, "only strings, numbers and booleans can be stored")
+	}
+	return result(L, s.kv.set(key, value))
+}
+
+// athena.kv.keys() returns a sorted list of the stored keys.
+func (s *Script) luaKeys(L *lua.LState) int {
+	L.Push(toValue(s.kv.keys()))
+	return 1
+}
+
+// toValue converts a Go value to Lua. Structs become tables keyed by their field names in snake_case, with the
+// fields of embedded structs included directly, and times become Unix timestamps, or 0 for the zero time.
+func toValue(v interface{}) lua.LValue {
+	return reflectValue(reflect.ValueOf(v))
+}
+
+func reflectValue(v reflect.Value) lua.LValue {
+	if !v.IsValid() {
+		return lua.LNil
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return lua.LNumber(0)
+		}
+		return lua.LNumber(t.Unix())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return lua.LString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lua.LNumber(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(v.Float())
+	case reflect.Bool:
+		return lua.LBool(v.Bool())
+	case reflect.Slice, reflect.Array:
+		t := &lua.LTable{}
+		for i := 0; i < v.Len(); i++ {
+			t.Append(reflectValue(v.Index(i)))
+		}
+		return t
+	case reflect.Struct:
+		t := &lua.LTable{}
+		addFields(t, v)
+		return t
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return lua.LNil
+		}
+		return reflectValue(v.Elem())
+	}
+	return lua.LNil
+}
+
+// addFields sets a table's keys to the exported fields of a struct.
+func addFields(t *lua.LTable, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		} else if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			addFields(t, v.Field(i))
+			continue
+		}
+		key := f.Tag.Get("lua")
+		if key == "" {
+			key = snakeCase(f.Name)
+		}
+		t.RawSetString(key, reflectValue(v.Field(i)))
+	}
+}
+
+// snakeCase converts a Go field name, such as OOCName, to snake_case, such as ooc_name.
+func snakeCase(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) && (unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package scripting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Limits on the data a script can store.
+const (
+	maxKeys     = 1000
+	maxKeyLen   = 128
+	maxValueLen = 4096
+)
+
+// A kvStore holds a script's stored data, which is kept in a JSON file so that it outlasts the script being reloaded.
+// It is only used with the script's lock held.
+type kvStore struct {
+	path string
+	data map[string]interface{}
+}
+
+// openKV reads the data stored at path, if any.
+func openKV(path string) (*kvStore, error) {
+	kv := &kvStore{path: path, data: make(map[string]interface{})}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return kv, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &kv.data); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+func (kv *kvStore) get(key string) (interface{}, bool) {
+	v, ok := kv.data[key]
+	return v, ok
+}
+
+// set stores a value, or removes the key if value is nil, and saves the data.
+func (kv *kvStore) set(key string, value interface{}) error {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return fmt.Errorf("keys must be between 1 and %v bytes long", maxKeyLen)
+	}
+	if s, ok := value.(string); ok && len(s) > maxValueLen {
+		return fmt.Errorf("values must be at most %v bytes long", maxValueLen)
+	}
+	old, exists := kv.data[key]
+	if value == nil {
+		if !exists {
+			return nil
+		}
+		delete(kv.data, key)
+	} else {
+		if !exists && len(kv.data) >= maxKeys {
+			return fmt.Errorf("cannot store more than %v keys", maxKeys)
+		}
+		kv.data[key] = value
+	}
+	if err := kv.save(); err != nil {
+		if exists {
+			kv.data[key] = old
+		} else {
+			delete(kv.data, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// keys returns the stored keys, sorted.
+func (kv *kvStore) keys() []string {
+	l := make([]string, 0, len(kv.data))
+	for k := range kv.data {
+		l = append(l, k)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// save writes the data to its file, replacing the old file only once the new one is fully written.
+func (kv *kvStore) save() error {
+	b, err := json.Marshal(kv.data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(kv.path), 0755); err != nil {
+		return err
+	}
+	tmp := kv.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kv.path)
+}
@@ -0,0 +1,233 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package scripting
+
+import (
+	"context"
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/pm"
+)
+
+const (
+	maxStringLen = 1 << 20  // The longest string that string.rep, string.format and string.gsub will build.
+	maxMemory    = 64 << 20 // How far the heap may grow during a call into a script before the call is stopped.
+)
+
+// limitStrings replaces the string functions that can build a string of any length in a single call, which the
+// time limit cannot interrupt, with versions that refuse to build strings longer than maxStringLen.
+func limitStrings(L *lua.LState) {
+	lib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	L.SetField(lib, "rep", L.NewFunction(strRep))
+	L.SetField(lib, "format", L.NewFunction(strFormat))
+	L.SetField(lib, "gsub", L.NewFunction(strGsub))
+}
+
+// string.rep(s, n)
+func strRep(L *lua.LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 || s == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if n > maxStringLen/len(s) {
+		L.RaiseError("resulting string too large")
+	}
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+// string.format(format, ...)
+// Widths and precisions are limited to two digits, as in C Lua.
+func strFormat(L *lua.LState) int {
+	format := L.CheckString(1)
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		digits := 0
+		for i++; i < len(format) && !isLetter(format[i]) && format[i] != '%'; i++ {
+			if format[i] >= '0' && format[i] <= '9' {
+				if digits++; digits > 2 {
+					L.RaiseError("invalid format (width or precision too long)")
+				}
+			} else {
+				digits = 0
+			}
+		}
+	}
+	args := make([]interface{}, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+	npat := strings.Count(format, "%") - 2*strings.Count(format, "%%")
+	if npat < len(args) {
+		args = args[:npat]
+	}
+	L.Push(lua.LString(fmt.Sprintf(format, args...)))
+	return 1
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// string.gsub(s, pattern, repl, n)
+// Unlike gopher-lua's own, this builds the result in one pass, so its time and memory grow with the result's length.
+func strGsub(L *lua.LState) int {
+	s := L.CheckString(1)
+	pattern := L.CheckString(2)
+	L.CheckTypes(3, lua.LTString, lua.LTTable, lua.LTFunction)
+	repl := L.Get(3)
+	limit := L.OptInt(4, -1)
+	matches, err := pm.Find(pattern, []byte(s), 0, limit)
+	if err != nil {
+		L.RaiseError(err.Error())
+	}
+	var b strings.Builder
+	write := func(str string) {
+		if b.Len()+len(str) > maxStringLen {
+			L.RaiseError("resulting string too large")
+		}
+		b.WriteString(str)
+	}
+	last := 0
+	for _, m := range matches {
+		start, end := m.Capture(0), m.Capture(1)
+		write(s[last:start])
+		last = end
+		var value lua.LValue
+		switch repl := repl.(type) {
+		case lua.LString:
+			write(expandRepl(L, s, m, string(repl)))
+			continue
+		case *lua.LTable:
+			value = L.GetTable(repl, capture(L, s, m, 1))
+		case *lua.LFunction:
+			L.Push(repl)
+			n := 1
+			if m.CaptureLength() > 2 {
+				n = m.CaptureLength()/2 - 1
+			}
+			for i := 1; i <= n; i++ {
+				L.Push(capture(L, s, m, i))
+			}
+			L.Call(n, 1)
+			value = L.Get(-1)
+			L.Pop(1)
+		}
+		if lua.LVIsFalse(value) {
+			write(s[start:end])
+		} else {
+			write(lua.LVAsString(value))
+		}
+	}
+	write(s[last:])
+	L.Push(lua.LString(b.String()))
+	L.Push(lua.LNumber(len(matches)))
+	return 2
+}
+
+// capture returns the i-th capture of a match, or the whole match for the first capture of a pattern without any.
+func capture(L *lua.LState, s string, m *pm.MatchData, i int) lua.LValue {
+	idx := 2 * i
+	if i == 0 || i == 1 && m.CaptureLength() == 2 {
+		idx = 0
+	} else if idx >= m.CaptureLength() {
+		L.RaiseError("invalid capture index")
+	}
+	if m.IsPosCapture(idx) {
+		return lua.LNumber(m.Capture(idx))
+	}
+	return lua.LString(s[m.Capture(idx):m.Capture(idx+1)])
+}
+
+// expandRepl returns a gsub replacement string with its captures, such as %1, filled in.
+func expandRepl(L *lua.LState, s string, m *pm.MatchData, repl string) string {
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c != '%' || i == len(repl)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = repl[i]; {
+		case c == '%':
+			b.WriteByte('%')
+		case c >= '0' && c <= '9':
+			str := lua.LVAsString(capture(L, s, m, int(c-'0')))
+			if b.Len()+len(str) > maxStringLen {
+				L.RaiseError("resulting string too large")
+			}
+			b.WriteString(str)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// A memoryWatch stops a call into a script once the heap has grown by more than maxMemory since the call began.
+// Allocations are not attributed to goroutines, so growth from elsewhere in the server counts towards the limit;
+// maxMemory is set well above what the rest of the server allocates in the length of a call.
+type memoryWatch struct {
+	done     chan struct{}
+	exceeded atomic.Bool
+}
+
+// watchMemory starts watching the heap, calling cancel if it grows too far.
+func watchMemory(cancel context.CancelFunc) *memoryWatch {
+	w := &memoryWatch{done: make(chan struct{})}
+	start := heapBytes()
+	go func() {
+		t := time.NewTicker(5 * time.Millisecond)
+		defer t.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-t.C:
+				if heapBytes() > start+maxMemory {
+					w.exceeded.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+// stop stops watching the heap.
+func (w *memoryWatch) stop() {
+	close(w.done)
+}
+
+// heapBytes returns the memory occupied by heap objects, including those not yet freed.
+func heapBytes() uint64 {
+	s := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(s)
+	return s[0].Value.Uint64()
+}
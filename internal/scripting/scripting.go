@@ -0,0 +1,369 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+// Package scripting runs Lua scripts that extend the server with commands and event handlers.
+//
+// Scripts run in a sandbox: they cannot read files, run programs or load other code, and can only affect the
+// server through the functions of the athena table. Each call into a script is stopped if it runs for longer
+// than the engine's time limit, or if the heap grows by more than 64 MiB while it runs.
+package scripting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+	"github.com/MangosArentLiterature/Athena/internal/logger"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// A Host is the server scripts run in. Scripts can only affect the server through it.
+type Host interface {
+	// SendMessage sends a server message to the client with the given UID.
+	SendMessage(uid int, message string) error
+	// SendAreaMessage sends a server message to every client in an area.
+	SendAreaMessage(area string, message string) error
+	// Broadcast sends a server message to every client.
+	Broadcast(message string)
+	// MoveUser moves the client with the given UID to an area.
+	MoveUser(uid int, area string) error
+	// SetAreaStatus sets the status of an area, such as "casing".
+	SetAreaStatus(area string, status string) error
+	// Area returns the current state of an area.
+	Area(name string) (AreaState, error)
+	// Areas returns the names of the server's areas, in order.
+	Areas() []string
+	// Players returns the clients that have joined the server.
+	Players() []events.Player
+	// ValidPermission returns whether a command may require the named permission.
+	ValidPermission(name string) bool
+}
+
+// An AreaState describes an area to scripts.
+type AreaState struct {
+	Name       string
+	Status     string
+	Lock       string
+	Background string
+	Players    int
+	CMs        []int `lua:"cms"`
+	Doc        string
+}
+
+// A Command is a chat command registered by a script.
+type Command struct {
+	Name       string
+	Desc       string
+	Permission string // The permission needed to use the command, or "" if anyone may.
+	Script     string // The name of the script that registered the command.
+	fn         *lua.LFunction
+}
+
+// An Engine loads scripts from a directory, and runs their commands and event handlers.
+type Engine struct {
+	dir     string
+	host    Host
+	bus     *events.Bus
+	timeout time.Duration
+	loadMu  sync.Mutex // Held while a script loads, so that two scripts cannot register the same command at once.
+	mu      sync.Mutex
+	scripts map[string]*Script
+}
+
+// A Script is a loaded Lua script.
+type Script struct {
+	name     string
+	engine   *Engine
+	mu       sync.Mutex // Guards the script's Lua state, which is not safe for concurrent use, and the fields below.
+	state    *lua.LState
+	handlers map[string][]*lua.LFunction // Event handlers, keyed by event name.
+	commands map[string]Command
+	kv       *kvStore
+	sub      *events.Subscription
+	loading  bool // Commands can only be registered while the script loads.
+	closed   bool
+}
+
+// errUnloaded is returned when calling into a script that has been unloaded.
+var errUnloaded = errors.New("script is not loaded")
+
+var (
+	scriptName  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	commandName = regexp.MustCompile(`^[a-z]+$`) // Commands typed in OOC can only contain lowercase letters.
+)
+
+// NewEngine returns an engine that loads scripts from dir, and stops calls into scripts that take longer than timeout.
+func NewEngine(dir string, host Host, bus *events.Bus, timeout time.Duration) *Engine {
+	return &Engine{
+		dir:     dir,
+		host:    host,
+		bus:     bus,
+		timeout: timeout,
+		scripts: make(map[string]*Script),
+	}
+}
+
+// LoadAll loads every script in the engine's directory, returning an error for each script that fails to load.
+func (e *Engine) LoadAll() []error {
+	files, err := filepath.Glob(filepath.Join(e.dir, "*.lua"))
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, f := range files {
+		if err := e.Load(strings.TrimSuffix(filepath.Base(f), ".lua")); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Load loads the script with the given name from the engine's directory. If the script is already loaded,
+// it is replaced once the new version has loaded successfully.
+func (e *Engine) Load(name string) error {
+	if !scriptName.MatchString(name) {
+		return fmt.Errorf("invalid script name %q", name)
+	}
+	e.loadMu.Lock()
+	defer e.loadMu.Unlock()
+	src, err := os.ReadFile(filepath.Join(e.dir, name+".lua"))
+	if err != nil {
+		return err
+	}
+	kv, err := openKV(filepath.Join(e.dir, "data", name+".json"))
+	if err != nil {
+		return fmt.Errorf("%v: failed to read stored data: %v", name, err)
+	}
+	s := &Script{
+		name:     name,
+		engine:   e,
+		state:    newState(),
+		handlers: make(map[string][]*lua.LFunction),
+		commands: make(map[string]Command),
+		kv:       kv,
+		loading:  true,
+	}
+	s.openAPI()
+	fn, err := s.state.LoadString(string(src))
+	if err == nil {
+		_, err = s.call(fn)
+	}
+	s.loading = false
+	if err != nil {
+		s.close()
+		return fmt.Errorf("%v: %v", name, err)
+	}
+
+	s.sub = e.bus.SubscribeAll("script "+name, s.handleEvent)
+	e.mu.Lock()
+	old := e.scripts[name]
+	e.scripts[name] = s
+	e.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	logger.LogInfof("Loaded script %v.", name)
+	return nil
+}
+
+// Unload stops and removes a loaded script.
+func (e *Engine) Unload(name string) error {
+	e.mu.Lock()
+	s, ok := e.scripts[name]
+	delete(e.scripts, name)
+	e.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("script %v is not loaded", name)
+	}
+	s.close()
+	logger.LogInfof("Unloaded script %v.", name)
+	return nil
+}
+
+// Close unloads every script.
+func (e *Engine) Close() {
+	for _, name := range e.Loaded() {
+		e.Unload(name)
+	}
+}
+
+// Loaded returns the names of the loaded scripts, sorted.
+func (e *Engine) Loaded() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	l := make([]string, 0, len(e.scripts))
+	for name := range e.scripts {
+		l = append(l, name)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// Command returns the command with the given name, if a loaded script has registered it.
+func (e *Engine) Command(name string) (Command, bool) {
+	for _, s := range e.loadedScripts() {
+		s.mu.Lock()
+		cmd, ok := s.commands[name]
+		s.mu.Unlock()
+		if ok {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// Commands returns every command registered by loaded scripts.
+func (e *Engine) Commands() []Command {
+	var l []Command
+	for _, s := range e.loadedScripts() {
+		s.mu.Lock()
+		for _, cmd := range s.commands {
+			l = append(l, cmd)
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+// RunCommand runs a script's command for a player, returning the handler's reply, if any.
+func (e *Engine) RunCommand(name string, p events.Player, args []string) (string, error) {
+	cmd, ok := e.Command(name)
+	if !ok {
+		return "", fmt.Errorf("no script has a command named %v", name)
+	}
+	e.mu.Lock()
+	s := e.scripts[cmd.Script]
+	e.mu.Unlock()
+	if s == nil {
+		return "", errUnloaded
+	}
+	argTable := &lua.LTable{}
+	for _, a := range args {
+		argTable.Append(lua.LString(a))
+	}
+	ret, err := s.call(cmd.fn, toValue(p), argTable)
+	if err != nil {
+		return "", err
+	}
+	if ret == lua.LNil {
+		return "", nil
+	}
+	return ret.String(), nil
+}
+
+// loadedScripts returns the loaded scripts, sorted by name.
+func (e *Engine) loadedScripts() []*Script {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	l := make([]*Script, 0, len(e.scripts))
+	for _, s := range e.scripts {
+		l = append(l, s)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].name < l[j].name })
+	return l
+}
+
+// newState returns a Lua state with only the libraries that are safe for scripts.
+func newState() *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   256,
+		RegistrySize:    1024,
+		RegistryMaxSize: 64 * 1024,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Remove the base functions that can load code from files or strings.
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	limitStrings(L)
+	return L
+}
+
+// call runs a Lua function under the engine's time limit and the memory limit, returning its first result.
+func (s *Script) call(fn *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return lua.LNil, errUnloaded
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.engine.timeout)
+	defer cancel()
+	mem := watchMemory(cancel)
+	defer mem.stop()
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	err := s.state.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...)
+	if mem.exceeded.Load() {
+		return lua.LNil, fmt.Errorf("exceeded the memory limit of %v MiB", maxMemory>>20)
+	} else if ctx.Err() != nil {
+		return lua.LNil, fmt.Errorf("exceeded the time limit of %v", s.engine.timeout)
+	} else if err != nil {
+		return lua.LNil, err
+	}
+	ret := s.state.Get(-1)
+	s.state.Pop(1)
+	return ret, nil
+}
+
+// handleEvent runs the script's handlers for an event.
+func (s *Script) handleEvent(e events.Event) {
+	s.mu.Lock()
+	handlers := s.handlers[e.Name()]
+	s.mu.Unlock()
+	for _, fn := range handlers {
+		_, err := s.call(fn, toValue(e))
+		if errors.Is(err, errUnloaded) {
+			return
+		} else if err != nil {
+			logger.LogErrorf("Script %v failed to handle %v: %v", s.name, e.Name(), err)
+		}
+	}
+}
+
+// close stops the script from receiving events, and releases its Lua state.
+func (s *Script) close() {
+	if s.sub != nil {
+		s.sub.Unsubscribe()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.state.Close()
+	}
+}
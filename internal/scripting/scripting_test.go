@@ -0,0 +1,348 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package scripting
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MangosArentLiterature/Athena/internal/events"
+)
+
+// testHost records what scripts do to the server.
+type testHost struct {
+	mu       sync.Mutex
+	messages []string
+	moves    map[int]string
+	status   map[string]string
+}
+
+func (h *testHost) SendMessage(uid int, message string) error {
+	if uid != 1 {
+		return fmt.Errorf("no client with UID %v", uid)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, fmt.Sprintf("%v: %v", uid, message))
+	return nil
+}
+
+func (h *testHost) SendAreaMessage(area string, message string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, fmt.Sprintf("%v: %v", area, message))
+	return nil
+}
+
+func (h *testHost) Broadcast(message string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, "all: "+message)
+}
+
+func (h *testHost) MoveUser(uid int, area string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.moves[uid] = area
+	return nil
+}
+
+func (h *testHost) SetAreaStatus(area string, status string) error {
+	if status != "casing" && status != "idle" {
+		return fmt.Errorf("invalid status %v", status)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status[area] = status
+	return nil
+}
+
+func (h *testHost) Area(name string) (AreaState, error) {
+	if name != "Courtroom" {
+		return AreaState{}, fmt.Errorf("no area named %v", name)
+	}
+	return AreaState{Name: "Courtroom", Status: "IDLE", Players: 2, CMs: []int{1}}, nil
+}
+
+func (h *testHost) Areas() []string { return []string{"Lobby", "Courtroom"} }
+
+func (h *testHost) Players() []events.Player {
+	return []events.Player{{UID: 1, OOCName: "alice", Area: "Lobby"}}
+}
+
+func (h *testHost) ValidPermission(name string) bool { return name == "KICK" }
+
+func (h *testHost) got() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.messages...)
+}
+
+func newTestEngine(t *testing.T, scripts map[string]string) (*Engine, *testHost, *events.Bus) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name+".lua"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &testHost{moves: make(map[int]string), status: make(map[string]string)}
+	b := events.New()
+	e := NewEngine(dir, h, b, 200*time.Millisecond)
+	t.Cleanup(func() {
+		e.Close()
+		b.Close()
+	})
+	return e, h, b
+}
+
+func TestCommands(t *testing.T) {
+	e, h, _ := newTestEngine(t, map[string]string{
+		"greet": `
+athena.command("greet", "Greets you.", function(player, args)
+	return "Hello, " .. player.ooc_name .. "! You said " .. table.concat(args, " ")
+end)
+athena.command("casing", "Starts a case.", function(player, args)
+	local ok, err = athena.set_status(args[1], "casing")
+	if not ok then return err end
+	athena.move(player.uid, args[1])
+	athena.send(player.uid, "Moved.")
+	local a = athena.area(args[1])
+	return a.name .. " " .. a.players .. " " .. a.cms[1]
+end, "KICK")`,
+		"bad": `athena.command("Bad", "Invalid name.", function() end)`,
+	})
+	errs := e.LoadAll()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "lowercase") {
+		t.Errorf("LoadAll returned %v, want an error for the invalid command name", errs)
+	}
+	if want := []string{"greet"}; !reflect.DeepEqual(e.Loaded(), want) {
+		t.Errorf("Loaded() = %q, want %q", e.Loaded(), want)
+	}
+	cmd, ok := e.Command("casing")
+	if !ok || cmd.Permission != "KICK" || cmd.Script != "greet" || cmd.Desc != "Starts a case." {
+		t.Errorf("Command(casing) = %+v, %v", cmd, ok)
+	}
+
+	p := events.Player{UID: 1, OOCName: "alice"}
+	reply, err := e.RunCommand("greet", p, []string{"hi", "there"})
+	if err != nil || reply != "Hello, alice! You said hi there" {
+		t.Errorf("greet replied %q, %v", reply, err)
+	}
+	reply, err = e.RunCommand("casing", p, []string{"Courtroom"})
+	if err != nil || reply != "Courtroom 2 1" {
+		t.Errorf("casing replied %q, %v", reply, err)
+	}
+	if h.status["Courtroom"] != "casing" || h.moves[1] != "Courtroom" {
+		t.Errorf("casing set status %v and moved %v", h.status, h.moves)
+	}
+	if want := []string{"1: Moved."}; !reflect.DeepEqual(h.got(), want) {
+		t.Errorf("messages = %q, want %q", h.got(), want)
+	}
+	if _, err := e.RunCommand("missing", p, nil); err == nil {
+		t.Error("running an unknown command succeeded")
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	e, _, _ := newTestEngine(t, map[string]string{
+		"a":     `athena.command("roll", "", function() end)`,
+		"b":     `athena.command("roll", "", function() end)`,
+		"perm":  `athena.command("perm", "", function() end, "NOT_A_PERMISSION")`,
+		"late":  `athena.command("late", "", function() athena.command("later", "", function() end) end)`,
+		"login": `athena.on("Login", function() end)`,
+		"audit": `athena.on("Audit", function() end)`,
+	})
+	for _, name := range []string{"a", "b", "perm", "late", "login", "audit"} {
+		err := e.Load(name)
+		if wantErr := name != "a" && name != "late"; (err != nil) != wantErr {
+			t.Errorf("loading %v returned %v", name, err)
+		}
+	}
+	if _, err := e.RunCommand("late", events.Player{}, nil); err == nil {
+		t.Error("registering a command after loading succeeded")
+	}
+	// Reloading a script may register the same commands again.
+	if err := e.Load("a"); err != nil {
+		t.Errorf("reloading a returned %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	e, h, b := newTestEngine(t, map[string]string{
+		"echo": `
+athena.on("OOCMessage", function(e)
+	athena.send_area(e.area, e.ooc_name .. " said " .. e.message)
+end)
+athena.on("Mute", function(e)
+	athena.broadcast("Mute " .. e.target.uid .. " until " .. e["until"])
+	error("handler failed")
+end)`,
+	})
+	if err := e.Load("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Load("unknown"); err == nil {
+		t.Error("loading a missing script succeeded")
+	}
+	b.Publish(events.OOCMessage{Player: events.Player{OOCName: "bob", Area: "Lobby"}, Message: "hi"})
+	b.Publish(events.Mute{Target: events.Player{UID: 3}, Until: time.Unix(100, 0)})
+	b.Publish(events.Mute{Target: events.Player{UID: 4}})
+	waitFor(t, func() bool { return len(h.got()) == 3 })
+	want := []string{"Lobby: bob said hi", "all: Mute 3 until 100", "all: Mute 4 until 0"}
+	if !reflect.DeepEqual(h.got(), want) {
+		t.Errorf("messages = %q, want %q", h.got(), want)
+	}
+
+	if err := e.Unload("echo"); err != nil {
+		t.Fatal(err)
+	}
+	b.Publish(events.OOCMessage{Message: "after unload"})
+	time.Sleep(50 * time.Millisecond)
+	if len(h.got()) != 3 {
+		t.Errorf("unloaded script still handled events: %q", h.got())
+	}
+	if err := e.Unload("echo"); err == nil {
+		t.Error("unloading a script twice succeeded")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	e, _, _ := newTestEngine(t, map[string]string{
+		"loop":    `athena.command("loop", "", function() while true do end end)`,
+		"sandbox": `athena.command("sandbox", "", function() return tostring(io) .. tostring(os) .. tostring(load) .. tostring(require) end)`,
+		"hang":    `while true do end`,
+		"rep":     `athena.command("rep", "", function() return string.rep("x", 2^31) end)`,
+		"format":  `athena.command("format", "", function() return string.format("%999999999d", 1) end)`,
+		"gsub":    `athena.command("gsub", "", function() return (string.gsub(string.rep("x", 1025), "x", string.rep("y", 1024))) end)`,
+		"strings": `
+athena.command("strings", "", function()
+	local a = string.gsub("hello world", "(o)", "[%1]")
+	local b = string.gsub("hello world", "%w+", {hello = "bye"})
+	local c, n = string.gsub("hello world", "(l+)(o?)", function(l, o) return #l .. o end)
+	return a .. " " .. b .. " " .. c .. " " .. n .. " " .. string.format("%5.2f%%", 1.5) .. string.rep("ab", 2)
+end)`,
+	})
+	if err := e.Load("hang"); err == nil || !strings.Contains(err.Error(), "time limit") {
+		t.Errorf("loading a script that never returns returned %v", err)
+	}
+	e.Load("loop")
+	e.Load("sandbox")
+	start := time.Now()
+	if _, err := e.RunCommand("loop", events.Player{}, nil); err == nil || !strings.Contains(err.Error(), "time limit") {
+		t.Errorf("an endless command returned %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("an endless command ran for %v", d)
+	}
+	reply, err := e.RunCommand("sandbox", events.Player{}, nil)
+	if err != nil || reply != "nilnilnilnil" {
+		t.Errorf("sandbox replied %q, %v", reply, err)
+	}
+	for _, name := range []string{"rep", "format", "gsub", "strings"} {
+		e.Load(name)
+	}
+	for _, name := range []string{"rep", "format", "gsub"} {
+		if _, err := e.RunCommand(name, events.Player{}, nil); err == nil || !strings.Contains(err.Error(), "too") {
+			t.Errorf("building a huge string with %v returned %v", name, err)
+		}
+	}
+	reply, err = e.RunCommand("strings", events.Player{}, nil)
+	if want := "hell[o] w[o]rld bye world he2o wor1d 2  1.50%abab"; err != nil || reply != want {
+		t.Errorf("strings replied %q, %v, want %q", reply, err, want)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	e, _, _ := newTestEngine(t, map[string]string{
+		"grow": `athena.command("grow", "", function() local t = {} for i = 1, 1e9 do t[i] = string.rep("x", 2^20) end end)`,
+	})
+	// Leave time for the table to outgrow the memory limit, which takes longer under the race detector.
+	e.timeout = 10 * time.Second
+	e.Load("grow")
+	if _, err := e.RunCommand("grow", events.Player{}, nil); err == nil || !strings.Contains(err.Error(), "memory limit") {
+		t.Errorf("an ever-growing table returned %v", err)
+	}
+}
+
+func TestKV(t *testing.T) {
+	src := `
+athena.command("count", "", function()
+	local n = (athena.kv.get("count") or 0) + 1
+	athena.kv.set("count", n)
+	return tostring(n)
+end)
+athena.command("clear", "", function()
+	athena.kv.set("count", nil)
+	local ok, err = athena.kv.set("", "x")
+	return tostring(ok) .. " " .. #athena.kv.keys()
+end)`
+	e, _, _ := newTestEngine(t, map[string]string{"counter": src})
+	if err := e.Load("counter"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "2"} {
+		if got, err := e.RunCommand("count", events.Player{}, nil); got != want || err != nil {
+			t.Errorf("count replied %q, %v, want %q", got, err, want)
+		}
+	}
+	// Stored data survives the script being reloaded.
+	if err := e.Load("counter"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := e.RunCommand("count", events.Player{}, nil); got != "3" {
+		t.Errorf("count replied %q after reloading, want 3", got)
+	}
+	if got, _ := e.RunCommand("clear", events.Player{}, nil); got != "false 0" {
+		t.Errorf("clear replied %q", got)
+	}
+	if got, _ := e.RunCommand("count", events.Player{}, nil); got != "1" {
+		t.Errorf("count replied %q after clearing, want 1", got)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"UID":        "uid",
+		"IPID":       "ipid",
+		"OOCName":    "ooc_name",
+		"Character":  "character",
+		"Background": "background",
+		"HDID":       "hdid",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
@@ -19,8 +19,14 @@ package settings
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -31,29 +37,55 @@ import (
 // Stores the path to the config directory
 var ConfigPath string
 
+// EnvPrefix is the prefix of environment variables that override config.toml values.
+const EnvPrefix = "ATHENA_"
+
+// flagOverrides stores config values set on the command line, keyed by flag name.
+var flagOverrides = map[string]string{}
+
 type Config struct {
-	ServerConfig `toml:"Server"`
-	LogConfig    `toml:"Logging"`
-	MSConfig     `toml:"MasterServer"`
+	ServerConfig   `toml:"Server"`
+	LogConfig      `toml:"Logging"`
+	MSConfig       `toml:"MasterServer"`
+	BackupConfig   `toml:"Backup"`
+	ApprovalConfig `toml:"BanApproval"`
+	LoginConfig    `toml:"Login"`
+	FloodConfig    `toml:"Flood"`
+	SSHConfig      `toml:"SSH"`
+	ScriptConfig   `toml:"Scripts"`
 }
 
 type ServerConfig struct {
-	Addr         string `toml:"addr"`
-	Port         int    `toml:"port"`
-	Name         string `toml:"name"`
-	Desc         string `toml:"description"`
-	MaxPlayers   int    `toml:"max_players"`
-	MaxMsg       int    `toml:"max_message_length"`
-	BanLen       string `toml:"default_ban_duration"`
-	EnableWS     bool   `toml:"enable_webao"`
-	WSPort       int    `toml:"webao_port"`
-	MCLimit      int    `toml:"multiclient_limit"`
-	AssetURL     string `toml:"asset_url"`
-	WebhookURL   string `toml:"webhook_url"`
-	MaxDice      int    `toml:"max_dice"`
-	MaxSide      int    `toml:"max_sides"`
-	Motd         string `toml:"motd"`
-	MaxStatement int    `toml:"max_testimony"`
+	Addr          string   `toml:"addr"`
+	Port          int      `toml:"port"`
+	Name          string   `toml:"name"`
+	Desc          string   `toml:"description"`
+	MaxPlayers    int      `toml:"max_players"`
+	MaxMsg        int      `toml:"max_message_length"`
+	BanLen        string   `toml:"default_ban_duration"`
+	EnableWS      bool     `toml:"enable_webao"`
+	WSPort        int      `toml:"webao_port"`
+	EnableWSS     bool     `toml:"enable_secure_webao"`
+	WSSPort       int      `toml:"secure_webao_port"`
+	WSOrigins     []string `toml:"webao_origins"`
+	TCPTLS        bool     `toml:"tcp_tls"`
+	TLSCert       string   `toml:"tls_cert"`
+	TLSKey        string   `toml:"tls_key"`
+	ProxyProto    bool     `toml:"proxy_protocol"`
+	Proxies       []string `toml:"trusted_proxies"`
+	IPv6Prefix    int      `toml:"ipv6_prefix_length"`
+	LegacyLookup  bool     `toml:"legacy_ban_lookup"`
+	MCLimit       int      `toml:"multiclient_limit"`
+	AssetURL      string   `toml:"asset_url"`
+	WebhookURL    string   `toml:"webhook_url"`
+	WebhookFile   string   `toml:"webhook_url_file"`
+	MaxDice       int      `toml:"max_dice"`
+	MaxSide       int      `toml:"max_sides"`
+	Motd          string   `toml:"motd"`
+	MaxStatement  int      `toml:"max_testimony"`
+	ShutdownLen   string   `toml:"shutdown_delay"`
+	ShutdownMsg   string   `toml:"shutdown_message"`
+	MassThreshold int      `toml:"mass_action_threshold"`
 }
 
 type LogConfig struct {
@@ -68,23 +100,70 @@ type MSConfig struct {
 	MSAddr    string `toml:"addr"`
 }
 
+type BackupConfig struct {
+	EnableBackup   bool   `toml:"enable"`
+	BackupInterval string `toml:"interval"`
+	BackupDir      string `toml:"directory"`
+	KeepDaily      int    `toml:"keep_daily"`
+	KeepWeekly     int    `toml:"keep_weekly"`
+}
+
+type ApprovalConfig struct {
+	EnableApproval    bool   `toml:"enable"`
+	ApprovalThreshold string `toml:"threshold"`
+	ApprovalTimeout   string `toml:"timeout"`
+}
+
+type LoginConfig struct {
+	LoginAttempts int    `toml:"max_attempts"`
+	LockoutLen    string `toml:"lockout"`
+	MaxLockout    string `toml:"max_lockout"`
+}
+
+type FloodConfig struct {
+	FloodViolations int    `toml:"violations"`
+	FloodLockout    string `toml:"lockout"`
+	FloodMaxLockout string `toml:"max_lockout"`
+}
+
+type SSHConfig struct {
+	EnableSSH      bool   `toml:"enable"`
+	SSHAddr        string `toml:"addr"`
+	SSHPort        int    `toml:"port"`
+	HostKey        string `toml:"host_key"`
+	AuthorizedKeys string `toml:"authorized_keys"`
+}
+
+type ScriptConfig struct {
+	EnableScripts bool   `toml:"enable"`
+	ScriptDir     string `toml:"directory"`
+	ScriptTimeout string `toml:"timeout"`
+}
+
 // Returns a default configuration.
 func defaultConfig() *Config {
 	return &Config{
 		ServerConfig{
-			Addr:         "",
-			Port:         27016,
-			Name:         "Unnamed Server",
-			Desc:         "",
-			MaxPlayers:   100,
-			MaxMsg:       256,
-			BanLen:       "3d",
-			EnableWS:     false,
-			WSPort:       27017,
-			MCLimit:      16,
-			MaxDice:      100,
-			MaxSide:      100,
-			MaxStatement: 10,
+			Addr:          "",
+			Port:          27016,
+			Name:          "Unnamed Server",
+			Desc:          "",
+			MaxPlayers:    100,
+			MaxMsg:        256,
+			BanLen:        "3d",
+			EnableWS:      false,
+			WSPort:        27017,
+			WSSPort:       27018,
+			WSOrigins:     []string{"web.aceattorneyonline.com"},
+			MCLimit:       16,
+			IPv6Prefix:    64,
+			LegacyLookup:  true,
+			MaxDice:       100,
+			MaxSide:       100,
+			MaxStatement:  10,
+			ShutdownLen:   "30s",
+			ShutdownMsg:   "The server is shutting down.",
+			MassThreshold: 5,
 		},
 		LogConfig{
 			BufSize:    150,
@@ -96,6 +175,40 @@ func defaultConfig() *Config {
 			Advertise: false,
 			MSAddr:    "https://servers.aceattorneyonline.com/servers",
 		},
+		BackupConfig{
+			EnableBackup:   false,
+			BackupInterval: "1d",
+			BackupDir:      "backups",
+			KeepDaily:      7,
+			KeepWeekly:     4,
+		},
+		ApprovalConfig{
+			EnableApproval:    false,
+			ApprovalThreshold: "7d",
+			ApprovalTimeout:   "1h",
+		},
+		LoginConfig{
+			LoginAttempts: 5,
+			LockoutLen:    "1m",
+			MaxLockout:    "1h",
+		},
+		FloodConfig{
+			FloodViolations: 5,
+			FloodLockout:    "30s",
+			FloodMaxLockout: "10m",
+		},
+		SSHConfig{
+			EnableSSH:      false,
+			SSHAddr:        "127.0.0.1",
+			SSHPort:        2222,
+			HostKey:        "",
+			AuthorizedKeys: "",
+		},
+		ScriptConfig{
+			EnableScripts: false,
+			ScriptDir:     "scripts",
+			ScriptTimeout: "100ms",
+		},
 	}
 }
 
@@ -109,6 +222,8 @@ func (conf *Config) Load() error {
 }
 
 // GetConfig returns the server's config options.
+// Values are applied in order of precedence, from lowest to highest:
+// built-in defaults, config.toml, ATHENA_* environment variables, and command-line flags.
 func GetConfig() (*Config, error) {
 	conf := defaultConfig()
 	err := conf.Load()
@@ -116,10 +231,178 @@ func GetConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = conf.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = conf.applyFlags()
+	if err != nil {
+		return nil, err
+	}
+	if conf.WebhookFile != "" {
+		conf.WebhookURL, err = readSecret(conf.WebhookFile)
+		if err != nil {
+			return nil, fmt.Errorf("webhook_url_file: %v", err)
+		}
+	}
 
 	return conf, nil
 }
 
+// configField is a single overridable config value.
+type configField struct {
+	section string // The name of the field's TOML table.
+	key     string // The field's TOML key.
+	value   reflect.Value
+}
+
+// envName returns the name of the environment variable that overrides the field.
+func (f configField) envName() string {
+	return EnvPrefix + strings.ToUpper(f.section+"_"+f.key)
+}
+
+// flagName returns the name of the command-line flag that overrides the field.
+func (f configField) flagName() string {
+	return strings.ToLower(f.section) + "." + f.key
+}
+
+// set parses s according to the field's type, and sets the field's value.
+func (f configField) set(s string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case reflect.Slice:
+		var l []string
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				l = append(l, v)
+			}
+		}
+		f.value.Set(reflect.ValueOf(l))
+	default:
+		return fmt.Errorf("unsupported type %v", f.value.Type())
+	}
+	return nil
+}
+
+// fields returns every overridable value in the config.
+func (conf *Config) fields() []configField {
+	var l []configField
+	c := reflect.ValueOf(conf).Elem()
+	for i := 0; i < c.NumField(); i++ {
+		section := c.Type().Field(i).Tag.Get("toml")
+		s := c.Field(i)
+		if s.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < s.NumField(); j++ {
+			key := s.Type().Field(j).Tag.Get("toml")
+			if key == "" {
+				continue
+			}
+			l = append(l, configField{section: section, key: key, value: s.Field(j)})
+		}
+	}
+	return l
+}
+
+// applyEnv overrides config values with those set in ATHENA_* environment variables.
+// For any value, a variable suffixed with _FILE may be set instead to read the value from a file, unless that
+// name belongs to another value: ATHENA_SERVER_WEBHOOK_URL_FILE sets webhook_url_file, not webhook_url.
+func (conf *Config) applyEnv() error {
+	fields := conf.fields()
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f.envName()] = true
+	}
+	for _, f := range fields {
+		v, ok := os.LookupEnv(f.envName())
+		var path string
+		var fromFile bool
+		if !names[f.envName()+"_FILE"] {
+			path, fromFile = os.LookupEnv(f.envName() + "_FILE")
+		}
+		if ok && fromFile {
+			return fmt.Errorf("both %v and %v_FILE are set", f.envName(), f.envName())
+		} else if fromFile {
+			var err error
+			v, err = readSecret(path)
+			if err != nil {
+				return fmt.Errorf("%v_FILE: %v", f.envName(), err)
+			}
+		} else if !ok {
+			continue
+		}
+		if err := f.set(v); err != nil {
+			return fmt.Errorf("invalid value for %v: %v", f.envName(), err)
+		}
+	}
+	return nil
+}
+
+// applyFlags overrides config values with those set on the command line.
+func (conf *Config) applyFlags() error {
+	for _, f := range conf.fields() {
+		v, ok := flagOverrides[f.flagName()]
+		if !ok {
+			continue
+		}
+		if err := f.set(v); err != nil {
+			return fmt.Errorf("invalid value for -%v: %v", f.flagName(), err)
+		}
+	}
+	return nil
+}
+
+// RegisterFlags defines a command-line flag on fs for every config value.
+// Flags are named after the value's table and key, such as -server.port.
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, f := range defaultConfig().fields() {
+		name := f.flagName()
+		fs.Func(name, fmt.Sprintf("overrides %v in [%v]", f.key, f.section), func(s string) error {
+			flagOverrides[name] = s
+			return nil
+		})
+	}
+}
+
+// readSecret returns the contents of a file, without surrounding whitespace.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Redacted returns a copy of the config with secret values hidden, suitable for display.
+func (conf *Config) Redacted() *Config {
+	c := *conf
+	for _, v := range []*string{&c.WebhookURL, &c.WebhookFile, &c.TLSCert, &c.TLSKey, &c.HostKey, &c.AuthorizedKeys} {
+		if *v != "" {
+			*v = "<redacted>"
+		}
+	}
+	return &c
+}
+
+// Encode writes the config to w in TOML format.
+func (conf *Config) Encode(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(conf)
+}
+
 // LoadMusic reads the server's music file, returning it's contents.
 func LoadMusic() ([]string, error) {
 	var musicList []string
@@ -183,5 +466,89 @@ func LoadRoles() ([]permissions.Role, error) {
 	if len(conf.Role) == 0 {
 		return conf.Role, fmt.Errorf("empty rolelist")
 	}
+	err = permissions.CheckRoles(conf.Role)
+	if err != nil {
+		return conf.Role, err
+	}
 	return conf.Role, nil
 }
+
+// BanList is a shared ban list the server subscribes to.
+type BanList struct {
+	Name     string `toml:"name"`
+	Source   string `toml:"source"`
+	Interval string `toml:"refresh_interval"`
+}
+
+// LoadBanLists reads the server's ban list subscriptions, returning it's contents.
+// The file is optional; if it does not exist, no lists are returned.
+func LoadBanLists() ([]BanList, error) {
+	var conf struct {
+		BanList []BanList
+	}
+	_, err := toml.DecodeFile(ConfigPath+"/banlists.toml", &conf)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, l := range conf.BanList {
+		if l.Name == "" || l.Source == "" {
+			return nil, fmt.Errorf("ban list %v is missing a name or source", i+1)
+		} else if names[l.Name] {
+			return nil, fmt.Errorf("duplicate ban list name %v", l.Name)
+		}
+		names[l.Name] = true
+		if l.Interval == "" {
+			conf.BanList[i].Interval = "1h"
+		}
+	}
+	return conf.BanList, nil
+}
+
+// CommandConfig is the server's command configuration from commands.toml.
+type CommandConfig struct {
+	Disabled    []string                `toml:"disabled"`    // Built-in commands that are turned off.
+	Limits      map[string]CommandLimit `toml:"Limits"`      // How often commands can be used, overriding their defaults.
+	Permissions map[string]string       `toml:"Permissions"` // The permission required by a command, overriding its default.
+	Aliases     map[string]string       `toml:"Aliases"`     // Alternative names for commands.
+	Command     []CustomCommand         `toml:"Command"`
+}
+
+// CommandLimit limits how often a command can be used.
+type CommandLimit struct {
+	Cooldown     string `toml:"cooldown"`      // The time a user must wait between uses.
+	AreaCooldown string `toml:"area_cooldown"` // The time between uses in the same area, by anyone.
+	Daily        int    `toml:"daily"`         // The number of uses each user gets per day.
+}
+
+// CustomCommand is a command that replies with a fixed message.
+type CustomCommand struct {
+	Name       string `toml:"name"`
+	Response   string `toml:"response"`
+	Desc       string `toml:"desc"`
+	Permission string `toml:"permission"`
+}
+
+// LoadCommands reads the server's command configuration, returning it's contents.
+// The file is optional; if it does not exist, an empty configuration is returned.
+func LoadCommands() (CommandConfig, error) {
+	var conf CommandConfig
+	_, err := toml.DecodeFile(ConfigPath+"/commands.toml", &conf)
+	if errors.Is(err, fs.ErrNotExist) {
+		return CommandConfig{}, nil
+	} else if err != nil {
+		return CommandConfig{}, err
+	}
+	names := make(map[string]bool)
+	for i, c := range conf.Command {
+		if c.Name == "" || c.Response == "" {
+			return CommandConfig{}, fmt.Errorf("command %v is missing a name or response", i+1)
+		} else if names[c.Name] {
+			return CommandConfig{}, fmt.Errorf("duplicate command name %v", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return conf, nil
+}
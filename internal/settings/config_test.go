@@ -0,0 +1,91 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package settings
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	ConfigPath = t.TempDir()
+	toml := "[Server]\nport = 1000\nname = \"From File\"\n[Logging]\nlog_level = \"debug\"\n"
+	if err := os.WriteFile(ConfigPath+"/config.toml", []byte(toml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	secret := ConfigPath + "/webhook"
+	if err := os.WriteFile(secret, []byte("https://example.com/hook\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ATHENA_SERVER_PORT", "2000")
+	t.Setenv("ATHENA_SERVER_ENABLE_WEBAO", "true")
+	t.Setenv("ATHENA_LOGGING_LOG_METHODS", "stdout, log_file")
+	t.Setenv("ATHENA_MASTERSERVER_ADDR", "https://ms.example.com")
+	t.Setenv("ATHENA_SERVER_WEBHOOK_URL_FILE", secret)
+
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	RegisterFlags(fs)
+	defer func() { flagOverrides = map[string]string{} }()
+	if err := fs.Parse([]string{"-server.port", "3000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Port != 3000 {
+		t.Errorf("flag should override environment: got port %d, want %d", conf.Port, 3000)
+	}
+	if conf.Name != "From File" {
+		t.Errorf("config.toml should override defaults: got name %q, want %q", conf.Name, "From File")
+	}
+	if conf.LogLevel != "debug" || conf.MaxPlayers != 100 {
+		t.Errorf("unexpected untouched values: got %q and %d", conf.LogLevel, conf.MaxPlayers)
+	}
+	if !conf.EnableWS {
+		t.Errorf("environment should override defaults: got enable_webao %t, want %t", conf.EnableWS, true)
+	}
+	if !reflect.DeepEqual(conf.LogMethods, []string{"stdout", "log_file"}) {
+		t.Errorf("unexpected log methods: got %v", conf.LogMethods)
+	}
+	if conf.MSAddr != "https://ms.example.com" || conf.Addr != "" {
+		t.Errorf("sections should be overridden independently: got %q and %q", conf.MSAddr, conf.Addr)
+	}
+	if conf.WebhookURL != "https://example.com/hook" {
+		t.Errorf("unexpected webhook url from file: got %q", conf.WebhookURL)
+	}
+	if conf.WebhookFile != secret {
+		t.Errorf("ATHENA_SERVER_WEBHOOK_URL_FILE should set webhook_url_file: got %q", conf.WebhookFile)
+	}
+	conf.TLSKey = ConfigPath + "/key.pem"
+	conf.HostKey = ConfigPath + "/ssh_host_key"
+	r := conf.Redacted()
+	if r.WebhookURL == conf.WebhookURL || r.WebhookFile == conf.WebhookFile || r.TLSKey == conf.TLSKey || r.HostKey == conf.HostKey {
+		t.Errorf("redacted config exposes secrets: %+v", r.ServerConfig)
+	}
+	if r.TLSCert != "" {
+		t.Errorf("redacted config should leave empty values empty: got %q", r.TLSCert)
+	}
+
+	t.Setenv("ATHENA_SERVER_MAX_PLAYERS", "lots")
+	if _, err := GetConfig(); err == nil {
+		t.Errorf("expected an error for an invalid integer")
+	}
+}
@@ -0,0 +1,67 @@
+/* Athena - A server for Attorney Online 2 written in Go
+Copyright (C) 2022 MangosArentLiterature <mango@transmenace.dev>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>. */
+
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from RFC 6238, truncated to 6 digits.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tt := range []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{20000000000, "353130"},
+	} {
+		got, err := Code(secret, Step(time.Unix(tt.time, 0)))
+		if err != nil || got != tt.want {
+			t.Errorf("Code at %v = %v, %v; want %v", tt.time, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now))
+	if step, ok := Validate(secret, code, now); !ok || step != Step(now) {
+		t.Errorf("current code was rejected")
+	}
+	if _, ok := Validate(secret, code, now.Add(period*time.Second)); !ok {
+		t.Errorf("code from the previous period was rejected")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*period*time.Second)); ok {
+		t.Errorf("stale code was accepted")
+	}
+	if _, ok := Validate(secret, "000000x", now); ok {
+		t.Errorf("malformed code was accepted")
+	}
+	if uri := URI("My Server", "admin", secret); !strings.HasPrefix(uri, "otpauth://totp/My%20Server:admin?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected URI %v", uri)
+	}
+}